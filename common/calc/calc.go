@@ -0,0 +1,195 @@
+package calc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/kostyay/otel-demo/common/log"
+	"github.com/maja42/goval"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Mode selects how an expression is evaluated.
+type Mode int
+
+const (
+	// ModeFloat evaluates with float64 numbers.
+	ModeFloat Mode = iota
+	// ModeDecimal evaluates arithmetic exactly and rounds the result to a fixed number of decimal places.
+	ModeDecimal
+	// ModeUnits evaluates quantities with physical units, e.g. "5 km / 2 h".
+	ModeUnits
+)
+
+// Calculation is an expression together with everything needed to evaluate it.
+type Calculation struct {
+	Owner      string
+	Expression string
+	Variables  map[string]Value
+	Mode       Mode
+	// Decimal applies to ModeDecimal.
+	Decimal DecimalOptions
+	// OutputUnit is the unit a ModeUnits result is converted to, empty keeps the SI base units.
+	OutputUnit string
+}
+
+func lazinessFactor(ctx context.Context) {
+	ctx, span := otel.GetTracerProvider().Tracer("math").Start(ctx, "lazinessFactor")
+	defer span.End()
+
+	delay := rand.Intn(5) + 2
+	span.SetAttributes(attribute.Int("laziness", delay))
+	time.Sleep(time.Duration(delay) * time.Second)
+}
+
+// Evaluate evaluates the calculation expression within limits.
+// The span carried by ctx is annotated with the calculation details.
+func Evaluate(ctx context.Context, calculation *Calculation, limits Limits) (_ *Result, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("owner", calculation.Owner), attribute.String("expression", calculation.Expression))
+
+	if calculation.Owner == "slow" {
+		lazinessFactor(ctx)
+	}
+
+	span.SetAttributes(
+		attribute.Int("variables", len(calculation.Variables)),
+		attribute.String("functions.catalog_version", CatalogVersion),
+	)
+
+	defer func() {
+		var exprErr *ExpressionError
+		if errors.As(err, &exprErr) && exprErr.Limit != "" {
+			span.SetAttributes(attribute.String("expression.limit", exprErr.Limit))
+		}
+	}()
+
+	expr, err := Validate(calculation, limits)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range calculation.Variables {
+		if err := checkSize(v, limits); err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+	}
+	span.SetAttributes(attribute.Int("expression.nodes", expr.Nodes), attribute.Int("expression.depth", expr.Depth))
+
+	span.AddEvent("evaluating expression")
+	result, err := evaluateWithin(ctx, limits.Timeout, func(ctx context.Context) (*Result, error) {
+		switch calculation.Mode {
+		case ModeDecimal:
+			return evaluateDecimal(ctx, calculation, expr.Root)
+		case ModeUnits:
+			return evaluateUnits(ctx, calculation, expr.Root)
+		}
+		return evaluateFloat(ctx, calculation)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to evaluate expression: %w", err)
+	}
+	if err := checkSize(result.Value, limits); err != nil {
+		return nil, fmt.Errorf("result: %w", err)
+	}
+
+	log.WithContext(ctx).Infof("Result: %v", result.Value)
+
+	return result, nil
+}
+
+// evaluationSlots caps the evaluations running at once in the process. goval can't be interrupted, so an
+// evaluation that timed out keeps running in the background and keeps its slot until it actually finishes:
+// runaway expressions slow down the evaluations after them instead of piling up without bound. The decimal and
+// units evaluators check their context at every node and give their slot back soon after timing out.
+var evaluationSlots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// evaluateWithin runs evaluate in an evaluation slot until it finishes, timeout elapses or ctx is done.
+// Waiting for the slot counts towards the timeout.
+func evaluateWithin(ctx context.Context, timeout time.Duration, evaluate func(ctx context.Context) (*Result, error)) (*Result, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	slots := evaluationSlots
+	if err := acquireSlot(ctx, slots); err != nil {
+		return nil, limitError(err, timeout)
+	}
+
+	type outcome struct {
+		value *Result
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() { <-slots }()
+		value, err := evaluate(ctx)
+		done <- outcome{value: value, err: err}
+	}()
+
+	select {
+	case o := <-done:
+		if o.err == nil || ctx.Err() == nil {
+			return o.value, o.err
+		}
+	case <-ctx.Done():
+	}
+	return nil, limitError(ctx.Err(), timeout)
+}
+
+// acquireSlot takes a free slot right away if there is one, even when ctx is already done.
+func acquireSlot(ctx context.Context, slots chan struct{}) error {
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitError reports running out of time as exceeding the timeout limit.
+func limitError(err error, timeout time.Duration) error {
+	if timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+		return &ExpressionError{Limit: "timeout", Msg: fmt.Sprintf("evaluation took longer than %s", timeout)}
+	}
+	return err
+}
+
+func evaluateFloat(ctx context.Context, calculation *Calculation) (_ *Result, err error) {
+	// goval re-panics runtime errors, e.g. an integer division by zero.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	variables, err := Variables(calculation.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	eval := goval.NewEvaluator()
+	result, err := eval.Evaluate(calculation.Expression, variables, expressionFunctions(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := toValue(result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert result: %w", err)
+	}
+	return &Result{Value: value}, nil
+}
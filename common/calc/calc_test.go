@@ -0,0 +1,116 @@
+package calc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateFloat(t *testing.T) {
+	tests := []struct {
+		expression string
+		variables  map[string]Value
+		limits     Limits
+		want       Value
+		wantErr    string
+	}{
+		{expression: "1 + 2 * 3", want: int64(7)},
+		{expression: "7 / 2", want: int64(3)},
+		{expression: "7.0 / 2", want: 3.5},
+		{expression: `"a" + "b"`, want: "ab"},
+		{expression: "1 < 2 && !false", want: true},
+		{expression: "nil", want: nil},
+		{expression: "[1, 2.5, [true]]", want: []Value{int64(1), 2.5, []Value{true}}},
+		{expression: "x + y", variables: map[string]Value{"x": int64(1), "y": Decimal("0.5")}, want: 1.5},
+		{expression: "xs[1]", variables: map[string]Value{"xs": []Value{"a", "b"}}, want: "b"},
+		{expression: "1 / 0", wantErr: "integer divide by zero"},
+		{expression: "x", variables: map[string]Value{"x": nil}, wantErr: `variable "x": no value set`},
+		{expression: "x", variables: map[string]Value{"x": Decimal("one")}, wantErr: `"one" is not a decimal number`},
+		{expression: "1 +", wantErr: "unexpected end of expression"},
+		{expression: `s + s`, variables: map[string]Value{"s": "abc"}, limits: Limits{MaxStringLength: 5}, wantErr: "string is 6 bytes long"},
+		{expression: `s`, variables: map[string]Value{"s": "abcdef"}, limits: Limits{MaxStringLength: 5}, wantErr: `variable "s"`},
+		{expression: "[1, 2, 3]", limits: Limits{MaxListLength: 2}, wantErr: "list has 3 items"},
+		{expression: "[[1, 2, 3]]", limits: Limits{MaxListLength: 2}, wantErr: "index 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Evaluate(context.Background(), &Calculation{Expression: tt.expression, Variables: tt.variables}, tt.limits)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Evaluate() = %v, %v, want error %q", result, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			if !reflect.DeepEqual(result.Value, tt.want) {
+				t.Fatalf("Evaluate() = %#v, want %#v", result.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateWithinKeepsSlotOfTimedOutEvaluation(t *testing.T) {
+	slots := evaluationSlots
+	evaluationSlots = make(chan struct{}, 1)
+	defer func() { evaluationSlots = slots }()
+
+	// runaway ignores its context, like goval does.
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	runaway := func(context.Context) (*Result, error) {
+		defer close(finished)
+		<-release
+		return &Result{}, nil
+	}
+	quick := func(context.Context) (*Result, error) {
+		return &Result{Value: int64(1)}, nil
+	}
+
+	_, err := evaluateWithin(context.Background(), 10*time.Millisecond, runaway)
+	var exprErr *ExpressionError
+	if !errors.As(err, &exprErr) || exprErr.Limit != "timeout" {
+		t.Fatalf("evaluateWithin() = %v, want the timeout limit", err)
+	}
+
+	// Waiting for the slot counts towards the timeout.
+	_, err = evaluateWithin(context.Background(), 10*time.Millisecond, quick)
+	if !errors.As(err, &exprErr) || exprErr.Limit != "timeout" {
+		t.Fatalf("evaluateWithin() = %v while the runaway evaluation holds the slot, want the timeout limit", err)
+	}
+
+	close(release)
+	<-finished
+	deadline := time.Now().Add(time.Second)
+	for {
+		result, err := evaluateWithin(context.Background(), 10*time.Millisecond, quick)
+		if err == nil {
+			if result.Value != int64(1) {
+				t.Fatalf("evaluateWithin() = %v, want 1", result.Value)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("evaluateWithin() = %v after the runaway evaluation finished", err)
+		}
+	}
+}
+
+func TestEvaluateTimeout(t *testing.T) {
+	// The decimal evaluator checks its context at every node, so it stops as soon as it times out.
+	calculation := &Calculation{
+		Expression: "pow(3, 1000) * pow(3, 1000) * pow(3, 1000) * pow(3, 1000)",
+		Mode:       ModeDecimal,
+		Decimal:    DecimalOptions{Scale: 2},
+	}
+	_, err := Evaluate(context.Background(), calculation, Limits{Timeout: time.Nanosecond})
+	var exprErr *ExpressionError
+	if !errors.As(err, &exprErr) || exprErr.Limit != "timeout" {
+		t.Fatalf("Evaluate() = %v, want the timeout limit", err)
+	}
+}
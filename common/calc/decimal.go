@@ -0,0 +1,349 @@
+package calc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultDecimalScale = 10
+	MaxDecimalScale     = 100
+	// maxDecimalExponent bounds the exponents of pow() and number literals, so no number ends up with millions of digits.
+	maxDecimalExponent = 1000
+)
+
+// Rounding is how a decimal result is rounded to its scale. The values are numbered like the RoundingMode
+// of the calculator API, the zero value rounds half to even.
+type Rounding int32
+
+const (
+	RoundingHalfEven Rounding = iota + 1
+	RoundingHalfUp
+	RoundingHalfDown
+	// RoundingUp rounds away from zero.
+	RoundingUp
+	// RoundingDown rounds towards zero.
+	RoundingDown
+	RoundingCeiling
+	RoundingFloor
+)
+
+// DecimalOptions configures ModeDecimal.
+type DecimalOptions struct {
+	// Scale is the number of digits kept after the decimal point.
+	Scale    uint32
+	Rounding Rounding
+}
+
+type decimalFunction struct {
+	minArgs, maxArgs int
+	call             func(e *decimalEvaluator, args []*big.Rat) (*big.Rat, error)
+}
+
+var decimalFunctions = map[string]decimalFunction{
+	"abs": {1, 1, func(_ *decimalEvaluator, args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).Abs(args[0]), nil
+	}},
+	"min": {1, -1, func(_ *decimalEvaluator, args []*big.Rat) (*big.Rat, error) {
+		result := args[0]
+		for _, v := range args[1:] {
+			if v.Cmp(result) < 0 {
+				result = v
+			}
+		}
+		return result, nil
+	}},
+	"max": {1, -1, func(_ *decimalEvaluator, args []*big.Rat) (*big.Rat, error) {
+		result := args[0]
+		for _, v := range args[1:] {
+			if v.Cmp(result) > 0 {
+				result = v
+			}
+		}
+		return result, nil
+	}},
+	"floor": {1, 1, func(_ *decimalEvaluator, args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).SetInt(roundScaled(args[0], 0, RoundingFloor)), nil
+	}},
+	"ceil": {1, 1, func(_ *decimalEvaluator, args []*big.Rat) (*big.Rat, error) {
+		return new(big.Rat).SetInt(roundScaled(args[0], 0, RoundingCeiling)), nil
+	}},
+	// round uses the rounding mode of the calculation.
+	"round": {1, 2, func(e *decimalEvaluator, args []*big.Rat) (*big.Rat, error) {
+		places := int64(0)
+		if len(args) == 2 {
+			if !args[1].IsInt() || args[1].Sign() < 0 || args[1].Num().Int64() > MaxDecimalScale {
+				return nil, fmt.Errorf("places must be a whole number between 0 and %d", MaxDecimalScale)
+			}
+			places = args[1].Num().Int64()
+		}
+		scaled := roundScaled(args[0], uint32(places), e.rounding)
+		return new(big.Rat).SetFrac(scaled, pow10(uint32(places))), nil
+	}},
+	"pow": {2, 2, func(_ *decimalEvaluator, args []*big.Rat) (*big.Rat, error) {
+		if !args[1].IsInt() || args[1].Num().CmpAbs(big.NewInt(maxDecimalExponent)) > 0 {
+			return nil, fmt.Errorf("exponent must be a whole number between -%d and %d", maxDecimalExponent, maxDecimalExponent)
+		}
+		exp := args[1].Num().Int64()
+		if exp < 0 && args[0].Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		abs := big.NewInt(exp)
+		abs.Abs(abs)
+		num := new(big.Int).Exp(args[0].Num(), abs, nil)
+		denom := new(big.Int).Exp(args[0].Denom(), abs, nil)
+		if exp < 0 {
+			num, denom = denom, num
+		}
+		return new(big.Rat).SetFrac(num, denom), nil
+	}},
+}
+
+// decimalEvaluator evaluates arithmetic exactly using rational numbers, only the final result is rounded.
+type decimalEvaluator struct {
+	ctx       context.Context
+	variables map[string]*big.Rat
+	rounding  Rounding
+}
+
+func evaluateDecimal(ctx context.Context, calculation *Calculation, root *Node) (*Result, error) {
+	opts := calculation.Decimal
+	if opts.Scale > MaxDecimalScale {
+		return nil, fmt.Errorf("scale must not exceed %d", MaxDecimalScale)
+	}
+
+	variables, err := decimalVariables(calculation.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &decimalEvaluator{ctx: ctx, variables: variables, rounding: opts.Rounding}
+	result, err := e.eval(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Value: Decimal(formatDecimal(result, opts.Scale, opts.Rounding))}, nil
+}
+
+func (e *decimalEvaluator) eval(n *Node) (*big.Rat, error) {
+	if err := e.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	switch n.Kind {
+	case NodeNumber:
+		return parseNumber(n.Value)
+	case NodeVariable:
+		v, ok := e.variables[n.Value]
+		if !ok {
+			return nil, fmt.Errorf("var error: variable %q does not exist", n.Value)
+		}
+		return v, nil
+	case NodeUnary:
+		if n.Value != "-" {
+			break
+		}
+		x, err := e.eval(n.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).Neg(x), nil
+	case NodeBinary:
+		return e.binary(n)
+	case NodeCall:
+		return e.call(n)
+	}
+	return nil, &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("decimal mode does not support %s %q", n.Kind, n.Value)}
+}
+
+func (e *decimalEvaluator) binary(n *Node) (*big.Rat, error) {
+	x, err := e.eval(n.Children[0])
+	if err != nil {
+		return nil, err
+	}
+	y, err := e.eval(n.Children[1])
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Value {
+	case "+":
+		return new(big.Rat).Add(x, y), nil
+	case "-":
+		return new(big.Rat).Sub(x, y), nil
+	case "*":
+		return new(big.Rat).Mul(x, y), nil
+	case "/":
+		if y.Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return new(big.Rat).Quo(x, y), nil
+	case "%":
+		if y.Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		// x - y * trunc(x / y), matching the sign of x like Go's integer remainder.
+		q := new(big.Rat).Quo(x, y)
+		trunc := new(big.Int).Quo(q.Num(), q.Denom())
+		return new(big.Rat).Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(trunc))), nil
+	}
+	return nil, &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("decimal mode does not support operator %q", n.Value)}
+}
+
+func (e *decimalEvaluator) call(n *Node) (*big.Rat, error) {
+	fn, ok := decimalFunctions[n.Value]
+	if !ok {
+		return nil, &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("no such function %q in decimal mode", n.Value)}
+	}
+
+	args := make([]*big.Rat, 0, len(n.Children))
+	for _, arg := range n.Children {
+		v, err := e.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	return traced(e.ctx, n.Value, len(args), func() (*big.Rat, error) {
+		if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+			return nil, fmt.Errorf("%s: wrong number of arguments %d", n.Value, len(args))
+		}
+		result, err := fn.call(e, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n.Value, err)
+		}
+		return result, nil
+	})
+}
+
+// parseNumber parses a number literal, which unlike in float mode may be of any size.
+func parseNumber(lit string) (*big.Rat, error) {
+	if hex := strings.TrimPrefix(lit, "0x"); len(hex) < len(lit) {
+		i, ok := new(big.Int).SetString(hex, 16)
+		if !ok {
+			return nil, fmt.Errorf("parse error: cannot parse integer %s", lit)
+		}
+		return new(big.Rat).SetInt(i), nil
+	}
+	return parseDecimal(lit)
+}
+
+func parseDecimal(s string) (*big.Rat, error) {
+	if strings.Contains(s, "/") {
+		return nil, fmt.Errorf("parse error: %q is not a decimal number", s)
+	}
+	// big.Rat expands exponents eagerly, so "1e999999999" would take forever.
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil || exp > maxDecimalExponent || exp < -maxDecimalExponent {
+			return nil, fmt.Errorf("parse error: exponent of %q must be between -%d and %d", s, maxDecimalExponent, maxDecimalExponent)
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("parse error: %q is not a decimal number", s)
+	}
+	return r, nil
+}
+
+func decimalVariables(vars map[string]Value) (map[string]*big.Rat, error) {
+	result := make(map[string]*big.Rat, len(vars))
+	for name, v := range vars {
+		switch v := v.(type) {
+		case int64:
+			result[name] = new(big.Rat).SetInt64(v)
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("variable %q: not a finite number", name)
+			}
+			// Use the shortest representation, so 0.1 means exactly 1/10.
+			r, err := parseDecimal(strconv.FormatFloat(v, 'g', -1, 64))
+			if err != nil {
+				return nil, fmt.Errorf("variable %q: %w", name, err)
+			}
+			result[name] = r
+		case Decimal:
+			r, err := parseDecimal(string(v))
+			if err != nil {
+				return nil, fmt.Errorf("variable %q: %w", name, err)
+			}
+			result[name] = r
+		default:
+			return nil, fmt.Errorf("variable %q: decimal mode only supports numeric variables", name)
+		}
+	}
+	return result, nil
+}
+
+func pow10(n uint32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// roundScaled returns r * 10^scale rounded to an integer using mode.
+func roundScaled(r *big.Rat, scale uint32, mode Rounding) *big.Int {
+	num := new(big.Int).Mul(r.Num(), pow10(scale))
+	q, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Sign() == 0 {
+		return q
+	}
+
+	negative := r.Sign() < 0
+	var away bool
+	switch mode {
+	case RoundingUp:
+		away = true
+	case RoundingDown:
+		away = false
+	case RoundingCeiling:
+		away = !negative
+	case RoundingFloor:
+		away = negative
+	default:
+		half := new(big.Int).Mul(rem.Abs(rem), big.NewInt(2)).Cmp(r.Denom())
+		switch {
+		case half > 0:
+			away = true
+		case half < 0:
+			away = false
+		case mode == RoundingHalfUp:
+			away = true
+		case mode == RoundingHalfDown:
+			away = false
+		default:
+			away = q.Bit(0) == 1
+		}
+	}
+
+	if away {
+		if negative {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// formatDecimal renders r with exactly scale digits after the decimal point.
+func formatDecimal(r *big.Rat, scale uint32, mode Rounding) string {
+	scaled := roundScaled(r, scale, mode)
+	digits := new(big.Int).Abs(scaled).String()
+
+	if scale > 0 {
+		if pad := int(scale) + 1 - len(digits); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+		digits = digits[:len(digits)-int(scale)] + "." + digits[len(digits)-int(scale):]
+	}
+	if scaled.Sign() < 0 {
+		digits = "-" + digits
+	}
+	return digits
+}
@@ -0,0 +1,113 @@
+package calc
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDecimalRounding(t *testing.T) {
+	modes := []struct {
+		rounding Rounding
+		name     string
+	}{
+		{0, "unspecified"},
+		{RoundingHalfEven, "half even"},
+		{RoundingHalfUp, "half up"},
+		{RoundingHalfDown, "half down"},
+		{RoundingUp, "up"},
+		{RoundingDown, "down"},
+		{RoundingCeiling, "ceiling"},
+		{RoundingFloor, "floor"},
+	}
+	tests := []struct {
+		value Decimal
+		scale uint32
+		// want is indexed like modes.
+		want [8]string
+	}{
+		{value: "2.5", want: [8]string{"2", "2", "3", "2", "3", "2", "3", "2"}},
+		{value: "3.5", want: [8]string{"4", "4", "4", "3", "4", "3", "4", "3"}},
+		{value: "-2.5", want: [8]string{"-2", "-2", "-3", "-2", "-3", "-2", "-2", "-3"}},
+		{value: "2.51", want: [8]string{"3", "3", "3", "3", "3", "2", "3", "2"}},
+		{value: "-2.49", want: [8]string{"-2", "-2", "-2", "-2", "-3", "-2", "-2", "-3"}},
+		{value: "1.25", scale: 1, want: [8]string{"1.2", "1.2", "1.3", "1.2", "1.3", "1.2", "1.3", "1.2"}},
+		{value: "7", scale: 2, want: [8]string{"7.00", "7.00", "7.00", "7.00", "7.00", "7.00", "7.00", "7.00"}},
+		{value: "-0.001", scale: 2, want: [8]string{"0.00", "0.00", "0.00", "0.00", "-0.01", "0.00", "0.00", "-0.01"}},
+	}
+
+	for _, tt := range tests {
+		for i, mode := range modes {
+			t.Run(string(tt.value)+" "+mode.name, func(t *testing.T) {
+				calculation := &Calculation{
+					Expression: "x",
+					Variables:  map[string]Value{"x": tt.value},
+					Mode:       ModeDecimal,
+					Decimal:    DecimalOptions{Scale: tt.scale, Rounding: mode.rounding},
+				}
+				result, err := Evaluate(context.Background(), calculation, Limits{})
+				if err != nil {
+					t.Fatalf("Evaluate() = %v", err)
+				}
+				if result.Value != Decimal(tt.want[i]) {
+					t.Fatalf("Evaluate() = %v, want %s", result.Value, tt.want[i])
+				}
+			})
+		}
+	}
+}
+
+func TestEvaluateDecimal(t *testing.T) {
+	tests := []struct {
+		expression string
+		variables  map[string]Value
+		decimal    DecimalOptions
+		want       Decimal
+		wantErr    string
+	}{
+		{expression: "0.1 + 0.2", decimal: DecimalOptions{Scale: 2}, want: "0.30"},
+		{expression: "1 / 3", decimal: DecimalOptions{Scale: 5}, want: "0.33333"},
+		{expression: "2 / 3", decimal: DecimalOptions{Scale: 2, Rounding: RoundingDown}, want: "0.66"},
+		{expression: "(1 / 3) * 3", want: "1"},
+		{expression: "x * 3", variables: map[string]Value{"x": 0.1}, decimal: DecimalOptions{Scale: 1}, want: "0.3"},
+		{expression: "x + y", variables: map[string]Value{"x": int64(1), "y": Decimal("0.5")}, decimal: DecimalOptions{Scale: 1}, want: "1.5"},
+		{expression: "-7 % 3", want: "-1"},
+		{expression: "1e-3 * 1000", want: "1"},
+		{expression: "0x10", want: "16"},
+		{expression: "round(2.345, 2)", decimal: DecimalOptions{Scale: 3, Rounding: RoundingHalfUp}, want: "2.350"},
+		{expression: "round(2.345, 2)", decimal: DecimalOptions{Scale: 3}, want: "2.340"},
+		{expression: "floor(-1.5) + ceil(1.2)", want: "0"},
+		{expression: "pow(2, -2)", decimal: DecimalOptions{Scale: 2}, want: "0.25"},
+		{expression: "abs(-3) + min(4, 2, 3) + max(1, 5)", want: "10"},
+		{expression: "99999999999999999999 + 1", want: "100000000000000000000"},
+		{expression: "1 / 0", wantErr: "division by zero"},
+		{expression: "1 % 0", wantErr: "division by zero"},
+		{expression: "pow(0, -1)", wantErr: "division by zero"},
+		{expression: "pow(2, 1001)", wantErr: "exponent must be a whole number"},
+		{expression: "1e1001", wantErr: "exponent"},
+		{expression: "round(1, 101)", wantErr: "places must be a whole number"},
+		{expression: "1 < 2", wantErr: `does not support operator "<"`},
+		{expression: "x", variables: map[string]Value{"x": "one"}, wantErr: "only supports numeric variables"},
+		{expression: "x", variables: map[string]Value{"x": Decimal("1.2.3")}, wantErr: "not a decimal number"},
+		{expression: "1", decimal: DecimalOptions{Scale: MaxDecimalScale + 1}, wantErr: "scale must not exceed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			calculation := &Calculation{Expression: tt.expression, Variables: tt.variables, Mode: ModeDecimal, Decimal: tt.decimal}
+			result, err := Evaluate(context.Background(), calculation, Limits{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Evaluate() = %v, %v, want error %q", result, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			if result.Value != tt.want {
+				t.Fatalf("Evaluate() = %v, want %s", result.Value, tt.want)
+			}
+		})
+	}
+}
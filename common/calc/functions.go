@@ -0,0 +1,134 @@
+package calc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	"github.com/maja42/goval"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// CatalogVersion identifies the set of functions available to expressions.
+// Bump it whenever a function is added, removed or changes behaviour.
+const CatalogVersion = "1"
+
+// Function is a numeric function that can be called from within expressions.
+type Function struct {
+	Name        string
+	Signature   string
+	Description string
+	MinArgs     int
+	// MaxArgs is negative for variadic functions.
+	MaxArgs int
+	Call    func(args ...float64) (float64, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Function{}
+)
+
+// Register adds fn to the functions available to expressions.
+func Register(fn Function) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[fn.Name]; ok {
+		return fmt.Errorf("function %q is already registered", fn.Name)
+	}
+	registry[fn.Name] = fn
+	return nil
+}
+
+// Functions returns the registered functions sorted by name.
+func Functions() []Function {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	result := make([]Function, 0, len(registry))
+	for _, fn := range registry {
+		result = append(result, fn)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+var (
+	functionCalls, _    = otelcommon.Meter().Int64Counter("calc.function.calls", metric.WithDescription("Number of expression function calls"))
+	functionDuration, _ = otelcommon.Meter().Float64Histogram("calc.function.duration", metric.WithDescription("Duration of expression function calls"), metric.WithUnit("ms"))
+)
+
+// expressionFunctions binds the registry to ctx, so every call gets its own span and metrics.
+func expressionFunctions(ctx context.Context) map[string]goval.ExpressionFunction {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	result := make(map[string]goval.ExpressionFunction, len(registry))
+	for name, fn := range registry {
+		fn := fn
+		result[name] = func(args ...interface{}) (interface{}, error) {
+			return call(ctx, fn, args)
+		}
+	}
+	return result
+}
+
+func call(ctx context.Context, fn Function, args []interface{}) (interface{}, error) {
+	return traced(ctx, fn.Name, len(args), func() (interface{}, error) {
+		if len(args) < fn.MinArgs || (fn.MaxArgs >= 0 && len(args) > fn.MaxArgs) {
+			return nil, fmt.Errorf("%s expects %s", fn.Name, fn.Signature)
+		}
+
+		numbers := make([]float64, len(args))
+		for i, arg := range args {
+			switch v := arg.(type) {
+			case int:
+				numbers[i] = float64(v)
+			case float64:
+				numbers[i] = v
+			default:
+				return nil, fmt.Errorf("%s: argument %d must be a number, but was %T", fn.Name, i+1, arg)
+			}
+		}
+
+		value, err := fn.Call(numbers...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name, err)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, fmt.Errorf("%s: result is not a finite number", fn.Name)
+		}
+		return value, nil
+	})
+}
+
+// traced runs a single expression function call in its own span and records its metrics.
+func traced[T any](ctx context.Context, name string, args int, f func() (T, error)) (result T, err error) {
+	_, span := otelcommon.Tracer().Start(ctx, "function "+name)
+	span.SetAttributes(attribute.String("function.name", name), attribute.Int("function.args", args))
+	start := time.Now()
+
+	defer func() {
+		attrs := metric.WithAttributes(attribute.String("function", name), attribute.Bool("error", err != nil))
+		functionCalls.Add(ctx, 1, attrs)
+		functionDuration.Record(ctx, float64(time.Since(start))/float64(time.Millisecond), attrs)
+
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return f()
+}
@@ -0,0 +1,81 @@
+package calc
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFunctionCatalog(t *testing.T) {
+	// Changing the catalog changes results, bump CatalogVersion together with this list.
+	want := []string{
+		"abs", "acos", "asin", "atan", "atan2", "ceil", "cos", "exp", "floor",
+		"log", "log10", "max", "min", "pow", "round", "sin", "sqrt", "tan",
+	}
+
+	var got []string
+	for _, fn := range Functions() {
+		got = append(got, fn.Name)
+		if fn.Signature == "" || fn.Description == "" {
+			t.Errorf("function %q is not documented", fn.Name)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Functions() = %v, want %v", got, want)
+	}
+
+	if err := Register(Function{Name: "abs"}); err == nil {
+		t.Fatal("Register() accepted a second abs")
+	}
+}
+
+func TestStandardLibrary(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+		wantErr    string
+	}{
+		{expression: "abs(-2)", want: 2},
+		{expression: "ceil(1.2) + floor(1.8)", want: 3},
+		{expression: "round(2.5) + round(-2.5)", want: 0},
+		{expression: "sqrt(16)", want: 4},
+		{expression: "pow(2, 10)", want: 1024},
+		{expression: "exp(0)", want: 1},
+		{expression: "log(exp(2))", want: 2},
+		{expression: "log(8, 2)", want: 3},
+		{expression: "log10(1000)", want: 3},
+		{expression: "min(3, 1.5, 2)", want: 1.5},
+		{expression: "max(3, 1.5, 2)", want: 3},
+		{expression: "sin(0) + cos(0) + tan(0)", want: 1},
+		{expression: "asin(1) * 2", want: math.Pi},
+		{expression: "acos(1) + atan(0)", want: 0},
+		{expression: "atan2(1, -1)", want: 3 * math.Pi / 4},
+		{expression: "sqrt(-1)", wantErr: "sqrt: x must not be negative"},
+		{expression: "log(0)", wantErr: "log: x must be positive"},
+		{expression: "log(2, 1)", wantErr: "log: base must be positive and not 1"},
+		{expression: "pow(0, -1)", wantErr: "pow: result is not a finite number"},
+		{expression: "abs()", wantErr: "abs expects abs(x)"},
+		{expression: "atan2(1)", wantErr: "atan2 expects atan2(y, x)"},
+		{expression: `abs("x")`, wantErr: "abs: argument 1 must be a number, but was string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := Evaluate(context.Background(), &Calculation{Expression: tt.expression}, Limits{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Evaluate() = %v, %v, want error %q", result, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			if value, ok := result.Value.(float64); !ok || math.Abs(value-tt.want) > 1e-12 {
+				t.Fatalf("Evaluate() = %v, want %v", result.Value, tt.want)
+			}
+		})
+	}
+}
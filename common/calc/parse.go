@@ -0,0 +1,659 @@
+package calc
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxParseDepth protects the parser's stack regardless of the configured limits.
+const maxParseDepth = 1000
+
+// NodeKind is the kind of an expression node, numbered like the ExpressionNodeKind of the calculator API.
+type NodeKind int
+
+const (
+	NodeNumber NodeKind = iota + 1
+	NodeString
+	NodeBool
+	NodeNil
+	NodeVariable
+	NodeUnary
+	NodeBinary
+	NodeTernary
+	NodeCall
+	NodeField
+	NodeIndex
+	NodeSlice
+	NodeArray
+	NodeObject
+)
+
+var nodeKindNames = map[NodeKind]string{
+	NodeNumber:   "number",
+	NodeString:   "string",
+	NodeBool:     "bool",
+	NodeNil:      "nil",
+	NodeVariable: "variable",
+	NodeUnary:    "unary operator",
+	NodeBinary:   "binary operator",
+	NodeTernary:  "ternary operator",
+	NodeCall:     "function call",
+	NodeField:    "field access",
+	NodeIndex:    "index",
+	NodeSlice:    "slice",
+	NodeArray:    "array",
+	NodeObject:   "object",
+}
+
+func (k NodeKind) String() string {
+	return nodeKindNames[k]
+}
+
+// Node is a node of a parsed expression.
+type Node struct {
+	Kind NodeKind
+	// Value is the literal as written, the variable, function or field name, or the operator.
+	Value string
+	// Column is the 1-based byte offset the node starts at.
+	Column int
+	// Children are the operands in source order. Object children alternate between keys and values,
+	// missing slice bounds are nil literals.
+	Children []*Node
+}
+
+// String renders n fully parenthesized, so equivalent spellings of an expression render the same.
+func (n *Node) String() string {
+	var b strings.Builder
+	n.render(&b)
+	return b.String()
+}
+
+func (n *Node) render(b *strings.Builder) {
+	switch n.Kind {
+	case NodeUnary:
+		b.WriteString("(" + n.Value)
+		n.Children[0].render(b)
+		b.WriteString(")")
+	case NodeBinary:
+		op := n.Value
+		if op == "in" {
+			op = " in "
+		}
+		b.WriteString("(")
+		n.Children[0].render(b)
+		b.WriteString(op)
+		n.Children[1].render(b)
+		b.WriteString(")")
+	case NodeTernary:
+		b.WriteString("(")
+		n.Children[0].render(b)
+		b.WriteString("?")
+		n.Children[1].render(b)
+		b.WriteString(":")
+		n.Children[2].render(b)
+		b.WriteString(")")
+	case NodeCall:
+		b.WriteString(n.Value)
+		renderList(b, "(", n.Children, ",", ")")
+	case NodeField:
+		n.Children[0].render(b)
+		b.WriteString("." + n.Value)
+	case NodeIndex:
+		n.Children[0].render(b)
+		renderList(b, "[", n.Children[1:], "", "]")
+	case NodeSlice:
+		n.Children[0].render(b)
+		renderList(b, "[", n.Children[1:], ":", "]")
+	case NodeArray:
+		renderList(b, "[", n.Children, ",", "]")
+	case NodeObject:
+		b.WriteString("{")
+		for i := 0; i+1 < len(n.Children); i += 2 {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			n.Children[i].render(b)
+			b.WriteString(":")
+			n.Children[i+1].render(b)
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(n.Value)
+	}
+}
+
+func renderList(b *strings.Builder, open string, nodes []*Node, sep, close string) {
+	b.WriteString(open)
+	for i, node := range nodes {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+		node.render(b)
+	}
+	b.WriteString(close)
+}
+
+// walk calls fn for n and all of its descendants, stopping at the first error.
+func (n *Node) walk(fn func(*Node) error) error {
+	if err := fn(n); err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		if err := child.walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpressionError reports an expression that can't be evaluated as given: a syntax error,
+// a reference to something that doesn't exist or an exceeded limit.
+type ExpressionError struct {
+	Msg string
+	// Column is the 1-based byte offset of the problem, zero when it concerns the whole expression.
+	Column int
+	// Limit names the exceeded limit, if any.
+	Limit string
+}
+
+func (e *ExpressionError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+	}
+	return e.Msg
+}
+
+type lexeme struct {
+	tok    token.Token
+	lit    string
+	column int
+}
+
+// op returns the operator the lexeme stands for, if any.
+func (l lexeme) op() string {
+	switch {
+	case l.tok == token.IDENT && (l.lit == "in" || l.lit == "IN"):
+		return "in"
+	case l.tok == token.ILLEGAL && l.lit == "?":
+		return "?"
+	case l.tok.IsOperator():
+		return l.tok.String()
+	}
+	return ""
+}
+
+func (l lexeme) String() string {
+	if l.tok == token.EOF {
+		return "end of expression"
+	}
+	if l.lit != "" {
+		return strconv.Quote(l.lit)
+	}
+	return strconv.Quote(l.tok.String())
+}
+
+// scan tokenizes expression the same way goval does.
+func scan(expression string) []lexeme {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(expression))
+
+	var s scanner.Scanner
+	s.Init(file, []byte(expression), nil, 0)
+
+	var result []lexeme
+	for {
+		pos, tok, lit := s.Scan()
+		column := file.Offset(pos) + 1
+		switch {
+		case tok == token.SEMICOLON && lit == "\n":
+			continue
+		case tok == token.EOF:
+			return append(result, lexeme{tok: tok, column: column})
+		case tok.IsKeyword():
+			tok = token.IDENT
+		case tok == token.ARROW:
+			// "a<-b" is a comparison against a negative number.
+			result = append(result, lexeme{tok: token.LSS, column: column}, lexeme{tok: token.SUB, column: column + 1})
+			continue
+		}
+		result = append(result, lexeme{tok: tok, lit: lit, column: column})
+	}
+}
+
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+	"in": 12,
+}
+
+// unaryPrecedence sits between the arithmetic operators and "in", so "-a in b" is "-(a in b)".
+const unaryPrecedence = 11
+
+// powerPrecedence is the precedence of "^" in units mode, where it raises to a power and binds tightest.
+const powerPrecedence = 13
+
+type parser struct {
+	lexemes []lexeme
+	pos     int
+	depth   int
+	// units enables the units mode grammar: "^" is a right associative power operator and
+	// a number followed by a unit, as in "5 km", is a multiplication.
+	units bool
+}
+
+func (p *parser) peek() lexeme {
+	return p.lexemes[p.pos]
+}
+
+func (p *parser) next() lexeme {
+	l := p.lexemes[p.pos]
+	if l.tok != token.EOF {
+		p.pos++
+	}
+	return l
+}
+
+func (p *parser) expect(tok token.Token) error {
+	if l := p.next(); l.tok != tok {
+		return p.unexpected(l)
+	}
+	return nil
+}
+
+func (p *parser) unexpected(l lexeme) error {
+	return &ExpressionError{Column: l.column, Msg: fmt.Sprintf("unexpected %s", l)}
+}
+
+// Parse parses expression using the goval grammar, extended with quantities in units mode.
+func Parse(expression string, mode Mode) (*Node, error) {
+	p := &parser{lexemes: scan(expression), units: mode == ModeUnits}
+	root, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if l := p.next(); l.tok != token.EOF {
+		return nil, p.unexpected(l)
+	}
+	return root, nil
+}
+
+func (p *parser) expr(minPrecedence int) (*Node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxParseDepth {
+		return nil, &ExpressionError{Column: p.peek().column, Msg: "expression is nested too deeply"}
+	}
+
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek().op()
+		if op == "?" && minPrecedence == 0 {
+			p.next()
+			then, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(token.COLON); err != nil {
+				return nil, err
+			}
+			otherwise, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			left = &Node{Kind: NodeTernary, Value: "?:", Column: left.Column, Children: []*Node{left, then, otherwise}}
+			continue
+		}
+
+		precedence, ok := binaryPrecedence[op]
+		next := precedence + 1
+		if p.units && op == "^" {
+			precedence, next = powerPrecedence, powerPrecedence
+		}
+		if !ok || precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		right, err := p.expr(next)
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Kind: NodeBinary, Value: op, Column: left.Column, Children: []*Node{left, right}}
+	}
+}
+
+func (p *parser) unary() (*Node, error) {
+	l := p.peek()
+	switch l.tok {
+	case token.SUB, token.NOT, token.TILDE:
+		p.next()
+		operand, err := p.expr(unaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: NodeUnary, Value: l.tok.String(), Column: l.column, Children: []*Node{operand}}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (*Node, error) {
+	node, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek().tok {
+		case token.PERIOD:
+			p.next()
+			name := p.next()
+			if name.tok != token.IDENT {
+				return nil, p.unexpected(name)
+			}
+			node = &Node{Kind: NodeField, Value: name.lit, Column: node.Column, Children: []*Node{node}}
+		case token.LBRACK:
+			if node, err = p.index(node); err != nil {
+				return nil, err
+			}
+		case token.IDENT:
+			// In units mode an operand followed by a unit multiplies them, e.g. "5 km" or "x m/s".
+			if !p.units || p.peek().op() != "" {
+				return node, nil
+			}
+			unit, err := p.expr(powerPrecedence)
+			if err != nil {
+				return nil, err
+			}
+			node = &Node{Kind: NodeBinary, Value: "*", Column: node.Column, Children: []*Node{node, unit}}
+		default:
+			return node, nil
+		}
+	}
+}
+
+// index parses x[i], x[lo:hi] and its variants with missing bounds.
+func (p *parser) index(x *Node) (*Node, error) {
+	p.next()
+	missing := func() *Node { return &Node{Kind: NodeNil, Column: p.peek().column} }
+
+	var low *Node
+	if p.peek().tok == token.COLON {
+		low = missing()
+	} else {
+		var err error
+		if low, err = p.expr(0); err != nil {
+			return nil, err
+		}
+		if p.peek().tok == token.RBRACK {
+			p.next()
+			return &Node{Kind: NodeIndex, Value: "[]", Column: x.Column, Children: []*Node{x, low}}, nil
+		}
+	}
+
+	if err := p.expect(token.COLON); err != nil {
+		return nil, err
+	}
+	high := missing()
+	if p.peek().tok != token.RBRACK {
+		var err error
+		if high, err = p.expr(0); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(token.RBRACK); err != nil {
+		return nil, err
+	}
+	return &Node{Kind: NodeSlice, Value: "[:]", Column: x.Column, Children: []*Node{x, low, high}}, nil
+}
+
+func (p *parser) primary() (*Node, error) {
+	l := p.next()
+	switch l.tok {
+	case token.INT, token.FLOAT:
+		return &Node{Kind: NodeNumber, Value: l.lit, Column: l.column}, nil
+	case token.STRING:
+		if _, err := strconv.Unquote(l.lit); err != nil {
+			return nil, &ExpressionError{Column: l.column, Msg: "cannot unquote string literal"}
+		}
+		return &Node{Kind: NodeString, Value: l.lit, Column: l.column}, nil
+	case token.IDENT:
+		switch l.lit {
+		case "nil":
+			return &Node{Kind: NodeNil, Value: l.lit, Column: l.column}, nil
+		case "true", "false":
+			return &Node{Kind: NodeBool, Value: l.lit, Column: l.column}, nil
+		case "in", "IN":
+			return nil, p.unexpected(l)
+		}
+		if p.peek().tok != token.LPAREN {
+			return &Node{Kind: NodeVariable, Value: l.lit, Column: l.column}, nil
+		}
+		p.next()
+		args, err := p.list(token.RPAREN)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: NodeCall, Value: l.lit, Column: l.column, Children: args}, nil
+	case token.LPAREN:
+		node, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(token.RPAREN); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case token.LBRACK:
+		items, err := p.list(token.RBRACK)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: NodeArray, Value: "[]", Column: l.column, Children: items}, nil
+	case token.LBRACE:
+		return p.object(l)
+	}
+	return nil, p.unexpected(l)
+}
+
+// list parses comma separated expressions up to and including end.
+func (p *parser) list(end token.Token) ([]*Node, error) {
+	var result []*Node
+	if p.peek().tok == end {
+		p.next()
+		return result, nil
+	}
+	for {
+		node, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, node)
+
+		l := p.next()
+		switch l.tok {
+		case end:
+			return result, nil
+		case token.COMMA:
+		default:
+			return nil, p.unexpected(l)
+		}
+	}
+}
+
+func (p *parser) object(open lexeme) (*Node, error) {
+	node := &Node{Kind: NodeObject, Value: "{}", Column: open.column}
+	if p.peek().tok == token.RBRACE {
+		p.next()
+		return node, nil
+	}
+	for {
+		key, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(token.COLON); err != nil {
+			return nil, err
+		}
+		value, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, key, value)
+
+		l := p.next()
+		switch l.tok {
+		case token.RBRACE:
+			return node, nil
+		case token.COMMA:
+		default:
+			return nil, p.unexpected(l)
+		}
+	}
+}
+
+// Expression is a parsed expression together with what static analysis found out about it.
+type Expression struct {
+	Root *Node
+	// Variables and Functions are the names referenced by the expression, sorted.
+	Variables []string
+	Functions []string
+	Nodes     int
+	Depth     int
+	Operators int
+}
+
+func analyze(root *Node) *Expression {
+	result := &Expression{Root: root}
+	variables := map[string]bool{}
+	functions := map[string]bool{}
+
+	var visit func(n *Node, depth int)
+	visit = func(n *Node, depth int) {
+		result.Nodes++
+		if depth > result.Depth {
+			result.Depth = depth
+		}
+		switch n.Kind {
+		case NodeUnary, NodeBinary, NodeTernary:
+			result.Operators++
+		case NodeVariable:
+			variables[n.Value] = true
+		case NodeCall:
+			functions[n.Value] = true
+		}
+		for _, child := range n.Children {
+			visit(child, depth+1)
+		}
+	}
+	visit(root, 1)
+
+	result.Variables = sortedKeys(variables)
+	result.Functions = sortedKeys(functions)
+	return result
+}
+
+func sortedKeys(m map[string]bool) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Limits bounds the size of an expression and the resources evaluating it may use. Zero means unlimited.
+type Limits struct {
+	MaxLength    int
+	MaxDepth     int
+	MaxOperators int
+	MaxNodes     int
+	// MaxStringLength and MaxListLength apply to variables and results.
+	MaxStringLength int
+	MaxListLength   int
+	Timeout         time.Duration
+}
+
+// Analyze parses expression for mode and checks it against limits.
+func Analyze(expression string, mode Mode, limits Limits) (*Expression, error) {
+	if limits.MaxLength > 0 && len(expression) > limits.MaxLength {
+		return nil, &ExpressionError{Limit: "max_length", Msg: fmt.Sprintf("expression is %d bytes long, the limit is %d", len(expression), limits.MaxLength)}
+	}
+
+	root, err := Parse(expression, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	result := analyze(root)
+	if limits.MaxDepth > 0 && result.Depth > limits.MaxDepth {
+		return nil, &ExpressionError{Limit: "max_depth", Msg: fmt.Sprintf("expression is nested %d levels deep, the limit is %d", result.Depth, limits.MaxDepth)}
+	}
+	if limits.MaxNodes > 0 && result.Nodes > limits.MaxNodes {
+		return nil, &ExpressionError{Limit: "max_nodes", Msg: fmt.Sprintf("expression has %d nodes, the limit is %d", result.Nodes, limits.MaxNodes)}
+	}
+	if limits.MaxOperators > 0 && result.Operators > limits.MaxOperators {
+		return nil, &ExpressionError{Limit: "max_operators", Msg: fmt.Sprintf("expression has %d operators, the limit is %d", result.Operators, limits.MaxOperators)}
+	}
+	return result, nil
+}
+
+// Validate statically checks a calculation without evaluating it: the expression must be within limits
+// and may only reference the variables of the calculation and the functions of its evaluation mode.
+func Validate(calculation *Calculation, limits Limits) (*Expression, error) {
+	result, err := Analyze(calculation.Expression, calculation.Mode, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	err = result.Root.walk(func(n *Node) error {
+		switch n.Kind {
+		case NodeVariable:
+			if _, ok := calculation.Variables[n.Value]; ok {
+				break
+			}
+			if calculation.Mode != ModeUnits {
+				return &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("variable %q is not defined", n.Value)}
+			}
+			if _, ok := units[n.Value]; !ok {
+				return &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("%q is neither a variable nor a known unit", n.Value)}
+			}
+		case NodeCall:
+			if !hasFunction(calculation.Mode, n.Value) {
+				return &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("function %q does not exist", n.Value)}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func hasFunction(mode Mode, name string) bool {
+	if mode == ModeDecimal {
+		_, ok := decimalFunctions[name]
+		return ok
+	}
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
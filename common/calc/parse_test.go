@@ -0,0 +1,269 @@
+package calc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/maja42/goval"
+)
+
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		expression string
+		mode       Mode
+		want       string
+	}{
+		{expression: "1 + 2 * 3", want: "(1+(2*3))"},
+		{expression: "1 * 2 + 3", want: "((1*2)+3)"},
+		{expression: "1 - 2 - 3", want: "((1-2)-3)"},
+		{expression: "8 / 4 / 2", want: "((8/4)/2)"},
+		{expression: "(1 + 2) * 3", want: "((1+2)*3)"},
+		{expression: "a || b && c", want: "(a||(b&&c))"},
+		{expression: "a && b || c", want: "((a&&b)||c)"},
+		{expression: "a | b ^ c & d", want: "(a|(b^(c&d)))"},
+		{expression: "a == b < c", want: "(a==(b<c))"},
+		{expression: "a < b + c", want: "(a<(b+c))"},
+		{expression: "1 << 2 + 3", want: "(1<<(2+3))"},
+		{expression: "-2 * 3", want: "((-2)*3)"},
+		{expression: "-a in b", want: "(-(a in b))"},
+		{expression: "!a && b", want: "((!a)&&b)"},
+		{expression: "a<-b", want: "(a<(-b))"},
+		{expression: "a ? b : c ? d : e", want: "(a?b:(c?d:e))"},
+		{expression: "a || b ? 1 + 2 : 3", want: "((a||b)?(1+2):3)"},
+		{expression: "x.y[0] + f(1, 2 * 3)", want: "(x.y[0]+f(1,(2*3)))"},
+		{expression: "arr[1:] + arr[:2]", want: "(arr[1:]+arr[:2])"},
+		{expression: `{"a": 1, "b": [1, 2]}`, want: `{"a":1,"b":[1,2]}`},
+		{expression: "2 ^ 3 ^ 2", want: "((2^3)^2)"},
+		{expression: "2 ^ 3 ^ 2", mode: ModeUnits, want: "(2^(3^2))"},
+		{expression: "2 * 3 ^ 2", mode: ModeUnits, want: "(2*(3^2))"},
+		{expression: "5 km / 2 h", mode: ModeUnits, want: "((5*km)/(2*h))"},
+		{expression: "10 m^2", mode: ModeUnits, want: "(10*(m^2))"},
+		{expression: "x m/s", mode: ModeUnits, want: "((x*m)/s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			root, err := Parse(tt.expression, tt.mode)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			if got := root.String(); got != tt.want {
+				t.Fatalf("Parse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		column     int
+		message    string
+	}{
+		{expression: "1 +", column: 4, message: "unexpected end of expression"},
+		{expression: "(1 + 2", column: 7, message: "unexpected end of expression"},
+		{expression: "1 2", column: 3, message: `unexpected "2"`},
+		{expression: "f(1,)", column: 5, message: `unexpected ")"`},
+		{expression: "in", column: 1, message: `unexpected "in"`},
+		{expression: "a.1", column: 2, message: `unexpected ".1"`},
+		{expression: "[1, 2", column: 6, message: "unexpected end of expression"},
+		{expression: `{"a" 1}`, column: 6, message: `unexpected "1"`},
+		{expression: strings.Repeat("(", maxParseDepth+1) + "1" + strings.Repeat(")", maxParseDepth+1), message: "nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		name := tt.expression
+		if len(name) > 20 {
+			name = name[:20]
+		}
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse(tt.expression, ModeFloat)
+			var exprErr *ExpressionError
+			if !errors.As(err, &exprErr) {
+				t.Fatalf("Parse() = %v, want an ExpressionError", err)
+			}
+			if tt.column != 0 && exprErr.Column != tt.column {
+				t.Errorf("column = %d, want %d", exprErr.Column, tt.column)
+			}
+			if !strings.Contains(exprErr.Msg, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", exprErr.Msg, tt.message)
+			}
+		})
+	}
+}
+
+func TestAnalyzeLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		limits     Limits
+		wantLimit  string
+	}{
+		{name: "within limits", expression: "1 + 2 * 3", limits: Limits{MaxLength: 9, MaxDepth: 3, MaxNodes: 5, MaxOperators: 2}},
+		{name: "length", expression: "1 + 2 * 3", limits: Limits{MaxLength: 8}, wantLimit: "max_length"},
+		{name: "depth", expression: "1 + 2 * 3", limits: Limits{MaxDepth: 2}, wantLimit: "max_depth"},
+		{name: "nodes", expression: "1 + 2 * 3", limits: Limits{MaxNodes: 4}, wantLimit: "max_nodes"},
+		{name: "operators", expression: "1 + 2 * 3", limits: Limits{MaxOperators: 1}, wantLimit: "max_operators"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Analyze(tt.expression, ModeFloat, tt.limits)
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Fatalf("Analyze() = %v", err)
+				}
+				if expr.Nodes != 5 || expr.Depth != 3 || expr.Operators != 2 {
+					t.Fatalf("Analyze() = %d nodes, depth %d, %d operators, want 5, 3, 2", expr.Nodes, expr.Depth, expr.Operators)
+				}
+				return
+			}
+
+			var exprErr *ExpressionError
+			if !errors.As(err, &exprErr) || exprErr.Limit != tt.wantLimit {
+				t.Fatalf("Analyze() = %v, want the %s limit", err, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		calculation *Calculation
+		wantErr     string
+	}{
+		{name: "defined variable", calculation: &Calculation{Expression: "x + sqrt(4)", Variables: map[string]Value{"x": int64(1)}}},
+		{name: "undefined variable", calculation: &Calculation{Expression: "x + 1"}, wantErr: `variable "x" is not defined`},
+		{name: "unknown function", calculation: &Calculation{Expression: "nope(1)"}, wantErr: `function "nope" does not exist`},
+		{name: "decimal function", calculation: &Calculation{Expression: "round(1.5)", Mode: ModeDecimal}},
+		{name: "float only function in decimal mode", calculation: &Calculation{Expression: "sin(1)", Mode: ModeDecimal}, wantErr: `function "sin" does not exist`},
+		{name: "unit", calculation: &Calculation{Expression: "5 km", Mode: ModeUnits}},
+		{name: "unknown unit", calculation: &Calculation{Expression: "5 furlong", Mode: ModeUnits}, wantErr: `"furlong" is neither a variable nor a known unit`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Validate(tt.calculation, Limits{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestParseMatchesGoval checks the parser against goval, which evaluates float mode: goval must reject what
+// Parse rejects, and evaluating the fully parenthesized rendering of an expression must give the same result
+// as evaluating the expression itself, so both agree on precedence and associativity.
+func TestParseMatchesGoval(t *testing.T) {
+	variables := map[string]interface{}{
+		"a":   1,
+		"b":   2,
+		"c":   3,
+		"x":   2.5,
+		"t":   true,
+		"f":   false,
+		"s":   "str",
+		"arr": []interface{}{1, 2, 3},
+		"obj": map[string]interface{}{"k": 4, "nested": map[string]interface{}{"v": 5}},
+	}
+	functions := map[string]goval.ExpressionFunction{
+		"sum": func(args ...interface{}) (interface{}, error) {
+			total := 0
+			for _, arg := range args {
+				total += arg.(int)
+			}
+			return total, nil
+		},
+	}
+
+	valid := []string{
+		"1 + 2 * 3 - 4 / 2",
+		"10 - 4 - 3",
+		"100 / 10 / 5",
+		"7 % 4 * 2",
+		"-a + b",
+		"- -a",
+		"-a * -b",
+		"1 << 2 + 1",
+		"16 >> 1 >> 1",
+		"a | b ^ c & 6",
+		"a ^ b ^ c",
+		"~a & 7",
+		"a + b == c",
+		"a < b == b < c",
+		"a<-b",
+		"!t || f && t",
+		"t && !f == t",
+		"a < b ? x : c",
+		"t ? f ? 1 : 2 : 3",
+		"a > b || b > c ? s + \"!\" : \"no\"",
+		"2 in arr",
+		"!(a in arr) || 5 in arr",
+		"arr[0] + arr[a] * arr[2]",
+		"arr[1:] + arr[:1]",
+		"s[1:2] + s[:a]",
+		"obj.k * obj.nested.v",
+		"obj[\"k\"] + a",
+		"[a, b, [c]][2][0]",
+		"{\"x\": a + b, \"y\": [1, 2]}.y[1]",
+		"sum(a, b * c, sum(1, 2)) - 1",
+		"1.5e2 + 0x10 + .5",
+		"x * 2 > 4.9 && x / 2 < 1.3",
+		"\"a\" + \"b\" == \"ab\"",
+		"1 +\n2",
+	}
+	for _, expression := range valid {
+		t.Run(expression, func(t *testing.T) {
+			want, err := goval.NewEvaluator().Evaluate(expression, variables, functions)
+			if err != nil {
+				t.Fatalf("goval rejected %q: %v", expression, err)
+			}
+
+			root, err := Parse(expression, ModeFloat)
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			got, err := goval.NewEvaluator().Evaluate(root.String(), variables, functions)
+			if err != nil {
+				t.Fatalf("goval rejected the rendering %s: %v", root, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s = %v, want %v like %s", root, got, want, expression)
+			}
+		})
+	}
+
+	invalid := []string{
+		"1 +",
+		"(1",
+		"1 2",
+		"a b",
+		"f(1,)",
+		"[1,",
+		"{\"a\" 1}",
+		"a ? b",
+		"a.1",
+		"*1",
+		"in",
+		"1 +* 2",
+	}
+	for _, expression := range invalid {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := Parse(expression, ModeFloat); err == nil {
+				t.Fatalf("Parse() accepted %q", expression)
+			}
+			if _, err := goval.NewEvaluator().Evaluate(expression, variables, functions); err == nil {
+				t.Fatalf("goval accepted %q", expression)
+			}
+		})
+	}
+}
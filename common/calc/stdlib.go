@@ -0,0 +1,78 @@
+package calc
+
+import (
+	"errors"
+	"math"
+)
+
+func unary(f func(float64) float64) func(args ...float64) (float64, error) {
+	return func(args ...float64) (float64, error) {
+		return f(args[0]), nil
+	}
+}
+
+func logarithm(args ...float64) (float64, error) {
+	if args[0] <= 0 {
+		return 0, errors.New("x must be positive")
+	}
+	if len(args) == 1 {
+		return math.Log(args[0]), nil
+	}
+	if args[1] <= 0 || args[1] == 1 {
+		return 0, errors.New("base must be positive and not 1")
+	}
+	return math.Log(args[0]) / math.Log(args[1]), nil
+}
+
+// standardLibrary is the set of functions every evaluator offers.
+var standardLibrary = []Function{
+	{Name: "abs", Signature: "abs(x)", Description: "Absolute value of x", MinArgs: 1, MaxArgs: 1, Call: unary(math.Abs)},
+	{Name: "ceil", Signature: "ceil(x)", Description: "Smallest integer value greater than or equal to x", MinArgs: 1, MaxArgs: 1, Call: unary(math.Ceil)},
+	{Name: "floor", Signature: "floor(x)", Description: "Greatest integer value less than or equal to x", MinArgs: 1, MaxArgs: 1, Call: unary(math.Floor)},
+	{Name: "round", Signature: "round(x)", Description: "Nearest integer to x, rounding half away from zero", MinArgs: 1, MaxArgs: 1, Call: unary(math.Round)},
+	{Name: "sqrt", Signature: "sqrt(x)", Description: "Square root of x", MinArgs: 1, MaxArgs: 1, Call: func(args ...float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, errors.New("x must not be negative")
+		}
+		return math.Sqrt(args[0]), nil
+	}},
+	{Name: "pow", Signature: "pow(x, y)", Description: "x raised to the power of y", MinArgs: 2, MaxArgs: 2, Call: func(args ...float64) (float64, error) {
+		return math.Pow(args[0], args[1]), nil
+	}},
+	{Name: "exp", Signature: "exp(x)", Description: "e raised to the power of x", MinArgs: 1, MaxArgs: 1, Call: unary(math.Exp)},
+	{Name: "log", Signature: "log(x[, base])", Description: "Logarithm of x, natural unless a base is given", MinArgs: 1, MaxArgs: 2, Call: logarithm},
+	{Name: "log10", Signature: "log10(x)", Description: "Decimal logarithm of x", MinArgs: 1, MaxArgs: 1, Call: func(args ...float64) (float64, error) {
+		return logarithm(args[0], 10)
+	}},
+	{Name: "min", Signature: "min(x, ...)", Description: "Smallest of the arguments", MinArgs: 1, MaxArgs: -1, Call: func(args ...float64) (float64, error) {
+		result := args[0]
+		for _, v := range args[1:] {
+			result = math.Min(result, v)
+		}
+		return result, nil
+	}},
+	{Name: "max", Signature: "max(x, ...)", Description: "Largest of the arguments", MinArgs: 1, MaxArgs: -1, Call: func(args ...float64) (float64, error) {
+		result := args[0]
+		for _, v := range args[1:] {
+			result = math.Max(result, v)
+		}
+		return result, nil
+	}},
+	{Name: "sin", Signature: "sin(x)", Description: "Sine of x radians", MinArgs: 1, MaxArgs: 1, Call: unary(math.Sin)},
+	{Name: "cos", Signature: "cos(x)", Description: "Cosine of x radians", MinArgs: 1, MaxArgs: 1, Call: unary(math.Cos)},
+	{Name: "tan", Signature: "tan(x)", Description: "Tangent of x radians", MinArgs: 1, MaxArgs: 1, Call: unary(math.Tan)},
+	{Name: "asin", Signature: "asin(x)", Description: "Arcsine of x, in radians", MinArgs: 1, MaxArgs: 1, Call: unary(math.Asin)},
+	{Name: "acos", Signature: "acos(x)", Description: "Arccosine of x, in radians", MinArgs: 1, MaxArgs: 1, Call: unary(math.Acos)},
+	{Name: "atan", Signature: "atan(x)", Description: "Arctangent of x, in radians", MinArgs: 1, MaxArgs: 1, Call: unary(math.Atan)},
+	{Name: "atan2", Signature: "atan2(y, x)", Description: "Arctangent of y/x, using the signs of both to pick the quadrant", MinArgs: 2, MaxArgs: 2, Call: func(args ...float64) (float64, error) {
+		return math.Atan2(args[0], args[1]), nil
+	}},
+}
+
+func init() {
+	for _, fn := range standardLibrary {
+		if err := Register(fn); err != nil {
+			panic(err)
+		}
+	}
+}
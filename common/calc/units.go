@@ -0,0 +1,343 @@
+package calc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// dimension holds the exponents of the SI base units, in the order of baseUnits.
+type dimension [7]int
+
+var baseUnits = [7]string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+func (d dimension) mul(o dimension) dimension {
+	for i := range d {
+		d[i] += o[i]
+	}
+	return d
+}
+
+func (d dimension) pow(n int) dimension {
+	for i := range d {
+		d[i] *= n
+	}
+	return d
+}
+
+func (d dimension) dimensionless() bool {
+	return d == dimension{}
+}
+
+// String renders d in SI base units, e.g. "kg*m^2/s^2". Dimensionless is the empty string.
+func (d dimension) String() string {
+	var num, denom []string
+	for i, exp := range d {
+		switch {
+		case exp == 1:
+			num = append(num, baseUnits[i])
+		case exp > 1:
+			num = append(num, baseUnits[i]+"^"+strconv.Itoa(exp))
+		case exp == -1:
+			denom = append(denom, baseUnits[i])
+		case exp < -1:
+			denom = append(denom, baseUnits[i]+"^"+strconv.Itoa(-exp))
+		}
+	}
+
+	result := strings.Join(num, "*")
+	if len(denom) > 0 {
+		if result == "" {
+			result = "1"
+		}
+		result += "/" + strings.Join(denom, "/")
+	}
+	return result
+}
+
+func (d dimension) describe() string {
+	if d.dimensionless() {
+		return "a dimensionless number"
+	}
+	return d.String()
+}
+
+// quantity is a value in SI base units.
+type quantity struct {
+	value float64
+	dim   dimension
+}
+
+func unit(factor float64, m, kg, s, a, k, mol, cd int) quantity {
+	return quantity{value: factor, dim: dimension{m, kg, s, a, k, mol, cd}}
+}
+
+// units are the units expressions can use in units mode. Variables take precedence over units of the same name.
+var units = map[string]quantity{
+	"m":    unit(1, 1, 0, 0, 0, 0, 0, 0),
+	"km":   unit(1e3, 1, 0, 0, 0, 0, 0, 0),
+	"cm":   unit(1e-2, 1, 0, 0, 0, 0, 0, 0),
+	"mm":   unit(1e-3, 1, 0, 0, 0, 0, 0, 0),
+	"um":   unit(1e-6, 1, 0, 0, 0, 0, 0, 0),
+	"nm":   unit(1e-9, 1, 0, 0, 0, 0, 0, 0),
+	"mi":   unit(1609.344, 1, 0, 0, 0, 0, 0, 0),
+	"yd":   unit(0.9144, 1, 0, 0, 0, 0, 0, 0),
+	"ft":   unit(0.3048, 1, 0, 0, 0, 0, 0, 0),
+	"inch": unit(0.0254, 1, 0, 0, 0, 0, 0, 0),
+	"nmi":  unit(1852, 1, 0, 0, 0, 0, 0, 0),
+
+	"kg": unit(1, 0, 1, 0, 0, 0, 0, 0),
+	"g":  unit(1e-3, 0, 1, 0, 0, 0, 0, 0),
+	"mg": unit(1e-6, 0, 1, 0, 0, 0, 0, 0),
+	"t":  unit(1e3, 0, 1, 0, 0, 0, 0, 0),
+	"lb": unit(0.45359237, 0, 1, 0, 0, 0, 0, 0),
+	"oz": unit(0.028349523125, 0, 1, 0, 0, 0, 0, 0),
+
+	"s":   unit(1, 0, 0, 1, 0, 0, 0, 0),
+	"ms":  unit(1e-3, 0, 0, 1, 0, 0, 0, 0),
+	"us":  unit(1e-6, 0, 0, 1, 0, 0, 0, 0),
+	"ns":  unit(1e-9, 0, 0, 1, 0, 0, 0, 0),
+	"min": unit(60, 0, 0, 1, 0, 0, 0, 0),
+	"h":   unit(3600, 0, 0, 1, 0, 0, 0, 0),
+	"d":   unit(86400, 0, 0, 1, 0, 0, 0, 0),
+
+	"A":   unit(1, 0, 0, 0, 1, 0, 0, 0),
+	"K":   unit(1, 0, 0, 0, 0, 1, 0, 0),
+	"mol": unit(1, 0, 0, 0, 0, 0, 1, 0),
+	"cd":  unit(1, 0, 0, 0, 0, 0, 0, 1),
+
+	"Hz":  unit(1, 0, 0, -1, 0, 0, 0, 0),
+	"N":   unit(1, 1, 1, -2, 0, 0, 0, 0),
+	"Pa":  unit(1, -1, 1, -2, 0, 0, 0, 0),
+	"kPa": unit(1e3, -1, 1, -2, 0, 0, 0, 0),
+	"J":   unit(1, 2, 1, -2, 0, 0, 0, 0),
+	"kJ":  unit(1e3, 2, 1, -2, 0, 0, 0, 0),
+	"Wh":  unit(3600, 2, 1, -2, 0, 0, 0, 0),
+	"kWh": unit(3.6e6, 2, 1, -2, 0, 0, 0, 0),
+	"W":   unit(1, 2, 1, -3, 0, 0, 0, 0),
+	"kW":  unit(1e3, 2, 1, -3, 0, 0, 0, 0),
+	"C":   unit(1, 0, 0, 1, 1, 0, 0, 0),
+	"V":   unit(1, 2, 1, -3, -1, 0, 0, 0),
+	"ohm": unit(1, 2, 1, -3, -2, 0, 0, 0),
+	"L":   unit(1e-3, 3, 0, 0, 0, 0, 0, 0),
+	"mL":  unit(1e-6, 3, 0, 0, 0, 0, 0, 0),
+}
+
+// DimensionError reports quantities that can't be combined or converted because their units are incompatible.
+type DimensionError struct {
+	Msg string
+	// Column is the 1-based byte offset of the operation, zero for the conversion to the output unit.
+	Column int
+}
+
+func (e *DimensionError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+	}
+	return e.Msg
+}
+
+type unitEvaluator struct {
+	ctx       context.Context
+	variables map[string]float64
+}
+
+func evaluateUnits(ctx context.Context, calculation *Calculation, root *Node) (*Result, error) {
+	variables, err := unitVariables(calculation.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &unitEvaluator{ctx: ctx, variables: variables}
+	result, err := e.eval(root)
+	if err != nil {
+		return nil, err
+	}
+
+	value, unitName := result.value, result.dim.String()
+	if outputUnit := strings.TrimSpace(calculation.OutputUnit); outputUnit != "" {
+		output, err := parseUnit(outputUnit)
+		if err != nil {
+			return nil, err
+		}
+		if output.dim != result.dim {
+			return nil, &DimensionError{Msg: fmt.Sprintf("cannot convert %s to %s", result.dim.describe(), outputUnit)}
+		}
+		value, unitName = result.value/output.value, outputUnit
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("result is not a finite number")
+	}
+	return &Result{Value: value, Unit: unitName}, nil
+}
+
+// ValidateUnit checks that unit can be used as the output unit of a units mode calculation.
+func ValidateUnit(unit string) error {
+	_, err := parseUnit(unit)
+	return err
+}
+
+// parseUnit evaluates a unit expression such as "km/h" into its size in SI base units.
+func parseUnit(unit string) (quantity, error) {
+	root, err := Parse(unit, ModeUnits)
+	if err != nil {
+		return quantity{}, fmt.Errorf("output unit: %w", err)
+	}
+	e := &unitEvaluator{ctx: context.Background()}
+	result, err := e.eval(root)
+	if err != nil {
+		return quantity{}, fmt.Errorf("output unit: %w", err)
+	}
+	if result.value == 0 || math.IsNaN(result.value) || math.IsInf(result.value, 0) {
+		return quantity{}, fmt.Errorf("output unit %q is not a valid unit", unit)
+	}
+	return result, nil
+}
+
+func unitVariables(vars map[string]Value) (map[string]float64, error) {
+	result := make(map[string]float64, len(vars))
+	for name, v := range vars {
+		value, err := fromValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+		switch value := value.(type) {
+		case int:
+			result[name] = float64(value)
+		case float64:
+			result[name] = value
+		default:
+			return nil, fmt.Errorf("variable %q: units mode only supports numeric variables", name)
+		}
+	}
+	return result, nil
+}
+
+func (e *unitEvaluator) eval(n *Node) (quantity, error) {
+	if err := e.ctx.Err(); err != nil {
+		return quantity{}, err
+	}
+
+	switch n.Kind {
+	case NodeNumber:
+		value, err := parseFloat(n.Value)
+		if err != nil {
+			return quantity{}, &ExpressionError{Column: n.Column, Msg: err.Error()}
+		}
+		return quantity{value: value}, nil
+	case NodeVariable:
+		if v, ok := e.variables[n.Value]; ok {
+			return quantity{value: v}, nil
+		}
+		if u, ok := units[n.Value]; ok {
+			return u, nil
+		}
+		return quantity{}, &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("%q is neither a variable nor a known unit", n.Value)}
+	case NodeUnary:
+		if n.Value != "-" {
+			break
+		}
+		x, err := e.eval(n.Children[0])
+		if err != nil {
+			return quantity{}, err
+		}
+		return quantity{value: -x.value, dim: x.dim}, nil
+	case NodeBinary:
+		return e.binary(n)
+	case NodeCall:
+		return e.call(n)
+	}
+	return quantity{}, &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("units mode does not support %s %q", n.Kind, n.Value)}
+}
+
+func (e *unitEvaluator) binary(n *Node) (quantity, error) {
+	x, err := e.eval(n.Children[0])
+	if err != nil {
+		return quantity{}, err
+	}
+	y, err := e.eval(n.Children[1])
+	if err != nil {
+		return quantity{}, err
+	}
+
+	switch n.Value {
+	case "+", "-", "%":
+		if x.dim != y.dim {
+			return quantity{}, &DimensionError{Column: n.Column, Msg: fmt.Sprintf("cannot combine %s and %s with %q", x.dim.describe(), y.dim.describe(), n.Value)}
+		}
+		switch n.Value {
+		case "+":
+			return quantity{value: x.value + y.value, dim: x.dim}, nil
+		case "-":
+			return quantity{value: x.value - y.value, dim: x.dim}, nil
+		default:
+			return quantity{value: math.Mod(x.value, y.value), dim: x.dim}, nil
+		}
+	case "*":
+		return quantity{value: x.value * y.value, dim: x.dim.mul(y.dim)}, nil
+	case "/":
+		if y.value == 0 {
+			return quantity{}, fmt.Errorf("division by zero")
+		}
+		return quantity{value: x.value / y.value, dim: x.dim.mul(y.dim.pow(-1))}, nil
+	case "^":
+		if !y.dim.dimensionless() {
+			return quantity{}, &DimensionError{Column: n.Column, Msg: fmt.Sprintf("exponent must be a dimensionless number, not %s", y.dim.describe())}
+		}
+		if x.dim.dimensionless() {
+			return quantity{value: math.Pow(x.value, y.value)}, nil
+		}
+		if y.value != math.Trunc(y.value) || math.Abs(y.value) > 100 {
+			return quantity{}, &DimensionError{Column: n.Column, Msg: fmt.Sprintf("%s can only be raised to a whole power", x.dim.describe())}
+		}
+		return quantity{value: math.Pow(x.value, y.value), dim: x.dim.pow(int(y.value))}, nil
+	}
+	return quantity{}, &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("units mode does not support operator %q", n.Value)}
+}
+
+// call invokes a registered function, which only accepts dimensionless arguments.
+func (e *unitEvaluator) call(n *Node) (quantity, error) {
+	registryMu.RLock()
+	fn, ok := registry[n.Value]
+	registryMu.RUnlock()
+	if !ok {
+		return quantity{}, &ExpressionError{Column: n.Column, Msg: fmt.Sprintf("function %q does not exist", n.Value)}
+	}
+
+	args := make([]interface{}, 0, len(n.Children))
+	for i, arg := range n.Children {
+		v, err := e.eval(arg)
+		if err != nil {
+			return quantity{}, err
+		}
+		if !v.dim.dimensionless() {
+			return quantity{}, &DimensionError{Column: arg.Column, Msg: fmt.Sprintf("%s: argument %d must be a dimensionless number, not %s", n.Value, i+1, v.dim.describe())}
+		}
+		args = append(args, v.value)
+	}
+
+	result, err := call(e.ctx, fn, args)
+	if err != nil {
+		return quantity{}, err
+	}
+	return quantity{value: result.(float64)}, nil
+}
+
+// parseFloat parses a number literal the way goval does.
+func parseFloat(lit string) (float64, error) {
+	if hex := strings.TrimPrefix(lit, "0x"); len(hex) < len(lit) {
+		v, err := strconv.ParseUint(hex, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse error: cannot parse integer %s", lit)
+		}
+		return float64(v), nil
+	}
+	v, err := strconv.ParseFloat(lit, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse error: cannot parse number %s", lit)
+	}
+	return v, nil
+}
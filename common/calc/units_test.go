@@ -0,0 +1,99 @@
+package calc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateUnits(t *testing.T) {
+	tests := []struct {
+		expression string
+		variables  map[string]Value
+		outputUnit string
+		want       float64
+		wantUnit   string
+	}{
+		{expression: "5 km / 2 h", outputUnit: "km/h", want: 2.5, wantUnit: "km/h"},
+		{expression: "5 km / 2 h", want: 5000.0 / 7200, wantUnit: "m/s"},
+		{expression: "1 mi", outputUnit: "km", want: 1.609344, wantUnit: "km"},
+		{expression: "3 ft + 1 yd", outputUnit: "inch", want: 72, wantUnit: "inch"},
+		{expression: "2 lb", outputUnit: "oz", want: 32, wantUnit: "oz"},
+		{expression: "1 kWh", outputUnit: "J", want: 3.6e6, wantUnit: "J"},
+		{expression: "10 m^2 * 2 m", outputUnit: "L", want: 20000, wantUnit: "L"},
+		{expression: "(2 m)^2", want: 4, wantUnit: "m^2"},
+		{expression: "1 N", want: 1, wantUnit: "m*kg/s^2"},
+		{expression: "1 / 4 s", want: 0.25, wantUnit: "1/s"},
+		{expression: "1 Hz", outputUnit: "1/min", want: 60, wantUnit: "1/min"},
+		{expression: "d km", variables: map[string]Value{"d": int64(3)}, outputUnit: "m", want: 3000, wantUnit: "m"},
+		{expression: "m * 2", variables: map[string]Value{"m": 1.5}, want: 3, wantUnit: ""},
+		{expression: "sqrt(16) m", want: 4, wantUnit: "m"},
+		{expression: "7 m % 4 m", want: 3, wantUnit: "m"},
+		{expression: "2 ^ 10", want: 1024, wantUnit: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression+" in "+tt.outputUnit, func(t *testing.T) {
+			calculation := &Calculation{Expression: tt.expression, Variables: tt.variables, Mode: ModeUnits, OutputUnit: tt.outputUnit}
+			result, err := Evaluate(context.Background(), calculation, Limits{})
+			if err != nil {
+				t.Fatalf("Evaluate() = %v", err)
+			}
+			value, ok := result.Value.(float64)
+			if !ok || math.Abs(value-tt.want) > 1e-9*math.Max(1, math.Abs(tt.want)) || result.Unit != tt.wantUnit {
+				t.Fatalf("Evaluate() = %v %q, want %v %q", result.Value, result.Unit, tt.want, tt.wantUnit)
+			}
+		})
+	}
+}
+
+func TestEvaluateUnitsErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		variables  map[string]Value
+		outputUnit string
+		dimension  bool
+		wantErr    string
+	}{
+		{expression: "1 m + 1 s", dimension: true, wantErr: `cannot combine m and s with "+"`},
+		{expression: "1 m - 1", dimension: true, wantErr: "cannot combine m and a dimensionless number"},
+		{expression: "2 ^ 1 m", dimension: true, wantErr: "exponent must be a dimensionless number"},
+		{expression: "(2 m) ^ 0.5", dimension: true, wantErr: "can only be raised to a whole power"},
+		{expression: "sqrt(4 m)", dimension: true, wantErr: "argument 1 must be a dimensionless number"},
+		{expression: "1 m", outputUnit: "s", dimension: true, wantErr: "cannot convert m to s"},
+		{expression: "1 m", outputUnit: "furlong", wantErr: "furlong"},
+		{expression: "1 m", outputUnit: "0 m", wantErr: "is not a valid unit"},
+		{expression: "1 m / 0", wantErr: "division by zero"},
+		{expression: "1 m < 2 m", wantErr: `does not support operator "<"`},
+		{expression: "x", variables: map[string]Value{"x": "one"}, wantErr: "only supports numeric variables"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression+" in "+tt.outputUnit, func(t *testing.T) {
+			calculation := &Calculation{Expression: tt.expression, Variables: tt.variables, Mode: ModeUnits, OutputUnit: tt.outputUnit}
+			result, err := Evaluate(context.Background(), calculation, Limits{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Evaluate() = %v, %v, want error %q", result, err, tt.wantErr)
+			}
+			var dimErr *DimensionError
+			if errors.As(err, &dimErr) != tt.dimension {
+				t.Fatalf("Evaluate() = %T, want a DimensionError: %v", err, tt.dimension)
+			}
+		})
+	}
+}
+
+func TestValidateUnit(t *testing.T) {
+	for _, unit := range []string{"m", "km/h", "m^2", "kg*m/s^2", "1/s"} {
+		if err := ValidateUnit(unit); err != nil {
+			t.Errorf("ValidateUnit(%q) = %v", unit, err)
+		}
+	}
+	for _, unit := range []string{"furlong", "m +", "0 m", ""} {
+		if err := ValidateUnit(unit); err == nil {
+			t.Errorf("ValidateUnit(%q) accepted an invalid unit", unit)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package calc
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Value is a variable or result value: nil, int64, float64, string, bool, Decimal or a []Value list.
+type Value interface{}
+
+// Decimal is an exact decimal number, e.g. "0.30".
+type Decimal string
+
+// Result is the outcome of evaluating a calculation.
+type Result struct {
+	Value Value
+	// Unit is the unit of a ModeUnits result, empty for dimensionless numbers.
+	Unit string
+}
+
+// Variables converts the calculation variables into the values goval understands.
+func Variables(vars map[string]Value) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(vars))
+	for name, v := range vars {
+		value, err := fromValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+func fromValue(v Value) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return int(v), nil
+	case float64, string, bool:
+		return v, nil
+	case Decimal:
+		value, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a decimal number", v)
+		}
+		return value, nil
+	case []Value:
+		values := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			value, err := fromValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("no value set")
+	}
+}
+
+// toValue converts a goval result into a typed value.
+func toValue(v interface{}) (Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case int:
+		return int64(v), nil
+	case float64, string, bool:
+		return v, nil
+	case []interface{}:
+		list := make([]Value, 0, len(v))
+		for i, item := range v {
+			value, err := toValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported result type %T", v)
+	}
+}
+
+// checkSize enforces the string and list limits on v.
+func checkSize(v Value, limits Limits) error {
+	switch v := v.(type) {
+	case string:
+		if limits.MaxStringLength > 0 && len(v) > limits.MaxStringLength {
+			return &ExpressionError{Limit: "max_string_length", Msg: fmt.Sprintf("string is %d bytes long, the limit is %d", len(v), limits.MaxStringLength)}
+		}
+	case Decimal:
+		if limits.MaxStringLength > 0 && len(v) > limits.MaxStringLength {
+			return &ExpressionError{Limit: "max_string_length", Msg: fmt.Sprintf("decimal is %d bytes long, the limit is %d", len(v), limits.MaxStringLength)}
+		}
+	case []Value:
+		if limits.MaxListLength > 0 && len(v) > limits.MaxListLength {
+			return &ExpressionError{Limit: "max_list_length", Msg: fmt.Sprintf("list has %d items, the limit is %d", len(v), limits.MaxListLength)}
+		}
+		for i, item := range v {
+			if err := checkSize(item, limits); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
@@ -70,6 +70,10 @@ func (l *Logger) Infof(format string, args ...interface{}) {
 	l.logger.Infof(format, args...)
 }
 
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.logger.Warnf(format, args...)
+}
+
 func (l *Logger) Error(args ...interface{}) {
 	l.logger.Error(args...)
 }
@@ -82,6 +86,11 @@ func (l *Logger) WithError(err error) *Logger {
 	return &Logger{logger: l.logger.With(errorKey, err.Error())}
 }
 
+// With adds the key value pairs to every entry logged with the returned logger.
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	return &Logger{logger: l.logger.With(keysAndValues...)}
+}
+
 func WithContext(ctx context.Context) *Logger {
 	return globalLog.WithContext(ctx)
 }
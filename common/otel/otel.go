@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 
+	mexporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/metric"
 	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
 	"go.opentelemetry.io/contrib/detectors/gcp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
@@ -20,12 +23,54 @@ type Config struct {
 	ServiceVersion string
 }
 
+// InitTracing installs the global tracer provider and propagators.
+// Without a ProjectID spans are still recorded and propagated but not exported, which is handy for local runs.
 func InitTracing(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
-	exporter, err := texporter.New(texporter.WithProjectID(cfg.ProjectID))
+	var opts []sdktrace.TracerProviderOption
+	if cfg.ProjectID != "" {
+		exporter, err := texporter.New(texporter.WithProjectID(cfg.ProjectID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	res, err := newResource(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp, nil
+}
+
+// InitMetrics installs the global meter provider.
+// Without a ProjectID measurements are aggregated but never exported.
+func InitMetrics(ctx context.Context, cfg Config) (*sdkmetric.MeterProvider, error) {
+	var opts []sdkmetric.Option
+	if cfg.ProjectID != "" {
+		exporter, err := mexporter.New(mexporter.WithProjectID(cfg.ProjectID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+		}
+		opts = append(opts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	}
+
+	res, err := newResource(ctx, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+		return nil, err
 	}
 
+	mp := sdkmetric.NewMeterProvider(append(opts, sdkmetric.WithResource(res))...)
+	otel.SetMeterProvider(mp)
+
+	return mp, nil
+}
+
+func newResource(ctx context.Context, cfg Config) (*resource.Resource, error) {
 	// Identify your application using resource detection
 	res, err := resource.New(ctx,
 		// Use the GCP resource detector to detect information about the GCP platform
@@ -41,17 +86,13 @@ func InitTracing(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, err
 	if err != nil {
 		return nil, fmt.Errorf("init resource detection: %w", err)
 	}
-
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(res),
-	)
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
-
-	return tp, nil
+	return res, nil
 }
 
 func Tracer() trace.Tracer {
 	return otel.Tracer("otel-demo")
 }
+
+func Meter() metric.Meter {
+	return otel.Meter("otel-demo")
+}
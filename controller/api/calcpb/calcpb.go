@@ -0,0 +1,228 @@
+// Package calcpb converts between the calculator API messages and the types of the calc package, so services
+// speaking the API can evaluate calculations without calc depending on the API.
+package calcpb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kostyay/otel-demo/common/calc"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+// Mode converts an evaluation mode, unspecified and unknown modes evaluate with floats.
+func Mode(mode pb.EvaluationMode) calc.Mode {
+	switch mode {
+	case pb.EvaluationMode_EVALUATION_MODE_DECIMAL:
+		return calc.ModeDecimal
+	case pb.EvaluationMode_EVALUATION_MODE_UNITS:
+		return calc.ModeUnits
+	}
+	return calc.ModeFloat
+}
+
+// NormalizeDecimal returns opts with the defaults filled in.
+func NormalizeDecimal(opts *pb.DecimalOptions) *pb.DecimalOptions {
+	result := &pb.DecimalOptions{
+		Scale:    proto.Uint32(calc.DefaultDecimalScale),
+		Rounding: pb.RoundingMode_ROUNDING_MODE_HALF_EVEN,
+	}
+	if opts != nil && opts.Scale != nil {
+		result.Scale = proto.Uint32(opts.GetScale())
+	}
+	if opts.GetRounding() != pb.RoundingMode_ROUNDING_MODE_UNSPECIFIED {
+		result.Rounding = opts.GetRounding()
+	}
+	return result
+}
+
+// Decimal converts decimal options, filling in the defaults.
+func Decimal(opts *pb.DecimalOptions) calc.DecimalOptions {
+	opts = NormalizeDecimal(opts)
+	return calc.DecimalOptions{Scale: opts.GetScale(), Rounding: calc.Rounding(opts.GetRounding())}
+}
+
+// Calculation converts the inputs of a calculation.
+func Calculation(calculation *pb.Calculation) (*calc.Calculation, error) {
+	variables, err := Variables(calculation.GetVariables())
+	if err != nil {
+		return nil, err
+	}
+	return &calc.Calculation{
+		Owner:      calculation.GetOwner(),
+		Expression: calculation.GetExpression(),
+		Variables:  variables,
+		Mode:       Mode(calculation.GetMode()),
+		Decimal:    Decimal(calculation.GetDecimal()),
+		OutputUnit: calculation.GetOutputUnit(),
+	}, nil
+}
+
+// Variables converts calculation variables.
+func Variables(vars map[string]*pb.Value) (map[string]calc.Value, error) {
+	result := make(map[string]calc.Value, len(vars))
+	for name, v := range vars {
+		value, err := Value(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+		result[name] = value
+	}
+	return result, nil
+}
+
+// Value converts a typed value, its unit is dropped.
+func Value(v *pb.Value) (calc.Value, error) {
+	switch kind := v.GetKind().(type) {
+	case *pb.Value_IntValue:
+		return kind.IntValue, nil
+	case *pb.Value_DoubleValue:
+		return kind.DoubleValue, nil
+	case *pb.Value_StringValue:
+		return kind.StringValue, nil
+	case *pb.Value_BoolValue:
+		return kind.BoolValue, nil
+	case *pb.Value_DecimalValue:
+		return calc.Decimal(kind.DecimalValue), nil
+	case *pb.Value_ListValue:
+		values := make([]calc.Value, 0, len(kind.ListValue.GetValues()))
+		for i, item := range kind.ListValue.GetValues() {
+			value, err := Value(item)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("no value set")
+	}
+}
+
+// Result converts the result of an evaluation into a typed value.
+func Result(result *calc.Result) (*pb.Value, error) {
+	value, err := toValue(result.Value)
+	if err != nil {
+		return nil, err
+	}
+	value.Unit = result.Unit
+	return value, nil
+}
+
+// toValue converts a calc value into a typed value. nil becomes a value without a kind.
+func toValue(v calc.Value) (*pb.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return &pb.Value{}, nil
+	case int64:
+		return &pb.Value{Kind: &pb.Value_IntValue{IntValue: v}}, nil
+	case float64:
+		return &pb.Value{Kind: &pb.Value_DoubleValue{DoubleValue: v}}, nil
+	case string:
+		return &pb.Value{Kind: &pb.Value_StringValue{StringValue: v}}, nil
+	case bool:
+		return &pb.Value{Kind: &pb.Value_BoolValue{BoolValue: v}}, nil
+	case calc.Decimal:
+		return &pb.Value{Kind: &pb.Value_DecimalValue{DecimalValue: string(v)}}, nil
+	case []calc.Value:
+		list := &pb.ValueList{Values: make([]*pb.Value, 0, len(v))}
+		for i, item := range v {
+			value, err := toValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+			list.Values = append(list.Values, value)
+		}
+		return &pb.Value{Kind: &pb.Value_ListValue{ListValue: list}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// Validate is calc.Validate for a calculation message. Only the names of the variables matter, so their
+// values may still be unset.
+func Validate(calculation *pb.Calculation, limits calc.Limits) (*calc.Expression, error) {
+	variables := make(map[string]calc.Value, len(calculation.GetVariables()))
+	for name := range calculation.GetVariables() {
+		variables[name] = nil
+	}
+	return calc.Validate(&calc.Calculation{
+		Expression: calculation.GetExpression(),
+		Variables:  variables,
+		Mode:       Mode(calculation.GetMode()),
+	}, limits)
+}
+
+// Evaluate evaluates calculation within limits and stores the outcome in calculation.Result.
+// The span carried by ctx is annotated with the calculation details.
+func Evaluate(ctx context.Context, calculation *pb.Calculation, limits calc.Limits) error {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("evaluation.mode", calculation.GetMode().String()))
+
+	input, err := Calculation(calculation)
+	if err != nil {
+		return err
+	}
+	result, err := calc.Evaluate(ctx, input, limits)
+	if err != nil {
+		return err
+	}
+	value, err := Result(result)
+	if err != nil {
+		return fmt.Errorf("unable to convert result: %w", err)
+	}
+
+	calculation.Result = value
+	return nil
+}
+
+// EvaluationError describes an error returned by Evaluate as part of a calculation.
+func EvaluationError(err error) *pb.EvaluationError {
+	result := &pb.EvaluationError{
+		Kind:    pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_RUNTIME,
+		Message: err.Error(),
+	}
+
+	var dimErr *calc.DimensionError
+	if errors.As(err, &dimErr) {
+		result.Kind = pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_DIMENSION_MISMATCH
+		result.Column = uint32(dimErr.Column)
+		return result
+	}
+
+	var exprErr *calc.ExpressionError
+	if errors.As(err, &exprErr) {
+		result.Kind = pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_INVALID_EXPRESSION
+		result.Column = uint32(exprErr.Column)
+		if exprErr.Limit != "" {
+			result.Kind = pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_LIMIT_EXCEEDED
+			result.Limit = exprErr.Limit
+		}
+	}
+	return result
+}
+
+// ExpressionError converts an expression error into its error detail.
+func ExpressionError(err *calc.ExpressionError) *pb.ExpressionError {
+	return &pb.ExpressionError{
+		Message: err.Msg,
+		Column:  uint32(err.Column),
+		Limit:   err.Limit,
+	}
+}
+
+// Node converts the syntax tree rooted at n.
+func Node(n *calc.Node) *pb.ExpressionNode {
+	result := &pb.ExpressionNode{
+		Kind:   pb.ExpressionNodeKind(n.Kind),
+		Value:  n.Value,
+		Column: uint32(n.Column),
+	}
+	for _, child := range n.Children {
+		result.Children = append(result.Children, Node(child))
+	}
+	return result
+}
@@ -44,6 +44,39 @@ const (
 	// CalculatorServiceCleanupProcedure is the fully-qualified name of the CalculatorService's Cleanup
 	// RPC.
 	CalculatorServiceCleanupProcedure = "/calculator.v1.CalculatorService/Cleanup"
+	// CalculatorServiceGetHistoryProcedure is the fully-qualified name of the CalculatorService's
+	// GetHistory RPC.
+	CalculatorServiceGetHistoryProcedure = "/calculator.v1.CalculatorService/GetHistory"
+	// CalculatorServiceWatchCalculationProcedure is the fully-qualified name of the CalculatorService's
+	// WatchCalculation RPC.
+	CalculatorServiceWatchCalculationProcedure = "/calculator.v1.CalculatorService/WatchCalculation"
+	// CalculatorServiceListFunctionsProcedure is the fully-qualified name of the CalculatorService's
+	// ListFunctions RPC.
+	CalculatorServiceListFunctionsProcedure = "/calculator.v1.CalculatorService/ListFunctions"
+	// CalculatorServiceValidateExpressionProcedure is the fully-qualified name of the
+	// CalculatorService's ValidateExpression RPC.
+	CalculatorServiceValidateExpressionProcedure = "/calculator.v1.CalculatorService/ValidateExpression"
+	// CalculatorServiceCreatePipelineProcedure is the fully-qualified name of the CalculatorService's
+	// CreatePipeline RPC.
+	CalculatorServiceCreatePipelineProcedure = "/calculator.v1.CalculatorService/CreatePipeline"
+	// CalculatorServiceGetPipelineProcedure is the fully-qualified name of the CalculatorService's
+	// GetPipeline RPC.
+	CalculatorServiceGetPipelineProcedure = "/calculator.v1.CalculatorService/GetPipeline"
+	// CalculatorServiceCreateScheduleProcedure is the fully-qualified name of the CalculatorService's
+	// CreateSchedule RPC.
+	CalculatorServiceCreateScheduleProcedure = "/calculator.v1.CalculatorService/CreateSchedule"
+	// CalculatorServiceGetScheduleProcedure is the fully-qualified name of the CalculatorService's
+	// GetSchedule RPC.
+	CalculatorServiceGetScheduleProcedure = "/calculator.v1.CalculatorService/GetSchedule"
+	// CalculatorServiceListSchedulesProcedure is the fully-qualified name of the CalculatorService's
+	// ListSchedules RPC.
+	CalculatorServiceListSchedulesProcedure = "/calculator.v1.CalculatorService/ListSchedules"
+	// CalculatorServiceUpdateScheduleProcedure is the fully-qualified name of the CalculatorService's
+	// UpdateSchedule RPC.
+	CalculatorServiceUpdateScheduleProcedure = "/calculator.v1.CalculatorService/UpdateSchedule"
+	// CalculatorServiceDeleteScheduleProcedure is the fully-qualified name of the CalculatorService's
+	// DeleteSchedule RPC.
+	CalculatorServiceDeleteScheduleProcedure = "/calculator.v1.CalculatorService/DeleteSchedule"
 )
 
 // CalculatorServiceClient is a client for the calculator.v1.CalculatorService service.
@@ -52,6 +85,23 @@ type CalculatorServiceClient interface {
 	List(context.Context, *connect_go.Request[v1.ListRequest]) (*connect_go.Response[v1.ListResponse], error)
 	Get(context.Context, *connect_go.Request[v1.GetRequest]) (*connect_go.Response[v1.GetResponse], error)
 	Cleanup(context.Context, *connect_go.Request[v1.CleanupRequest]) (*connect_go.Response[v1.CleanupResponse], error)
+	// GetHistory returns every state change of a calculation, oldest first.
+	GetHistory(context.Context, *connect_go.Request[v1.GetHistoryRequest]) (*connect_go.Response[v1.GetHistoryResponse], error)
+	// WatchCalculation streams the history of a calculation and then its changes as they happen, until it completes.
+	WatchCalculation(context.Context, *connect_go.Request[v1.WatchCalculationRequest]) (*connect_go.ServerStreamForClient[v1.WatchCalculationResponse], error)
+	ListFunctions(context.Context, *connect_go.Request[v1.ListFunctionsRequest]) (*connect_go.Response[v1.ListFunctionsResponse], error)
+	// ValidateExpression parses an expression without evaluating it.
+	ValidateExpression(context.Context, *connect_go.Request[v1.ValidateExpressionRequest]) (*connect_go.Response[v1.ValidateExpressionResponse], error)
+	// CreatePipeline starts a pipeline of calculations, steps can use the results of earlier steps as variables.
+	CreatePipeline(context.Context, *connect_go.Request[v1.CreatePipelineRequest]) (*connect_go.Response[v1.CreatePipelineResponse], error)
+	GetPipeline(context.Context, *connect_go.Request[v1.GetPipelineRequest]) (*connect_go.Response[v1.GetPipelineResponse], error)
+	// CreateSchedule runs a calculation at a future time or on a cron schedule, every run is a new calculation.
+	CreateSchedule(context.Context, *connect_go.Request[v1.CreateScheduleRequest]) (*connect_go.Response[v1.CreateScheduleResponse], error)
+	GetSchedule(context.Context, *connect_go.Request[v1.GetScheduleRequest]) (*connect_go.Response[v1.GetScheduleResponse], error)
+	ListSchedules(context.Context, *connect_go.Request[v1.ListSchedulesRequest]) (*connect_go.Response[v1.ListSchedulesResponse], error)
+	// UpdateSchedule replaces the calculation, timing and paused state of a schedule.
+	UpdateSchedule(context.Context, *connect_go.Request[v1.UpdateScheduleRequest]) (*connect_go.Response[v1.UpdateScheduleResponse], error)
+	DeleteSchedule(context.Context, *connect_go.Request[v1.DeleteScheduleRequest]) (*connect_go.Response[v1.DeleteScheduleResponse], error)
 }
 
 // NewCalculatorServiceClient constructs a client for the calculator.v1.CalculatorService service.
@@ -84,15 +134,81 @@ func NewCalculatorServiceClient(httpClient connect_go.HTTPClient, baseURL string
 			baseURL+CalculatorServiceCleanupProcedure,
 			opts...,
 		),
+		getHistory: connect_go.NewClient[v1.GetHistoryRequest, v1.GetHistoryResponse](
+			httpClient,
+			baseURL+CalculatorServiceGetHistoryProcedure,
+			opts...,
+		),
+		watchCalculation: connect_go.NewClient[v1.WatchCalculationRequest, v1.WatchCalculationResponse](
+			httpClient,
+			baseURL+CalculatorServiceWatchCalculationProcedure,
+			opts...,
+		),
+		listFunctions: connect_go.NewClient[v1.ListFunctionsRequest, v1.ListFunctionsResponse](
+			httpClient,
+			baseURL+CalculatorServiceListFunctionsProcedure,
+			opts...,
+		),
+		validateExpression: connect_go.NewClient[v1.ValidateExpressionRequest, v1.ValidateExpressionResponse](
+			httpClient,
+			baseURL+CalculatorServiceValidateExpressionProcedure,
+			opts...,
+		),
+		createPipeline: connect_go.NewClient[v1.CreatePipelineRequest, v1.CreatePipelineResponse](
+			httpClient,
+			baseURL+CalculatorServiceCreatePipelineProcedure,
+			opts...,
+		),
+		getPipeline: connect_go.NewClient[v1.GetPipelineRequest, v1.GetPipelineResponse](
+			httpClient,
+			baseURL+CalculatorServiceGetPipelineProcedure,
+			opts...,
+		),
+		createSchedule: connect_go.NewClient[v1.CreateScheduleRequest, v1.CreateScheduleResponse](
+			httpClient,
+			baseURL+CalculatorServiceCreateScheduleProcedure,
+			opts...,
+		),
+		getSchedule: connect_go.NewClient[v1.GetScheduleRequest, v1.GetScheduleResponse](
+			httpClient,
+			baseURL+CalculatorServiceGetScheduleProcedure,
+			opts...,
+		),
+		listSchedules: connect_go.NewClient[v1.ListSchedulesRequest, v1.ListSchedulesResponse](
+			httpClient,
+			baseURL+CalculatorServiceListSchedulesProcedure,
+			opts...,
+		),
+		updateSchedule: connect_go.NewClient[v1.UpdateScheduleRequest, v1.UpdateScheduleResponse](
+			httpClient,
+			baseURL+CalculatorServiceUpdateScheduleProcedure,
+			opts...,
+		),
+		deleteSchedule: connect_go.NewClient[v1.DeleteScheduleRequest, v1.DeleteScheduleResponse](
+			httpClient,
+			baseURL+CalculatorServiceDeleteScheduleProcedure,
+			opts...,
+		),
 	}
 }
 
 // calculatorServiceClient implements CalculatorServiceClient.
 type calculatorServiceClient struct {
-	calculate *connect_go.Client[v1.CalculateRequest, v1.CalculateResponse]
-	list      *connect_go.Client[v1.ListRequest, v1.ListResponse]
-	get       *connect_go.Client[v1.GetRequest, v1.GetResponse]
-	cleanup   *connect_go.Client[v1.CleanupRequest, v1.CleanupResponse]
+	calculate          *connect_go.Client[v1.CalculateRequest, v1.CalculateResponse]
+	list               *connect_go.Client[v1.ListRequest, v1.ListResponse]
+	get                *connect_go.Client[v1.GetRequest, v1.GetResponse]
+	cleanup            *connect_go.Client[v1.CleanupRequest, v1.CleanupResponse]
+	getHistory         *connect_go.Client[v1.GetHistoryRequest, v1.GetHistoryResponse]
+	watchCalculation   *connect_go.Client[v1.WatchCalculationRequest, v1.WatchCalculationResponse]
+	listFunctions      *connect_go.Client[v1.ListFunctionsRequest, v1.ListFunctionsResponse]
+	validateExpression *connect_go.Client[v1.ValidateExpressionRequest, v1.ValidateExpressionResponse]
+	createPipeline     *connect_go.Client[v1.CreatePipelineRequest, v1.CreatePipelineResponse]
+	getPipeline        *connect_go.Client[v1.GetPipelineRequest, v1.GetPipelineResponse]
+	createSchedule     *connect_go.Client[v1.CreateScheduleRequest, v1.CreateScheduleResponse]
+	getSchedule        *connect_go.Client[v1.GetScheduleRequest, v1.GetScheduleResponse]
+	listSchedules      *connect_go.Client[v1.ListSchedulesRequest, v1.ListSchedulesResponse]
+	updateSchedule     *connect_go.Client[v1.UpdateScheduleRequest, v1.UpdateScheduleResponse]
+	deleteSchedule     *connect_go.Client[v1.DeleteScheduleRequest, v1.DeleteScheduleResponse]
 }
 
 // Calculate calls calculator.v1.CalculatorService.Calculate.
@@ -115,12 +231,84 @@ func (c *calculatorServiceClient) Cleanup(ctx context.Context, req *connect_go.R
 	return c.cleanup.CallUnary(ctx, req)
 }
 
+// GetHistory calls calculator.v1.CalculatorService.GetHistory.
+func (c *calculatorServiceClient) GetHistory(ctx context.Context, req *connect_go.Request[v1.GetHistoryRequest]) (*connect_go.Response[v1.GetHistoryResponse], error) {
+	return c.getHistory.CallUnary(ctx, req)
+}
+
+// WatchCalculation calls calculator.v1.CalculatorService.WatchCalculation.
+func (c *calculatorServiceClient) WatchCalculation(ctx context.Context, req *connect_go.Request[v1.WatchCalculationRequest]) (*connect_go.ServerStreamForClient[v1.WatchCalculationResponse], error) {
+	return c.watchCalculation.CallServerStream(ctx, req)
+}
+
+// ListFunctions calls calculator.v1.CalculatorService.ListFunctions.
+func (c *calculatorServiceClient) ListFunctions(ctx context.Context, req *connect_go.Request[v1.ListFunctionsRequest]) (*connect_go.Response[v1.ListFunctionsResponse], error) {
+	return c.listFunctions.CallUnary(ctx, req)
+}
+
+// ValidateExpression calls calculator.v1.CalculatorService.ValidateExpression.
+func (c *calculatorServiceClient) ValidateExpression(ctx context.Context, req *connect_go.Request[v1.ValidateExpressionRequest]) (*connect_go.Response[v1.ValidateExpressionResponse], error) {
+	return c.validateExpression.CallUnary(ctx, req)
+}
+
+// CreatePipeline calls calculator.v1.CalculatorService.CreatePipeline.
+func (c *calculatorServiceClient) CreatePipeline(ctx context.Context, req *connect_go.Request[v1.CreatePipelineRequest]) (*connect_go.Response[v1.CreatePipelineResponse], error) {
+	return c.createPipeline.CallUnary(ctx, req)
+}
+
+// GetPipeline calls calculator.v1.CalculatorService.GetPipeline.
+func (c *calculatorServiceClient) GetPipeline(ctx context.Context, req *connect_go.Request[v1.GetPipelineRequest]) (*connect_go.Response[v1.GetPipelineResponse], error) {
+	return c.getPipeline.CallUnary(ctx, req)
+}
+
+// CreateSchedule calls calculator.v1.CalculatorService.CreateSchedule.
+func (c *calculatorServiceClient) CreateSchedule(ctx context.Context, req *connect_go.Request[v1.CreateScheduleRequest]) (*connect_go.Response[v1.CreateScheduleResponse], error) {
+	return c.createSchedule.CallUnary(ctx, req)
+}
+
+// GetSchedule calls calculator.v1.CalculatorService.GetSchedule.
+func (c *calculatorServiceClient) GetSchedule(ctx context.Context, req *connect_go.Request[v1.GetScheduleRequest]) (*connect_go.Response[v1.GetScheduleResponse], error) {
+	return c.getSchedule.CallUnary(ctx, req)
+}
+
+// ListSchedules calls calculator.v1.CalculatorService.ListSchedules.
+func (c *calculatorServiceClient) ListSchedules(ctx context.Context, req *connect_go.Request[v1.ListSchedulesRequest]) (*connect_go.Response[v1.ListSchedulesResponse], error) {
+	return c.listSchedules.CallUnary(ctx, req)
+}
+
+// UpdateSchedule calls calculator.v1.CalculatorService.UpdateSchedule.
+func (c *calculatorServiceClient) UpdateSchedule(ctx context.Context, req *connect_go.Request[v1.UpdateScheduleRequest]) (*connect_go.Response[v1.UpdateScheduleResponse], error) {
+	return c.updateSchedule.CallUnary(ctx, req)
+}
+
+// DeleteSchedule calls calculator.v1.CalculatorService.DeleteSchedule.
+func (c *calculatorServiceClient) DeleteSchedule(ctx context.Context, req *connect_go.Request[v1.DeleteScheduleRequest]) (*connect_go.Response[v1.DeleteScheduleResponse], error) {
+	return c.deleteSchedule.CallUnary(ctx, req)
+}
+
 // CalculatorServiceHandler is an implementation of the calculator.v1.CalculatorService service.
 type CalculatorServiceHandler interface {
 	Calculate(context.Context, *connect_go.Request[v1.CalculateRequest]) (*connect_go.Response[v1.CalculateResponse], error)
 	List(context.Context, *connect_go.Request[v1.ListRequest]) (*connect_go.Response[v1.ListResponse], error)
 	Get(context.Context, *connect_go.Request[v1.GetRequest]) (*connect_go.Response[v1.GetResponse], error)
 	Cleanup(context.Context, *connect_go.Request[v1.CleanupRequest]) (*connect_go.Response[v1.CleanupResponse], error)
+	// GetHistory returns every state change of a calculation, oldest first.
+	GetHistory(context.Context, *connect_go.Request[v1.GetHistoryRequest]) (*connect_go.Response[v1.GetHistoryResponse], error)
+	// WatchCalculation streams the history of a calculation and then its changes as they happen, until it completes.
+	WatchCalculation(context.Context, *connect_go.Request[v1.WatchCalculationRequest], *connect_go.ServerStream[v1.WatchCalculationResponse]) error
+	ListFunctions(context.Context, *connect_go.Request[v1.ListFunctionsRequest]) (*connect_go.Response[v1.ListFunctionsResponse], error)
+	// ValidateExpression parses an expression without evaluating it.
+	ValidateExpression(context.Context, *connect_go.Request[v1.ValidateExpressionRequest]) (*connect_go.Response[v1.ValidateExpressionResponse], error)
+	// CreatePipeline starts a pipeline of calculations, steps can use the results of earlier steps as variables.
+	CreatePipeline(context.Context, *connect_go.Request[v1.CreatePipelineRequest]) (*connect_go.Response[v1.CreatePipelineResponse], error)
+	GetPipeline(context.Context, *connect_go.Request[v1.GetPipelineRequest]) (*connect_go.Response[v1.GetPipelineResponse], error)
+	// CreateSchedule runs a calculation at a future time or on a cron schedule, every run is a new calculation.
+	CreateSchedule(context.Context, *connect_go.Request[v1.CreateScheduleRequest]) (*connect_go.Response[v1.CreateScheduleResponse], error)
+	GetSchedule(context.Context, *connect_go.Request[v1.GetScheduleRequest]) (*connect_go.Response[v1.GetScheduleResponse], error)
+	ListSchedules(context.Context, *connect_go.Request[v1.ListSchedulesRequest]) (*connect_go.Response[v1.ListSchedulesResponse], error)
+	// UpdateSchedule replaces the calculation, timing and paused state of a schedule.
+	UpdateSchedule(context.Context, *connect_go.Request[v1.UpdateScheduleRequest]) (*connect_go.Response[v1.UpdateScheduleResponse], error)
+	DeleteSchedule(context.Context, *connect_go.Request[v1.DeleteScheduleRequest]) (*connect_go.Response[v1.DeleteScheduleResponse], error)
 }
 
 // NewCalculatorServiceHandler builds an HTTP handler from the service implementation. It returns
@@ -150,6 +338,61 @@ func NewCalculatorServiceHandler(svc CalculatorServiceHandler, opts ...connect_g
 		svc.Cleanup,
 		opts...,
 	))
+	mux.Handle(CalculatorServiceGetHistoryProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceGetHistoryProcedure,
+		svc.GetHistory,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceWatchCalculationProcedure, connect_go.NewServerStreamHandler(
+		CalculatorServiceWatchCalculationProcedure,
+		svc.WatchCalculation,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceListFunctionsProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceListFunctionsProcedure,
+		svc.ListFunctions,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceValidateExpressionProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceValidateExpressionProcedure,
+		svc.ValidateExpression,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceCreatePipelineProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceCreatePipelineProcedure,
+		svc.CreatePipeline,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceGetPipelineProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceGetPipelineProcedure,
+		svc.GetPipeline,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceCreateScheduleProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceCreateScheduleProcedure,
+		svc.CreateSchedule,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceGetScheduleProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceGetScheduleProcedure,
+		svc.GetSchedule,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceListSchedulesProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceListSchedulesProcedure,
+		svc.ListSchedules,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceUpdateScheduleProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceUpdateScheduleProcedure,
+		svc.UpdateSchedule,
+		opts...,
+	))
+	mux.Handle(CalculatorServiceDeleteScheduleProcedure, connect_go.NewUnaryHandler(
+		CalculatorServiceDeleteScheduleProcedure,
+		svc.DeleteSchedule,
+		opts...,
+	))
 	return "/calculator.v1.CalculatorService/", mux
 }
 
@@ -171,3 +414,47 @@ func (UnimplementedCalculatorServiceHandler) Get(context.Context, *connect_go.Re
 func (UnimplementedCalculatorServiceHandler) Cleanup(context.Context, *connect_go.Request[v1.CleanupRequest]) (*connect_go.Response[v1.CleanupResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.Cleanup is not implemented"))
 }
+
+func (UnimplementedCalculatorServiceHandler) GetHistory(context.Context, *connect_go.Request[v1.GetHistoryRequest]) (*connect_go.Response[v1.GetHistoryResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.GetHistory is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) WatchCalculation(context.Context, *connect_go.Request[v1.WatchCalculationRequest], *connect_go.ServerStream[v1.WatchCalculationResponse]) error {
+	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.WatchCalculation is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) ListFunctions(context.Context, *connect_go.Request[v1.ListFunctionsRequest]) (*connect_go.Response[v1.ListFunctionsResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.ListFunctions is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) ValidateExpression(context.Context, *connect_go.Request[v1.ValidateExpressionRequest]) (*connect_go.Response[v1.ValidateExpressionResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.ValidateExpression is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) CreatePipeline(context.Context, *connect_go.Request[v1.CreatePipelineRequest]) (*connect_go.Response[v1.CreatePipelineResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.CreatePipeline is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) GetPipeline(context.Context, *connect_go.Request[v1.GetPipelineRequest]) (*connect_go.Response[v1.GetPipelineResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.GetPipeline is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) CreateSchedule(context.Context, *connect_go.Request[v1.CreateScheduleRequest]) (*connect_go.Response[v1.CreateScheduleResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.CreateSchedule is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) GetSchedule(context.Context, *connect_go.Request[v1.GetScheduleRequest]) (*connect_go.Response[v1.GetScheduleResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.GetSchedule is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) ListSchedules(context.Context, *connect_go.Request[v1.ListSchedulesRequest]) (*connect_go.Response[v1.ListSchedulesResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.ListSchedules is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) UpdateSchedule(context.Context, *connect_go.Request[v1.UpdateScheduleRequest]) (*connect_go.Response[v1.UpdateScheduleResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.UpdateSchedule is not implemented"))
+}
+
+func (UnimplementedCalculatorServiceHandler) DeleteSchedule(context.Context, *connect_go.Request[v1.DeleteScheduleRequest]) (*connect_go.Response[v1.DeleteScheduleResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("calculator.v1.CalculatorService.DeleteSchedule is not implemented"))
+}
@@ -3,16 +3,22 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 
+	"github.com/kostyay/otel-demo/common/calc"
 	"github.com/kostyay/otel-demo/common/log"
 	"github.com/kostyay/otel-demo/common/otel"
 	"github.com/kostyay/otel-demo/common/version"
+	"github.com/kostyay/otel-demo/controller/internal/cache"
 	"github.com/kostyay/otel-demo/controller/internal/config"
 	"github.com/kostyay/otel-demo/controller/internal/handler"
 	"github.com/kostyay/otel-demo/controller/internal/math"
+	"github.com/kostyay/otel-demo/controller/internal/pipeline"
+	"github.com/kostyay/otel-demo/controller/internal/scheduler"
 	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 )
@@ -22,20 +28,55 @@ func run(ctx context.Context, cfg *config.Options) error {
 	if err != nil {
 		return fmt.Errorf("unable to initialize storage: %w", err)
 	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.WithError(err).Error("unable to close storage")
+		}
+	}()
 	log.Info("storage initialized")
 
-	m, err := math.New(ctx, cfg, db)
+	limits := calc.Limits{
+		MaxLength:    cfg.Expression.MaxLength,
+		MaxDepth:     cfg.Expression.MaxDepth,
+		MaxOperators: cfg.Expression.MaxOperators,
+	}
+
+	var shared cache.Shared
+	if cfg.Cache.Shared {
+		shared = db
+	}
+	results := cache.New(cfg, shared)
+	quota := tenant.NewQuota(db, cfg.Tenant.MaxPendingCalculations)
+	pipelines := pipeline.New(db, quota)
+
+	m, err := newMath(ctx, cfg, db, results, pipelines, limits)
 	if err != nil {
 		return fmt.Errorf("unable to initialize math agent: %w", err)
 	}
+	defer func() {
+		if err := m.Close(); err != nil {
+			log.WithError(err).Error("unable to close math agent")
+		}
+	}()
 
 	log.Info("math agent initialized")
 
-	controller := handler.New(db, m)
+	if cfg.Scheduler.Enabled {
+		go scheduler.New(cfg, db, m, quota).Run(ctx)
+		log.Info("scheduler started")
+	}
+
+	auth, err := tenant.NewAuthenticator(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("unable to initialize authentication: %w", err)
+	}
+	log.Infof("authenticating tenants, mode=%s", cfg.Auth.Mode)
+
+	controller := handler.New(db, m, results, pipelines, limits, quota)
 	mux := http.NewServeMux()
 	// The generated constructors return a path and a plain net/http
 	// handler.
-	controller.Register(mux)
+	controller.Register(mux, auth)
 	return http.ListenAndServe(
 		cfg.ListenAddr,
 		// For gRPC clients, it's convenient to support HTTP/2 without TLS. You can
@@ -44,6 +85,19 @@ func run(ctx context.Context, cfg *config.Options) error {
 	)
 }
 
+// mathAgent is closed once the server stops, waiting for the calculations it is evaluating.
+type mathAgent interface {
+	handler.Math
+	io.Closer
+}
+
+func newMath(ctx context.Context, cfg *config.Options, db math.Storage, results math.Cache, pipelines math.Pipelines, limits calc.Limits) (mathAgent, error) {
+	if cfg.MathMode == config.MathModeLocal {
+		return math.NewLocal(db, results, pipelines, limits, cfg.MathLocal.Concurrency), nil
+	}
+	return math.New(ctx, cfg, db, results, pipelines)
+}
+
 func main() {
 	ctx := context.Background()
 	log.Info(fmt.Sprintf("Starting server - %s;%s", version.ServiceName, version.Version))
@@ -54,6 +108,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(ctx, cfg, os.Args[2:]); err != nil {
+			log.WithError(err).Error("failed to migrate")
+			os.Exit(1)
+		}
+		return
+	}
+
 	tp, err := otel.InitTracing(ctx, otel.Config{
 		ProjectID:      cfg.GoogleCloudProject,
 		ServiceName:    version.ServiceName,
@@ -72,6 +134,22 @@ func main() {
 
 	log.Info("Trace initialized")
 
+	mp, err := otel.InitMetrics(ctx, otel.Config{
+		ProjectID:      cfg.GoogleCloudProject,
+		ServiceName:    version.ServiceName,
+		ServiceVersion: version.Version,
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to init metrics")
+		os.Exit(1)
+	}
+
+	defer func() {
+		if err := mp.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("failed to shutdown metrics")
+		}
+	}()
+
 	if err := run(ctx, cfg); err != nil {
 		log.WithError(err).Error("failed to run server")
 		os.Exit(1)
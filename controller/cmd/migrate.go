@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/kostyay/otel-demo/common/log"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+)
+
+const migrateUsage = "usage: controller migrate [up | down [n] | status | row-level-security <login role>]"
+
+// migrate runs the migrate command: up applies every pending migration, down reverts the latest n (1 by
+// default) and status reports the schema version. row-level-security makes postgres enforce row level
+// security for a controller logging in as the given role when DB_ROW_LEVEL_SECURITY is set, and stops enforcing
+// it otherwise.
+func migrate(ctx context.Context, cfg *config.Options, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	migrator, err := storage.NewMigrator(cfg)
+	if err != nil {
+		return fmt.Errorf("unable to initialize migrator: %w", err)
+	}
+
+	switch command {
+	case "up":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Infof("applied %d migrations %v, schema is at version %d", len(applied), applied, migrator.Latest())
+	case "down":
+		n := 1
+		if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		if len(args) == 2 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("number of migrations to revert must be a positive integer, got %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, n)
+		if err != nil {
+			return err
+		}
+		log.Infof("reverted %d migrations %v", len(reverted), reverted)
+	case "status":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		current, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		log.Infof("schema is at version %d, latest version is %d", current, migrator.Latest())
+	case "row-level-security":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		if err := migrator.RowLevelSecurity(ctx, cfg.DB.RowLevelSecurity, args[1]); err != nil {
+			return err
+		}
+		log.Infof("row level security enforced: %t", cfg.DB.RowLevelSecurity)
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Command storagetest runs the storage conformance suite against the backend configured by DB_DRIVER and DB_DSN.
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/caarlos0/env/v8"
+	"github.com/kostyay/otel-demo/common/log"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"github.com/kostyay/otel-demo/controller/internal/storage/storagetest"
+)
+
+func main() {
+	ctx := context.Background()
+
+	// Only the database options are needed.
+	cfg := &config.Options{}
+	if err := env.Parse(&cfg.DB); err != nil {
+		log.WithError(err).Error("failed to parse config")
+		os.Exit(1)
+	}
+	if err := config.LoadSecrets(cfg); err != nil {
+		log.WithError(err).Error("failed to load secrets")
+		os.Exit(1)
+	}
+
+	if cfg.DB.Driver != config.DBDriverMemory {
+		migrator, err := storage.NewMigrator(cfg)
+		if err != nil {
+			log.WithError(err).Error("failed to initialize migrator")
+			os.Exit(1)
+		}
+		if _, err := migrator.Up(ctx); err != nil {
+			log.WithError(err).Error("failed to migrate")
+			os.Exit(1)
+		}
+	}
+
+	s, err := storage.New(cfg)
+	if err != nil {
+		log.WithError(err).Error("failed to initialize storage")
+		os.Exit(1)
+	}
+
+	err = storagetest.Run(ctx, s)
+	if closeErr := s.Close(); closeErr != nil {
+		log.WithError(closeErr).Error("failed to close storage")
+	}
+	if err != nil {
+		log.WithError(err).Error("storage doesn't conform")
+		os.Exit(1)
+	}
+	log.Infof("storage %s conforms", cfg.DB.Driver)
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/kostyay/otel-demo/common/calc"
+	"github.com/kostyay/otel-demo/common/log"
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	"github.com/kostyay/otel-demo/controller/api/calcpb"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	tierMemory = "memory"
+	tierShared = "postgres"
+)
+
+var lookups, _ = otelcommon.Meter().Int64Counter("calculation.cache.lookups", metric.WithDescription("Number of calculation result cache lookups"))
+
+// Shared is the optional cache tier shared by all controller instances.
+type Shared interface {
+	GetCachedResult(ctx context.Context, key string) (*domain.CacheEntry, error)
+	PutCachedResult(ctx context.Context, entry *domain.CacheEntry) error
+}
+
+// Cache remembers calculation results by content, first in memory and then in the shared tier if there is one.
+type Cache struct {
+	enabled bool
+	ttl     time.Duration
+	memory  *lru
+	shared  Shared
+}
+
+// New creates the result cache, shared may be nil.
+func New(cfg *config.Options, shared Shared) *Cache {
+	return &Cache{
+		enabled: cfg.Cache.Enabled,
+		ttl:     cfg.Cache.TTL,
+		memory:  newLRU(cfg.Cache.Size),
+		shared:  shared,
+	}
+}
+
+// Key addresses a calculation by its owner, normalized expression, variables, evaluation mode, output unit and
+// the function catalog it is evaluated with. The owner keeps tenants from learning what others calculated.
+func Key(calculation *pb.Calculation) (string, error) {
+	root, err := calc.Parse(calculation.GetExpression(), calcpb.Mode(calculation.GetMode()))
+	if err != nil {
+		return "", err
+	}
+
+	content := &pb.Calculation{
+		Owner:      calculation.GetOwner(),
+		Expression: root.String(),
+		Variables:  calculation.GetVariables(),
+		Mode:       pb.EvaluationMode_EVALUATION_MODE_FLOAT,
+	}
+	switch calculation.GetMode() {
+	case pb.EvaluationMode_EVALUATION_MODE_DECIMAL:
+		content.Mode = calculation.GetMode()
+		content.Decimal = calcpb.NormalizeDecimal(calculation.GetDecimal())
+	case pb.EvaluationMode_EVALUATION_MODE_UNITS:
+		content.Mode = calculation.GetMode()
+		content.OutputUnit = calculation.GetOutputUnit()
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal cache key: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(calc.CatalogVersion))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Lookup returns the cached result of an identical calculation, the span in ctx records whether it was a hit.
+func (c *Cache) Lookup(ctx context.Context, calculation *pb.Calculation) (*pb.Value, bool) {
+	if !c.enabled {
+		return nil, false
+	}
+
+	key, err := Key(calculation)
+	if err != nil {
+		return nil, false
+	}
+
+	if result, ok := c.memory.get(key); ok {
+		c.record(ctx, tierMemory)
+		return result, true
+	}
+
+	if c.shared != nil {
+		entry, err := c.shared.GetCachedResult(ctx, key)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Error("unable to look up shared cache")
+		} else if entry != nil {
+			c.memory.put(key, entry.Result.Proto(), entry.ExpiresAt)
+			c.record(ctx, tierShared)
+			return entry.Result.Proto(), true
+		}
+	}
+
+	c.record(ctx, "")
+	return nil, false
+}
+
+// Store remembers the result of a completed calculation. Failed calculations aren't cached.
+func (c *Cache) Store(ctx context.Context, calculation *pb.Calculation) {
+	if !c.enabled || calculation.GetResult() == nil || calculation.GetError() != nil {
+		return
+	}
+
+	key, err := Key(calculation)
+	if err != nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(c.ttl)
+	c.memory.put(key, calculation.GetResult(), expiresAt)
+
+	if c.shared != nil {
+		err := c.shared.PutCachedResult(ctx, &domain.CacheEntry{Key: key, Result: domain.NewResult(calculation.GetResult()), ExpiresAt: expiresAt})
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Error("unable to store shared cache entry")
+		}
+	}
+}
+
+// record annotates the current span and counts the lookup, tier is empty for misses.
+func (c *Cache) record(ctx context.Context, tier string) {
+	hit := tier != ""
+	attrs := []attribute.KeyValue{attribute.Bool("cache.hit", hit)}
+	if hit {
+		attrs = append(attrs, attribute.String("cache.tier", tier))
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+	lookups.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+)
+
+type lruEntry struct {
+	key       string
+	result    *pb.Value
+	expiresAt time.Time
+}
+
+// lru is a fixed size in-memory cache that evicts the least recently used entry first.
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *lru) get(key string) (*pb.Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.result, true
+}
+
+func (c *lru) put(key string, result *pb.Value, expiresAt time.Time) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = &lruEntry{key: key, result: result, expiresAt: expiresAt}
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, result: result, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
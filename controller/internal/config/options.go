@@ -1,16 +1,147 @@
 package config
 
-import "github.com/caarlos0/env/v8"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env/v8"
+)
+
+const (
+	// MathModePubSub dispatches calculations to the math cloud function over Pub/Sub.
+	MathModePubSub = "pubsub"
+	// MathModeLocal evaluates calculations inside the controller process.
+	MathModeLocal = "local"
+)
+
+const (
+	// DBDriverCloudSQL connects to a Cloud SQL instance through the Cloud SQL proxy dialer.
+	DBDriverCloudSQL = "cloudsql"
+	// DBDriverPostgres connects to any postgres database using DB_DSN.
+	DBDriverPostgres = "postgres"
+	// DBDriverSQLite opens the sqlite database file DB_DSN.
+	DBDriverSQLite = "sqlite"
+	// DBDriverMemory keeps everything in memory, for local development.
+	DBDriverMemory = "memory"
+)
+
+const (
+	// AuthModeNone serves requests without a tenant, the owner of a calculation is whatever the client says.
+	AuthModeNone = "none"
+	// AuthModeHeader trusts the identity a proxy in front of the controller puts in AUTH_HEADER.
+	AuthModeHeader = "header"
+	// AuthModeIDToken verifies the Google-signed ID token of the Authorization header against AUTH_AUDIENCE.
+	AuthModeIDToken = "idtoken"
+)
+
+// Secret is an option that is never printed, so logging the options doesn't leak it.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
 
 type Options struct {
 	DB struct {
-		User                   string `env:"DB_USER" envDefault:"postgres"`
-		Password               string `env:"DB_PASS"`
+		Driver string `env:"DB_DRIVER" envDefault:"cloudsql"`
+		// DSN is the sqlite file, or a postgres connection string used instead of the options below.
+		DSN      string `env:"DB_DSN"`
+		Host     string `env:"DB_HOST"`
+		Port     int    `env:"DB_PORT" envDefault:"5432"`
+		User     string `env:"DB_USER" envDefault:"postgres"`
+		Password Secret `env:"DB_PASS"`
+		// PasswordFile holds the password instead of DB_PASS, e.g. a Secret Manager secret mounted by Cloud Run
+		// or a Kubernetes secret. A trailing newline is ignored.
+		PasswordFile           string `env:"DB_PASS_FILE"`
 		Name                   string `env:"DB_NAME" envDefault:"postgres"`
 		InstanceConnectionName string `env:"INSTANCE_CONNECTION_NAME"`
+		// SSLMode is the postgres sslmode. When empty, cloudsql disables it as the Cloud SQL dialer encrypts
+		// connections itself, and postgres uses the driver default.
+		SSLMode string `env:"DB_SSLMODE"`
+		// Params are extra postgres connection parameters, as in "connect_timeout:5,application_name:controller".
+		Params map[string]string `env:"DB_PARAMS"`
+		// Pool configures the connection pool of SQL databases, with the semantics of database/sql.
+		Pool struct {
+			MaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS" envDefault:"10"`
+			MaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS" envDefault:"5"`
+			ConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" envDefault:"30m"`
+			ConnMaxIdleTime time.Duration `env:"DB_CONN_MAX_IDLE_TIME" envDefault:"5m"`
+		}
+		// ReplicaDSNs are read replicas of the database, opened with the same driver. List and Get read from
+		// them while serving a request.
+		ReplicaDSNs []string `env:"DB_REPLICA_DSNS" envSeparator:","`
+		// ReadYourWritesWindow keeps a tenant reading from the primary for this long after it wrote, so it
+		// sees its own writes despite replication lag. Without authenticated tenants every request reads from
+		// the primary for this long after any write. Zero disables it.
+		ReadYourWritesWindow time.Duration `env:"DB_READ_YOUR_WRITES_WINDOW" envDefault:"5s"`
+		// SlowQueryThreshold logs queries taking at least this long, zero disables it.
+		SlowQueryThreshold time.Duration `env:"DB_SLOW_QUERY_THRESHOLD" envDefault:"200ms"`
+		// SQLCommentTraceParent adds the traceparent to the sqlcommenter comment of statements. It makes every
+		// statement text unique, so statements run on every request or tick always leave it out.
+		SQLCommentTraceParent bool `env:"DB_SQLCOMMENT_TRACEPARENT" envDefault:"true"`
+		// RowLevelSecurity sets the tenant of every request in postgres, so the row level security policies
+		// isolate tenants even from queries the controller forgets to filter. The database has to be set up with
+		// the row-level-security step of the migrate command first.
+		RowLevelSecurity bool `env:"DB_ROW_LEVEL_SECURITY" envDefault:"false"`
+	}
+	// Auth selects how the tenant owning everything a request reads and writes is authenticated.
+	Auth struct {
+		Mode string `env:"AUTH_MODE" envDefault:"none"`
+		// Header holds the tenant in header mode, by default the user Identity-Aware Proxy authenticated.
+		Header string `env:"AUTH_HEADER" envDefault:"X-Goog-Authenticated-User-Email"`
+		// Audience is the audience of the ID tokens accepted in idtoken mode, usually the URL of the service.
+		Audience string `env:"AUTH_AUDIENCE"`
+	}
+	// Tenant bounds what a single tenant can do, zero disables a limit.
+	Tenant struct {
+		MaxPendingCalculations int `env:"TENANT_MAX_PENDING_CALCULATIONS" envDefault:"100"`
+	}
+	MathPublish struct {
+		MaxAttempts      int           `env:"MATH_PUBLISH_MAX_ATTEMPTS" envDefault:"5"`
+		InitialBackoff   time.Duration `env:"MATH_PUBLISH_INITIAL_BACKOFF" envDefault:"100ms"`
+		MaxBackoff       time.Duration `env:"MATH_PUBLISH_MAX_BACKOFF" envDefault:"5s"`
+		BreakerThreshold int           `env:"MATH_PUBLISH_BREAKER_THRESHOLD" envDefault:"5"`
+		BreakerCooldown  time.Duration `env:"MATH_PUBLISH_BREAKER_COOLDOWN" envDefault:"30s"`
+	}
+	// MathLocal configures the evaluation of calculations in MATH_MODE=local.
+	MathLocal struct {
+		// Concurrency bounds how many calculations are evaluated at once, the others wait for a free slot.
+		Concurrency int `env:"MATH_LOCAL_CONCURRENCY" envDefault:"8"`
 	}
-	MathRequestTopic       string `env:"MATH_REQUEST_TOPIC,required"`
-	MathResultSubscription string `env:"MATH_RESULT_SUBSCRIPTION,required"`
+	// Expression bounds what Calculate accepts, zero disables a limit.
+	Expression struct {
+		MaxLength    int `env:"EXPRESSION_MAX_LENGTH" envDefault:"1024"`
+		MaxDepth     int `env:"EXPRESSION_MAX_DEPTH" envDefault:"32"`
+		MaxOperators int `env:"EXPRESSION_MAX_OPERATORS" envDefault:"256"`
+	}
+	// Cache reuses the results of identical calculations.
+	Cache struct {
+		Enabled bool          `env:"CACHE_ENABLED" envDefault:"true"`
+		Size    int           `env:"CACHE_SIZE" envDefault:"1000"`
+		TTL     time.Duration `env:"CACHE_TTL" envDefault:"1h"`
+		// Shared adds a Postgres tier shared by all controller instances.
+		Shared bool `env:"CACHE_SHARED" envDefault:"false"`
+	}
+	// Scheduler runs scheduled calculations on the controller instance holding the scheduler lease.
+	Scheduler struct {
+		Enabled   bool          `env:"SCHEDULER_ENABLED" envDefault:"true"`
+		Interval  time.Duration `env:"SCHEDULER_INTERVAL" envDefault:"10s"`
+		LeaseTTL  time.Duration `env:"SCHEDULER_LEASE_TTL" envDefault:"30s"`
+		BatchSize int           `env:"SCHEDULER_BATCH_SIZE" envDefault:"100"`
+	}
+	MathMode               string `env:"MATH_MODE" envDefault:"pubsub"`
+	MathRequestTopic       string `env:"MATH_REQUEST_TOPIC"`
+	MathResultSubscription string `env:"MATH_RESULT_SUBSCRIPTION"`
 	GoogleCloudProject     string `env:"GOOGLE_CLOUD_PROJECT,required"`
 	ListenAddr             string `env:"LISTEN_ADDR" envDefault:"0.0.0.0:8080"`
 }
@@ -20,5 +151,74 @@ func Parse() (*Options, error) {
 	if err := env.Parse(opts); err != nil {
 		return nil, err
 	}
+
+	switch opts.MathMode {
+	case MathModePubSub:
+		if opts.MathRequestTopic == "" || opts.MathResultSubscription == "" {
+			return nil, errors.New("MATH_REQUEST_TOPIC and MATH_RESULT_SUBSCRIPTION are required in pubsub math mode")
+		}
+	case MathModeLocal:
+		if opts.MathLocal.Concurrency <= 0 {
+			return nil, errors.New("MATH_LOCAL_CONCURRENCY must be positive")
+		}
+	default:
+		return nil, errors.New("MATH_MODE must be either pubsub or local")
+	}
+
+	switch opts.DB.Driver {
+	case DBDriverPostgres:
+		if opts.DB.DSN == "" && opts.DB.Host == "" {
+			return nil, errors.New("DB_DSN or DB_HOST is required by the postgres database driver")
+		}
+	case DBDriverSQLite:
+		if opts.DB.DSN == "" {
+			return nil, errors.New("DB_DSN is required by the sqlite database driver")
+		}
+	case DBDriverCloudSQL, DBDriverMemory:
+	default:
+		return nil, errors.New("DB_DRIVER must be one of cloudsql, postgres, sqlite or memory")
+	}
+	if opts.DB.Driver == DBDriverMemory && len(opts.DB.ReplicaDSNs) > 0 {
+		return nil, errors.New("DB_REPLICA_DSNS are not supported by the memory database driver")
+	}
+
+	if opts.DB.RowLevelSecurity && opts.DB.Driver != DBDriverCloudSQL && opts.DB.Driver != DBDriverPostgres {
+		return nil, errors.New("DB_ROW_LEVEL_SECURITY is only supported by the cloudsql and postgres database drivers")
+	}
+
+	switch opts.Auth.Mode {
+	case AuthModeIDToken:
+		if opts.Auth.Audience == "" {
+			return nil, errors.New("AUTH_AUDIENCE is required in idtoken auth mode")
+		}
+	case AuthModeHeader:
+		if opts.Auth.Header == "" {
+			return nil, errors.New("AUTH_HEADER is required in header auth mode")
+		}
+	case AuthModeNone:
+	default:
+		return nil, errors.New("AUTH_MODE must be one of none, header or idtoken")
+	}
+
+	if err := LoadSecrets(opts); err != nil {
+		return nil, err
+	}
+
 	return opts, nil
 }
+
+// LoadSecrets reads the secrets kept in files into opts.
+func LoadSecrets(opts *Options) error {
+	if opts.DB.PasswordFile == "" {
+		return nil
+	}
+	if opts.DB.Password != "" {
+		return errors.New("only one of DB_PASS and DB_PASS_FILE can be set")
+	}
+	password, err := os.ReadFile(opts.DB.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("unable to read DB_PASS_FILE: %w", err)
+	}
+	opts.DB.Password = Secret(strings.TrimRight(string(password), "\r\n"))
+	return nil
+}
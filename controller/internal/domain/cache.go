@@ -0,0 +1,10 @@
+package domain
+
+import "time"
+
+// CacheEntry is a calculation result shared between controller instances, addressed by cache key.
+type CacheEntry struct {
+	Key       string  `gorm:"primaryKey"`
+	Result    *Result `gorm:"type:jsonb"`
+	ExpiresAt time.Time
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 )
@@ -12,26 +13,51 @@ type Calculation struct {
 	gorm.Model
 	Owner       string
 	Expression  string
-	Result      *float64
+	Variables   Variables `gorm:"type:jsonb"`
+	Result      *Result   `gorm:"column:result_value;type:jsonb"`
+	Error       *Failure  `gorm:"type:jsonb"`
 	CompletedAt *time.Time
+	Mode        pb.EvaluationMode
+	// DecimalScale and DecimalRounding are only set in decimal mode.
+	DecimalScale    uint32
+	DecimalRounding pb.RoundingMode
+	// OutputUnit is the unit a units mode result is converted to, empty for SI base units.
+	OutputUnit string
+	// ServedFromCache is set when the result was reused instead of evaluating the expression.
+	ServedFromCache bool
+	// ScheduleID is set for calculations run by a schedule.
+	ScheduleID *uint `gorm:"index"`
 }
 
 func (c *Calculation) Proto() *pb.Calculation {
 	result := &pb.Calculation{
-		Id:         uint32(c.ID),
-		Owner:      c.Owner,
-		Expression: c.Expression,
-		UpdatedAt:  timestamppb.New(c.UpdatedAt),
-		CreatedAt:  timestamppb.New(c.CreatedAt),
+		Id:              uint32(c.ID),
+		Owner:           c.Owner,
+		Expression:      c.Expression,
+		Variables:       c.Variables,
+		Result:          c.Result.Proto(),
+		Error:           c.Error.Proto(),
+		ServedFromCache: c.ServedFromCache,
+		Mode:            c.Mode,
+		OutputUnit:      c.OutputUnit,
+		UpdatedAt:       timestamppb.New(c.UpdatedAt),
+		CreatedAt:       timestamppb.New(c.CreatedAt),
 	}
 
-	if c.Result != nil {
-		result.Result = *c.Result
+	if c.Mode == pb.EvaluationMode_EVALUATION_MODE_DECIMAL {
+		result.Decimal = &pb.DecimalOptions{
+			Scale:    proto.Uint32(c.DecimalScale),
+			Rounding: c.DecimalRounding,
+		}
 	}
 
 	if c.CompletedAt != nil {
 		result.CompletedAt = timestamppb.New(*c.CompletedAt)
 	}
 
+	if c.ScheduleID != nil {
+		result.ScheduleId = uint32(*c.ScheduleID)
+	}
+
 	return result
 }
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// CalculationEvent is a state change of a calculation. Events are only ever appended, they outlive the
+// calculation they are about.
+type CalculationEvent struct {
+	ID            uint `gorm:"primaryKey"`
+	CalculationID uint `gorm:"index"`
+	Kind          pb.CalculationEventKind
+	// Actor is who caused the change, see WithActor.
+	Actor  string
+	Detail string
+	// TraceID and SpanID identify the span the change was made in.
+	TraceID   string
+	SpanID    string
+	CreatedAt time.Time
+}
+
+func (e *CalculationEvent) Proto() *pb.CalculationEvent {
+	return &pb.CalculationEvent{
+		Id:            uint32(e.ID),
+		CalculationId: uint32(e.CalculationID),
+		Kind:          e.Kind,
+		CreatedAt:     timestamppb.New(e.CreatedAt),
+		Actor:         e.Actor,
+		Detail:        e.Detail,
+		TraceId:       e.TraceID,
+		SpanId:        e.SpanID,
+	}
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx whose calculation events are recorded as caused by actor, like "scheduler".
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or an empty string if there is none.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
@@ -0,0 +1,10 @@
+package domain
+
+import "time"
+
+// Lease elects a single controller instance to do some work, the holder has to renew it before it expires.
+type Lease struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string
+	ExpiresAt time.Time
+}
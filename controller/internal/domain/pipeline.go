@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Pipeline is a set of calculations where later steps use the results of earlier ones.
+type Pipeline struct {
+	gorm.Model
+	Owner     string
+	Variables Variables `gorm:"type:jsonb"`
+	// Mode through DecimalRounding are copied into the calculation of every step.
+	Mode            pb.EvaluationMode
+	DecimalScale    uint32
+	DecimalRounding pb.RoundingMode
+	Steps           []*PipelineStep
+	// TraceParent is the W3C trace context of the pipeline span, every step is traced as its child.
+	TraceParent string
+	Failed      bool
+	CompletedAt *time.Time
+}
+
+// PipelineStep is a step of a pipeline, CalculationID is set once it has been dispatched.
+type PipelineStep struct {
+	ID         uint `gorm:"primaryKey"`
+	PipelineID uint `gorm:"index"`
+	Position   int
+	Name       string
+	Expression string
+	DependsOn  StepNames `gorm:"type:jsonb"`
+	// OutputUnit is the unit a units mode result is converted to, empty for SI base units.
+	OutputUnit    string
+	CalculationID *uint `gorm:"index"`
+	Calculation   *Calculation
+}
+
+// NewCalculation creates the calculation of step, evaluating its expression with variables.
+func (p *Pipeline) NewCalculation(step *PipelineStep, variables Variables) *Calculation {
+	return &Calculation{
+		Owner:           p.Owner,
+		Expression:      step.Expression,
+		Variables:       variables,
+		Mode:            p.Mode,
+		DecimalScale:    p.DecimalScale,
+		DecimalRounding: p.DecimalRounding,
+		OutputUnit:      step.OutputUnit,
+	}
+}
+
+func (p *Pipeline) Proto() *pb.Pipeline {
+	result := &pb.Pipeline{
+		Id:        uint32(p.ID),
+		Owner:     p.Owner,
+		Variables: p.Variables,
+		Status:    pb.PipelineStatus_PIPELINE_STATUS_RUNNING,
+		Mode:      p.Mode,
+		CreatedAt: timestamppb.New(p.CreatedAt),
+	}
+
+	if p.Mode == pb.EvaluationMode_EVALUATION_MODE_DECIMAL {
+		result.Decimal = &pb.DecimalOptions{
+			Scale:    proto.Uint32(p.DecimalScale),
+			Rounding: p.DecimalRounding,
+		}
+	}
+
+	switch {
+	case p.Failed:
+		result.Status = pb.PipelineStatus_PIPELINE_STATUS_FAILED
+	case p.CompletedAt != nil:
+		result.Status = pb.PipelineStatus_PIPELINE_STATUS_SUCCEEDED
+	}
+	if p.CompletedAt != nil {
+		result.CompletedAt = timestamppb.New(*p.CompletedAt)
+	}
+
+	for _, step := range p.Steps {
+		state := &pb.PipelineStepState{
+			Name:       step.Name,
+			Expression: step.Expression,
+			DependsOn:  step.DependsOn,
+			OutputUnit: step.OutputUnit,
+		}
+		if step.Calculation != nil {
+			state.Calculation = step.Calculation.Proto()
+		}
+		result.Steps = append(result.Steps, state)
+	}
+
+	return result
+}
+
+// StepNames are the names of pipeline steps, stored as a JSON array.
+type StepNames []string
+
+func (n StepNames) Value() (driver.Value, error) {
+	if n == nil {
+		n = StepNames{}
+	}
+	b, err := json.Marshal([]string(n))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (n *StepNames) Scan(src interface{}) error {
+	b, err := jsonBytes(src)
+	if err != nil || b == nil {
+		*n = nil
+		return err
+	}
+
+	if err := json.Unmarshal(b, (*[]string)(n)); err != nil {
+		return fmt.Errorf("unable to unmarshal step names: %w", err)
+	}
+	return nil
+}
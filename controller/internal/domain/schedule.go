@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// Schedule runs a calculation once at NextRunAt, or repeatedly when Cron is set.
+type Schedule struct {
+	gorm.Model
+	// Owner through OutputUnit are copied into the calculation of every run.
+	Owner           string
+	Expression      string
+	Variables       Variables `gorm:"type:jsonb"`
+	Mode            pb.EvaluationMode
+	DecimalScale    uint32
+	DecimalRounding pb.RoundingMode
+	OutputUnit      string
+	// Cron is empty for one-off schedules, RunAt is only set for them.
+	Cron              string
+	RunAt             *time.Time
+	Paused            bool
+	NextRunAt         *time.Time `gorm:"index"`
+	LastRunAt         *time.Time
+	LastCalculationID *uint
+}
+
+// NewCalculation creates the calculation of a single run.
+func (s *Schedule) NewCalculation() *Calculation {
+	id := s.ID
+	return &Calculation{
+		Owner:           s.Owner,
+		Expression:      s.Expression,
+		Variables:       s.Variables,
+		Mode:            s.Mode,
+		DecimalScale:    s.DecimalScale,
+		DecimalRounding: s.DecimalRounding,
+		OutputUnit:      s.OutputUnit,
+		ScheduleID:      &id,
+	}
+}
+
+func (s *Schedule) Proto() *pb.Schedule {
+	calculation := s.NewCalculation().Proto()
+	result := &pb.Schedule{
+		Id: uint32(s.ID),
+		Request: &pb.CalculateRequest{
+			Expression: calculation.Expression,
+			Owner:      calculation.Owner,
+			Variables:  calculation.Variables,
+			Mode:       calculation.Mode,
+			Decimal:    calculation.Decimal,
+			OutputUnit: calculation.OutputUnit,
+		},
+		Paused:    s.Paused,
+		CreatedAt: timestamppb.New(s.CreatedAt),
+		UpdatedAt: timestamppb.New(s.UpdatedAt),
+	}
+
+	if s.Cron != "" {
+		result.Timing = &pb.Schedule_Cron{Cron: s.Cron}
+	} else if s.RunAt != nil {
+		result.Timing = &pb.Schedule_RunAt{RunAt: timestamppb.New(*s.RunAt)}
+	}
+	if s.NextRunAt != nil {
+		result.NextRunAt = timestamppb.New(*s.NextRunAt)
+	}
+	if s.LastRunAt != nil {
+		result.LastRunAt = timestamppb.New(*s.LastRunAt)
+	}
+	if s.LastCalculationID != nil {
+		result.LastCalculationId = uint32(*s.LastCalculationID)
+	}
+
+	return result
+}
@@ -0,0 +1,158 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Variables are the named values a calculation expression can reference, stored as a JSON object.
+type Variables map[string]*pb.Value
+
+func (v Variables) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	raw := make(map[string]json.RawMessage, len(v))
+	for name, variable := range v {
+		b, err := protojson.Marshal(variable)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal variable %q: %w", name, err)
+		}
+		raw[name] = b
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (v *Variables) Scan(src interface{}) error {
+	b, err := jsonBytes(src)
+	if err != nil || b == nil {
+		*v = nil
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("unable to unmarshal variables: %w", err)
+	}
+
+	result := make(Variables, len(raw))
+	for name, r := range raw {
+		variable := &pb.Value{}
+		if err := protojson.Unmarshal(r, variable); err != nil {
+			return fmt.Errorf("unable to unmarshal variable %q: %w", name, err)
+		}
+		result[name] = variable
+	}
+	*v = result
+	return nil
+}
+
+// Result is the typed outcome of a calculation, stored as JSON so integers keep their precision.
+// It lives in the result_value column, the legacy result column only ever held doubles.
+type Result struct {
+	value *pb.Value
+}
+
+func NewResult(value *pb.Value) *Result {
+	if value == nil {
+		return nil
+	}
+	return &Result{value: value}
+}
+
+func (r *Result) Proto() *pb.Value {
+	if r == nil {
+		return nil
+	}
+	return r.value
+}
+
+func (r Result) Value() (driver.Value, error) {
+	b, err := protojson.Marshal(r.value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal result: %w", err)
+	}
+	return string(b), nil
+}
+
+func (r *Result) Scan(src interface{}) error {
+	b, err := jsonBytes(src)
+	if err != nil {
+		return err
+	}
+
+	r.value = &pb.Value{}
+	if b == nil {
+		return nil
+	}
+	if err := protojson.Unmarshal(b, r.value); err != nil {
+		return fmt.Errorf("unable to unmarshal result: %w", err)
+	}
+	return nil
+}
+
+// Failure explains why a calculation failed, stored as JSON.
+type Failure struct {
+	err *pb.EvaluationError
+}
+
+func NewFailure(err *pb.EvaluationError) *Failure {
+	if err == nil {
+		return nil
+	}
+	return &Failure{err: err}
+}
+
+func (f *Failure) Proto() *pb.EvaluationError {
+	if f == nil {
+		return nil
+	}
+	return f.err
+}
+
+func (f Failure) Value() (driver.Value, error) {
+	b, err := protojson.Marshal(f.err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal failure: %w", err)
+	}
+	return string(b), nil
+}
+
+func (f *Failure) Scan(src interface{}) error {
+	b, err := jsonBytes(src)
+	if err != nil {
+		return err
+	}
+
+	f.err = &pb.EvaluationError{}
+	if b == nil {
+		return nil
+	}
+	if err := protojson.Unmarshal(b, f.err); err != nil {
+		return fmt.Errorf("unable to unmarshal failure: %w", err)
+	}
+	return nil
+}
+
+func jsonBytes(src interface{}) ([]byte, error) {
+	switch src := src.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return src, nil
+	case string:
+		return []byte(src), nil
+	default:
+		return nil, fmt.Errorf("unsupported JSON column type %T", src)
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	context "context"
+	"errors"
+	"strconv"
+	"time"
+
+	connect_go "github.com/bufbuild/connect-go"
+	"github.com/kostyay/otel-demo/common/log"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// retryDelay is how long clients are asked to wait before retrying when the storage is unavailable.
+const retryDelay = time.Second
+
+// errorCodes are the connect codes of the storage error kinds.
+var errorCodes = map[error]connect_go.Code{
+	storage.ErrNotFound:      connect_go.CodeNotFound,
+	storage.ErrAlreadyExists: connect_go.CodeAlreadyExists,
+	storage.ErrConflict:      connect_go.CodeAborted,
+	storage.ErrUnavailable:   connect_go.CodeUnavailable,
+}
+
+// errorInterceptor translates the storage errors returned by handlers into connect errors, so clients get a
+// meaningful code instead of CodeUnknown. Errors that already are connect errors are returned as they are.
+type errorInterceptor struct{}
+
+func newErrorInterceptor() *errorInterceptor {
+	return &errorInterceptor{}
+}
+
+func (i *errorInterceptor) WrapUnary(next connect_go.UnaryFunc) connect_go.UnaryFunc {
+	return func(ctx context.Context, req connect_go.AnyRequest) (connect_go.AnyResponse, error) {
+		res, err := next(ctx, req)
+		return res, translateError(ctx, err)
+	}
+}
+
+func (i *errorInterceptor) WrapStreamingClient(next connect_go.StreamingClientFunc) connect_go.StreamingClientFunc {
+	return next
+}
+
+func (i *errorInterceptor) WrapStreamingHandler(next connect_go.StreamingHandlerFunc) connect_go.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect_go.StreamingHandlerConn) error {
+		return translateError(ctx, next(ctx, conn))
+	}
+}
+
+// translateError returns err as a connect error if it is a storage error.
+func translateError(ctx context.Context, err error) error {
+	var connectErr *connect_go.Error
+	var storageErr *storage.Error
+	if err == nil || errors.As(err, &connectErr) || !errors.As(err, &storageErr) {
+		return err
+	}
+	return storageError(ctx, err, storageErr)
+}
+
+// storageError records err on the span of the request and wraps it for the client. The database error stays
+// in the logs and the trace, the client only gets the description and details of the error.
+func storageError(ctx context.Context, err error, storageErr *storage.Error) *connect_go.Error {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err, trace.WithAttributes(
+		attribute.String("storage.error.kind", storageErr.Kind.Error()),
+		attribute.String("storage.resource", storageErr.Resource),
+	))
+	span.SetStatus(codes.Error, storageErr.Description())
+	if storageErr.Kind == storage.ErrUnavailable {
+		log.WithContext(ctx).WithError(err).Error("storage is unavailable")
+	}
+
+	connectErr := connect_go.NewError(errorCodes[storageErr.Kind], errors.New(storageErr.Description()))
+	for _, detail := range errorDetails(storageErr) {
+		if d, detailErr := connect_go.NewErrorDetail(detail); detailErr == nil {
+			connectErr.AddDetail(d)
+		}
+	}
+	return connectErr
+}
+
+func errorDetails(storageErr *storage.Error) []proto.Message {
+	switch storageErr.Kind {
+	case storage.ErrNotFound, storage.ErrAlreadyExists:
+		resource := &errdetails.ResourceInfo{
+			ResourceType: storageErr.Resource,
+			Description:  storageErr.Description(),
+		}
+		if storageErr.ID != 0 {
+			resource.ResourceName = strconv.Itoa(int(storageErr.ID))
+		}
+		return []proto.Message{resource}
+	case storage.ErrConflict:
+		return []proto.Message{&errdetails.ErrorInfo{
+			Reason:   "CONFLICT",
+			Domain:   "calculator.v1",
+			Metadata: map[string]string{"resource": storageErr.Resource},
+		}}
+	case storage.ErrUnavailable:
+		return []proto.Message{&errdetails.RetryInfo{RetryDelay: durationpb.New(retryDelay)}}
+	}
+	return nil
+}
@@ -2,35 +2,67 @@ package handler
 
 import (
 	context "context"
+	"errors"
 	"fmt"
 	"github.com/kostyay/otel-demo/common/log"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"regexp"
+	"time"
 
 	connect_go "github.com/bufbuild/connect-go"
 	otelconnect "github.com/bufbuild/connect-opentelemetry-go"
+	"github.com/kostyay/otel-demo/common/calc"
+	"github.com/kostyay/otel-demo/controller/api/calcpb"
 	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
 	"github.com/kostyay/otel-demo/controller/api/calculator/v1/calculatorv1connect"
 	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/pipeline"
+	"github.com/kostyay/otel-demo/controller/internal/procedure"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
 )
 
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 type Storage interface {
-	CreateCalculation(ctx context.Context, owner, expression string) (*domain.Calculation, error)
+	CreateCalculation(ctx context.Context, calculation *domain.Calculation) error
 	GetCalculation(ctx context.Context, id uint) (*domain.Calculation, error)
 	GetCalculations(ctx context.Context) ([]*domain.Calculation, error)
-	UpdateResult(ctx context.Context, id uint, result float64) error
+	UpdateResult(ctx context.Context, id uint, result *pb.Value) error
+	UpdateError(ctx context.Context, id uint, evalErr *pb.EvaluationError) error
+	GetHistory(ctx context.Context, calculationID uint) ([]*domain.CalculationEvent, error)
+	Subscribe(ctx context.Context) <-chan storage.Change
+	GetPipeline(ctx context.Context, id uint) (*domain.Pipeline, error)
+	CreateSchedule(ctx context.Context, schedule *domain.Schedule) error
+	GetSchedule(ctx context.Context, id uint) (*domain.Schedule, error)
+	GetSchedules(ctx context.Context) ([]*domain.Schedule, error)
+	UpdateSchedule(ctx context.Context, schedule *domain.Schedule) error
+	DeleteSchedule(ctx context.Context, id uint) error
 }
 
 type Math interface {
 	Calculate(ctx context.Context, calculation *pb.Calculation) error
 }
 
+type Cache interface {
+	Lookup(ctx context.Context, calculation *pb.Calculation) (*pb.Value, bool)
+}
+
+type Pipelines interface {
+	Start(ctx context.Context, math pipeline.Math, p *domain.Pipeline) error
+}
+
 type calculator struct {
 	calculatorv1connect.UnimplementedCalculatorServiceHandler
-	db   Storage
-	math Math
+	db        Storage
+	math      Math
+	cache     Cache
+	pipelines Pipelines
+	limits    calc.Limits
+	quota     *tenant.Quota
 }
 
 func (c *calculator) Calculate(ctx context.Context, req *connect_go.Request[pb.CalculateRequest]) (*connect_go.Response[pb.CalculateResponse], error) {
@@ -47,23 +79,50 @@ func (c *calculator) Calculate(ctx context.Context, req *connect_go.Request[pb.C
 		return nil, connect_go.NewError(connect_go.CodeInvalidArgument, fmt.Errorf("owner is invalid"))
 	}
 
+	res, err := c.newCalculation(ctx, span, req.Msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if result, ok := c.cache.Lookup(ctx, res.Proto()); ok {
+		now := time.Now()
+		res.Result = domain.NewResult(result)
+		res.CompletedAt = &now
+		res.ServedFromCache = true
+	} else if err := c.checkQuota(ctx, span, 1); err != nil {
+		return nil, err
+	}
+
 	// some span events
 	span.AddEvent("Creating calculation in database")
-	res, err := c.db.CreateCalculation(ctx, req.Msg.GetOwner(), req.Msg.GetExpression())
-	if err != nil {
+	if err = c.db.CreateCalculation(ctx, res); err != nil {
 		return nil, err
 	}
 
 	span.SetAttributes(attribute.Int("id", int(res.ID)))
 
+	if res.ServedFromCache {
+		span.AddEvent("Calculation served from cache")
+		return connect_go.NewResponse(&pb.CalculateResponse{Id: uint32(res.ID)}), nil
+	}
+
 	span.AddEvent(fmt.Sprintf("Dispatching calculation %d reqeust to math service", res.ID))
 
 	err = c.math.Calculate(ctx, &pb.Calculation{
 		Id:         uint32(res.ID),
 		Owner:      res.Owner,
 		Expression: res.Expression,
+		Variables:  res.Variables,
+		Mode:       res.Mode,
+		Decimal:    res.Proto().GetDecimal(),
+		OutputUnit: res.OutputUnit,
 	})
 	if err != nil {
+		// The calculation will never be evaluated, fail it so it doesn't count against the quota.
+		evalErr := &pb.EvaluationError{Kind: pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_RUNTIME, Message: fmt.Sprintf("unable to dispatch: %s", err)}
+		if updateErr := c.db.UpdateError(ctx, res.ID, evalErr); updateErr != nil {
+			log.WithContext(ctx).WithError(updateErr).Error("unable to update result")
+		}
 		return nil, err
 	}
 
@@ -74,6 +133,117 @@ func (c *calculator) Calculate(ctx context.Context, req *connect_go.Request[pb.C
 	return response, nil
 }
 
+// newCalculation validates req and creates the calculation it submits, annotating span with the outcome.
+func (c *calculator) newCalculation(ctx context.Context, span trace.Span, req *pb.CalculateRequest) (*domain.Calculation, error) {
+	if err := validateVariables(span, req.GetVariables()); err != nil {
+		return nil, err
+	}
+	calculationOwner, err := owner(ctx, span, req.GetOwner())
+	if err != nil {
+		return nil, err
+	}
+
+	res := &domain.Calculation{
+		Owner:      calculationOwner,
+		Expression: req.GetExpression(),
+		Variables:  req.GetVariables(),
+	}
+	if err := evaluationMode(span, req.GetMode(), req.GetDecimal(), res); err != nil {
+		return nil, err
+	}
+	if err := outputUnit(span, res.Mode, req.GetOutputUnit()); err != nil {
+		return nil, err
+	}
+	res.OutputUnit = req.GetOutputUnit()
+	span.SetAttributes(attribute.String("evaluation.mode", res.Mode.String()))
+
+	expr, err := calcpb.Validate(res.Proto(), c.limits)
+	if err != nil {
+		return nil, expressionError(span, err)
+	}
+	span.SetAttributes(attribute.Int("expression.depth", expr.Depth), attribute.Int("expression.operators", expr.Operators))
+
+	return res, nil
+}
+
+// evaluationMode validates the mode and decimal options of a request and sets them on res.
+func evaluationMode(span trace.Span, mode pb.EvaluationMode, decimal *pb.DecimalOptions, res *domain.Calculation) error {
+	switch mode {
+	case pb.EvaluationMode_EVALUATION_MODE_UNSPECIFIED, pb.EvaluationMode_EVALUATION_MODE_FLOAT:
+		res.Mode = pb.EvaluationMode_EVALUATION_MODE_FLOAT
+	case pb.EvaluationMode_EVALUATION_MODE_DECIMAL:
+		opts := calcpb.NormalizeDecimal(decimal)
+		if opts.GetScale() > calc.MaxDecimalScale {
+			return invalidArgument(span, "decimal options are invalid", fmt.Errorf("scale must not exceed %d", calc.MaxDecimalScale))
+		}
+		if _, ok := pb.RoundingMode_name[int32(opts.GetRounding())]; !ok {
+			return invalidArgument(span, "decimal options are invalid", fmt.Errorf("unknown rounding mode %d", opts.GetRounding()))
+		}
+		res.Mode = pb.EvaluationMode_EVALUATION_MODE_DECIMAL
+		res.DecimalScale = opts.GetScale()
+		res.DecimalRounding = opts.GetRounding()
+	case pb.EvaluationMode_EVALUATION_MODE_UNITS:
+		res.Mode = pb.EvaluationMode_EVALUATION_MODE_UNITS
+	default:
+		return invalidArgument(span, "mode is invalid", fmt.Errorf("unknown evaluation mode %d", mode))
+	}
+	return nil
+}
+
+// outputUnit validates the unit a result in mode is converted to.
+func outputUnit(span trace.Span, mode pb.EvaluationMode, unit string) error {
+	if unit == "" {
+		return nil
+	}
+	if mode != pb.EvaluationMode_EVALUATION_MODE_UNITS {
+		return invalidArgument(span, "output unit is invalid", fmt.Errorf("output unit is only supported in units mode"))
+	}
+	if err := calc.ValidateUnit(unit); err != nil {
+		return invalidArgument(span, "output unit is invalid", err)
+	}
+	return nil
+}
+
+func validateVariables(span trace.Span, variables map[string]*pb.Value) error {
+	values, err := calcpb.Variables(variables)
+	if err == nil {
+		_, err = calc.Variables(values)
+	}
+	if err != nil {
+		return invalidArgument(span, "variables are invalid", err)
+	}
+	for name := range variables {
+		if !variableName.MatchString(name) {
+			return invalidArgument(span, "variables are invalid", fmt.Errorf("variable name %q is not a valid identifier", name))
+		}
+	}
+	return nil
+}
+
+// invalidArgument records err on span and wraps it for the client.
+func invalidArgument(span trace.Span, reason string, err error) *connect_go.Error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, reason)
+	return connect_go.NewError(connect_go.CodeInvalidArgument, err)
+}
+
+// expressionError is invalidArgument for rejected expressions, pointing the client at the offending column.
+func expressionError(span trace.Span, err error) error {
+	connectErr := invalidArgument(span, "expression is invalid", err)
+
+	var exprErr *calc.ExpressionError
+	if !errors.As(err, &exprErr) {
+		return connectErr
+	}
+	if exprErr.Limit != "" {
+		span.SetAttributes(attribute.String("expression.limit", exprErr.Limit))
+	}
+	if detail, detailErr := connect_go.NewErrorDetail(calcpb.ExpressionError(exprErr)); detailErr == nil {
+		connectErr.AddDetail(detail)
+	}
+	return connectErr
+}
+
 func (c *calculator) List(ctx context.Context, req *connect_go.Request[pb.ListRequest]) (*connect_go.Response[pb.ListResponse], error) {
 	results, err := c.db.GetCalculations(ctx)
 	if err != nil {
@@ -105,13 +275,131 @@ func (c *calculator) Get(ctx context.Context, req *connect_go.Request[pb.GetRequ
 	return response, nil
 }
 
+// GetHistory returns the state changes of a calculation, each with the trace it was made in.
+func (c *calculator) GetHistory(ctx context.Context, req *connect_go.Request[pb.GetHistoryRequest]) (*connect_go.Response[pb.GetHistoryResponse], error) {
+	events, err := c.db.GetHistory(ctx, uint(req.Msg.GetId()))
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*pb.CalculationEvent, 0, len(events))
+	for _, event := range events {
+		history = append(history, event.Proto())
+	}
+
+	return connect_go.NewResponse(&pb.GetHistoryResponse{Events: history}), nil
+}
+
 func (c *calculator) Cleanup(ctx context.Context, req *connect_go.Request[pb.CleanupRequest]) (*connect_go.Response[pb.CleanupResponse], error) {
 	return connect_go.NewResponse(&pb.CleanupResponse{}), nil
 }
-func New(s Storage, m Math) *calculator {
-	return &calculator{db: s, math: m}
+func (c *calculator) ListFunctions(ctx context.Context, req *connect_go.Request[pb.ListFunctionsRequest]) (*connect_go.Response[pb.ListFunctionsResponse], error) {
+	var functions []*pb.Function
+	for _, fn := range calc.Functions() {
+		functions = append(functions, &pb.Function{
+			Name:        fn.Name,
+			Signature:   fn.Signature,
+			Description: fn.Description,
+			MinArgs:     int32(fn.MinArgs),
+			MaxArgs:     int32(fn.MaxArgs),
+		})
+	}
+
+	response := connect_go.NewResponse(&pb.ListFunctionsResponse{
+		CatalogVersion: calc.CatalogVersion,
+		Functions:      functions,
+	})
+
+	return response, nil
+}
+
+func (c *calculator) ValidateExpression(ctx context.Context, req *connect_go.Request[pb.ValidateExpressionRequest]) (*connect_go.Response[pb.ValidateExpressionResponse], error) {
+	span := trace.SpanFromContext(ctx)
+
+	// Accept the same modes as Calculate.
+	options := &domain.Calculation{}
+	if err := evaluationMode(span, req.Msg.GetMode(), nil, options); err != nil {
+		return nil, err
+	}
+
+	expr, err := calc.Analyze(req.Msg.GetExpression(), calcpb.Mode(options.Mode), c.limits)
+	if err != nil {
+		return nil, expressionError(span, err)
+	}
+
+	response := connect_go.NewResponse(&pb.ValidateExpressionResponse{
+		Ast:       calcpb.Node(expr.Root),
+		Variables: expr.Variables,
+		Functions: expr.Functions,
+		Depth:     uint32(expr.Depth),
+		Operators: uint32(expr.Operators),
+	})
+
+	return response, nil
+}
+
+func (c *calculator) CreatePipeline(ctx context.Context, req *connect_go.Request[pb.CreatePipelineRequest]) (*connect_go.Response[pb.CreatePipelineResponse], error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("owner", req.Msg.GetOwner()))
+
+	if err := validateVariables(span, req.Msg.GetVariables()); err != nil {
+		return nil, err
+	}
+
+	options := &domain.Calculation{}
+	if err := evaluationMode(span, req.Msg.GetMode(), req.Msg.GetDecimal(), options); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("evaluation.mode", options.Mode.String()))
+
+	p, err := pipeline.Plan(req.Msg, c.limits)
+	if err != nil {
+		return nil, expressionError(span, err)
+	}
+	p.Mode, p.DecimalScale, p.DecimalRounding = options.Mode, options.DecimalScale, options.DecimalRounding
+	for _, step := range p.Steps {
+		if err := outputUnit(span, p.Mode, step.OutputUnit); err != nil {
+			return nil, err
+		}
+	}
+	if p.Owner, err = owner(ctx, span, p.Owner); err != nil {
+		return nil, err
+	}
+	if err := c.checkQuota(ctx, span, len(p.Steps)); err != nil {
+		return nil, err
+	}
+
+	if err := c.pipelines.Start(ctx, c.math, p); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("pipeline.id", int(p.ID)))
+
+	response := connect_go.NewResponse(&pb.CreatePipelineResponse{
+		Id: uint32(p.ID),
+	})
+
+	return response, nil
+}
+
+func (c *calculator) GetPipeline(ctx context.Context, req *connect_go.Request[pb.GetPipelineRequest]) (*connect_go.Response[pb.GetPipelineResponse], error) {
+	p, err := c.db.GetPipeline(ctx, uint(req.Msg.GetId()))
+	if err != nil {
+		return nil, err
+	}
+
+	response := connect_go.NewResponse(&pb.GetPipelineResponse{
+		Pipeline: p.Proto(),
+	})
+
+	return response, nil
+}
+
+func New(s Storage, m Math, cache Cache, pipelines Pipelines, limits calc.Limits, quota *tenant.Quota) *calculator {
+	return &calculator{db: s, math: m, cache: cache, pipelines: pipelines, limits: limits, quota: quota}
 }
 
-func (c *calculator) Register(mux *http.ServeMux) {
-	mux.Handle(calculatorv1connect.NewCalculatorServiceHandler(c, connect_go.WithInterceptors(otelconnect.NewInterceptor())))
+// Register serves the calculator on mux, for the tenants authenticated by auth, which is nil when tenants
+// aren't authenticated.
+func (c *calculator) Register(mux *http.ServeMux, auth tenant.Authenticator) {
+	mux.Handle(calculatorv1connect.NewCalculatorServiceHandler(c, connect_go.WithInterceptors(otelconnect.NewInterceptor(), tenant.NewInterceptor(auth), procedure.NewInterceptor(), newErrorInterceptor())))
 }
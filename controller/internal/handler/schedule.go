@@ -0,0 +1,140 @@
+package handler
+
+import (
+	context "context"
+	"fmt"
+	"time"
+
+	connect_go "github.com/bufbuild/connect-go"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/scheduler"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func (c *calculator) CreateSchedule(ctx context.Context, req *connect_go.Request[pb.CreateScheduleRequest]) (*connect_go.Response[pb.CreateScheduleResponse], error) {
+	span := trace.SpanFromContext(ctx)
+
+	schedule, err := c.newSchedule(ctx, span, req.Msg.GetSchedule())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.db.CreateSchedule(ctx, schedule); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("schedule.id", int(schedule.ID)))
+
+	response := connect_go.NewResponse(&pb.CreateScheduleResponse{
+		Id: uint32(schedule.ID),
+	})
+
+	return response, nil
+}
+
+func (c *calculator) GetSchedule(ctx context.Context, req *connect_go.Request[pb.GetScheduleRequest]) (*connect_go.Response[pb.GetScheduleResponse], error) {
+	schedule, err := c.db.GetSchedule(ctx, uint(req.Msg.GetId()))
+	if err != nil {
+		return nil, err
+	}
+
+	response := connect_go.NewResponse(&pb.GetScheduleResponse{
+		Schedule: schedule.Proto(),
+	})
+
+	return response, nil
+}
+
+func (c *calculator) ListSchedules(ctx context.Context, req *connect_go.Request[pb.ListSchedulesRequest]) (*connect_go.Response[pb.ListSchedulesResponse], error) {
+	results, err := c.db.GetSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules []*pb.Schedule
+	for _, result := range results {
+		schedules = append(schedules, result.Proto())
+	}
+
+	response := connect_go.NewResponse(&pb.ListSchedulesResponse{
+		Schedules: schedules,
+	})
+
+	return response, nil
+}
+
+func (c *calculator) UpdateSchedule(ctx context.Context, req *connect_go.Request[pb.UpdateScheduleRequest]) (*connect_go.Response[pb.UpdateScheduleResponse], error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("schedule.id", int(req.Msg.GetSchedule().GetId())))
+
+	schedule, err := c.newSchedule(ctx, span, req.Msg.GetSchedule())
+	if err != nil {
+		return nil, err
+	}
+	schedule.ID = uint(req.Msg.GetSchedule().GetId())
+
+	if err := c.db.UpdateSchedule(ctx, schedule); err != nil {
+		return nil, err
+	}
+
+	updated, err := c.db.GetSchedule(ctx, schedule.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := connect_go.NewResponse(&pb.UpdateScheduleResponse{
+		Schedule: updated.Proto(),
+	})
+
+	return response, nil
+}
+
+func (c *calculator) DeleteSchedule(ctx context.Context, req *connect_go.Request[pb.DeleteScheduleRequest]) (*connect_go.Response[pb.DeleteScheduleResponse], error) {
+	if err := c.db.DeleteSchedule(ctx, uint(req.Msg.GetId())); err != nil {
+		return nil, err
+	}
+	return connect_go.NewResponse(&pb.DeleteScheduleResponse{}), nil
+}
+
+// newSchedule validates the calculation and timing of msg. Rescheduling a one-off schedule runs it again.
+func (c *calculator) newSchedule(ctx context.Context, span trace.Span, msg *pb.Schedule) (*domain.Schedule, error) {
+	span.SetAttributes(attribute.String("owner", msg.GetRequest().GetOwner()))
+
+	res, err := c.newCalculation(ctx, span, msg.GetRequest())
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &domain.Schedule{
+		Owner:           res.Owner,
+		Expression:      res.Expression,
+		Variables:       res.Variables,
+		Mode:            res.Mode,
+		DecimalScale:    res.DecimalScale,
+		DecimalRounding: res.DecimalRounding,
+		OutputUnit:      res.OutputUnit,
+		Paused:          msg.GetPaused(),
+	}
+
+	switch timing := msg.GetTiming().(type) {
+	case *pb.Schedule_RunAt:
+		if err := timing.RunAt.CheckValid(); err != nil {
+			return nil, invalidArgument(span, "schedule timing is invalid", err)
+		}
+		runAt := timing.RunAt.AsTime()
+		schedule.RunAt = &runAt
+	case *pb.Schedule_Cron:
+		schedule.Cron = timing.Cron
+	default:
+		return nil, invalidArgument(span, "schedule timing is invalid", fmt.Errorf("either run_at or cron is required"))
+	}
+
+	next, err := scheduler.NextRun(schedule, time.Now())
+	if err != nil {
+		return nil, invalidArgument(span, "schedule timing is invalid", err)
+	}
+	schedule.NextRunAt = next
+
+	return schedule, nil
+}
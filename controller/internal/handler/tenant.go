@@ -0,0 +1,51 @@
+package handler
+
+import (
+	context "context"
+	"errors"
+	"fmt"
+
+	connect_go "github.com/bufbuild/connect-go"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// owner returns the owner of what a request creates: the tenant of ctx, or the owner the request names when
+// tenants aren't authenticated. Naming an owner other than the tenant is denied.
+func owner(ctx context.Context, span trace.Span, requested string) (string, error) {
+	id := tenant.FromContext(ctx)
+	if id == "" {
+		return requested, nil
+	}
+	if requested != "" && requested != id {
+		err := fmt.Errorf("owner %q is not the authenticated tenant", requested)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "owner is not the tenant")
+		return "", connect_go.NewError(connect_go.CodePermissionDenied, err)
+	}
+	return id, nil
+}
+
+// checkQuota rejects a request that would leave the tenant of ctx with more than its maximum of pending
+// calculations, once it adds count of them.
+func (c *calculator) checkQuota(ctx context.Context, span trace.Span, count int) error {
+	err := c.quota.Check(ctx, count)
+	var quotaErr *tenant.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return err
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, "quota exceeded")
+	connectErr := connect_go.NewError(connect_go.CodeResourceExhausted, err)
+	detail, detailErr := connect_go.NewErrorDetail(&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{
+		Subject:     "tenant:" + quotaErr.Tenant,
+		Description: fmt.Sprintf("at most %d calculations can be pending", quotaErr.Max),
+	}}})
+	if detailErr == nil {
+		connectErr.AddDetail(detail)
+	}
+	return connectErr
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	context "context"
+
+	connect_go "github.com/bufbuild/connect-go"
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// finalEvents are the kinds of events after which a calculation doesn't change anymore.
+var finalEvents = map[pb.CalculationEventKind]bool{
+	pb.CalculationEventKind_CALCULATION_EVENT_KIND_RESULT_RECEIVED: true,
+	pb.CalculationEventKind_CALCULATION_EVENT_KIND_FAILED:          true,
+	pb.CalculationEventKind_CALCULATION_EVENT_KIND_CANCELLED:       true,
+}
+
+func (c *calculator) WatchCalculation(ctx context.Context, req *connect_go.Request[pb.WatchCalculationRequest], stream *connect_go.ServerStream[pb.WatchCalculationResponse]) error {
+	id := uint(req.Msg.GetId())
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("id", int(id)))
+
+	// Subscribe before reading the history, so changes made in between aren't missed. The history is read from
+	// the primary, a lagging replica could miss changes that were already notified.
+	ctx, cancel := context.WithCancel(storage.WithPrimary(ctx))
+	defer cancel()
+	changes := c.db.Subscribe(ctx)
+
+	w := &watch{stream: stream, sent: map[uint]bool{}}
+	if err := w.sendHistory(ctx, c.db, id); err != nil || w.done {
+		return err
+	}
+
+	for change := range changes {
+		var err error
+		switch {
+		case change.Event == nil:
+			err = w.sendHistory(ctx, c.db, id)
+		case change.Event.CalculationID == id:
+			_, span := otelcommon.Tracer().Start(ctx, "calculation changed", trace.WithLinks(trace.Link{SpanContext: change.SpanContext}))
+			err = w.send(change.Event)
+			span.End()
+		}
+		if err != nil || w.done {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// watch streams the events of a calculation, each at most once.
+type watch struct {
+	stream *connect_go.ServerStream[pb.WatchCalculationResponse]
+	sent   map[uint]bool
+	// done is set once a final event was sent.
+	done bool
+}
+
+// sendHistory sends the events of the history of calculation id that weren't sent yet.
+func (w *watch) sendHistory(ctx context.Context, db Storage, id uint) error {
+	events, err := db.GetHistory(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := w.send(event); err != nil || w.done {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *watch) send(event *domain.CalculationEvent) error {
+	if w.sent[event.ID] {
+		return nil
+	}
+	w.sent[event.ID] = true
+	w.done = finalEvents[event.Kind]
+	return w.stream.Send(&pb.WatchCalculationResponse{Event: event.Proto()})
+}
@@ -0,0 +1,87 @@
+package math
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const testCooldown = 20 * time.Millisecond
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	b := newCircuitBreaker(3, time.Hour)
+	for i := 0; i < 2; i++ {
+		mustAllow(t, b, breakerClosed)
+		b.failure()
+	}
+	mustAllow(t, b, breakerClosed)
+	b.failure()
+
+	if state, err := b.allow(); !errors.Is(err, ErrCircuitOpen) || state != breakerOpen {
+		t.Fatalf("allow() = %v, %v after 3 failures, want open, ErrCircuitOpen", state, err)
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	b := newCircuitBreaker(2, time.Hour)
+	mustAllow(t, b, breakerClosed)
+	b.failure()
+	mustAllow(t, b, breakerClosed)
+	b.success()
+	mustAllow(t, b, breakerClosed)
+	b.failure()
+
+	mustAllow(t, b, breakerClosed)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name   string
+		probe  func(b *circuitBreaker)
+		want   breakerState
+		reopen bool
+	}{
+		{name: "successful probe closes", probe: (*circuitBreaker).success, want: breakerClosed},
+		{name: "failed probe reopens", probe: (*circuitBreaker).failure, want: breakerOpen, reopen: true},
+		{name: "released probe lets another through", probe: (*circuitBreaker).release, want: breakerHalfOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(1, testCooldown)
+			mustAllow(t, b, breakerClosed)
+			b.failure()
+			if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("allow() = %v during the cooldown, want ErrCircuitOpen", err)
+			}
+
+			time.Sleep(testCooldown)
+			mustAllow(t, b, breakerHalfOpen)
+			if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("allow() = %v while probing, want ErrCircuitOpen", err)
+			}
+
+			tt.probe(b)
+			state, err := b.allow()
+			if state != tt.want || errors.Is(err, ErrCircuitOpen) != tt.reopen {
+				t.Fatalf("allow() = %v, %v after the probe, want %v", state, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker(0, time.Hour)
+	for i := 0; i < 10; i++ {
+		b.failure()
+		mustAllow(t, b, breakerClosed)
+	}
+}
+
+func mustAllow(t *testing.T, b *circuitBreaker, want breakerState) {
+	t.Helper()
+	state, err := b.allow()
+	if err != nil || state != want {
+		t.Fatalf("allow() = %v, %v, want %v, nil", state, err, want)
+	}
+}
@@ -0,0 +1,131 @@
+package math
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	connect_go "github.com/bufbuild/connect-go"
+
+	"github.com/kostyay/otel-demo/common/calc"
+	"github.com/kostyay/otel-demo/common/log"
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	"github.com/kostyay/otel-demo/controller/api/calcpb"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// local evaluates calculations inside the controller process instead of dispatching them over Pub/Sub.
+// Calculate still returns before the result is ready, the Pub/Sub producer and consumer spans are
+// replaced with internal "math dispatch" and "math process" spans. At most concurrency calculations are
+// evaluated at once, Calculate waits for a free slot until its context is done.
+type local struct {
+	storage   Storage
+	cache     Cache
+	pipelines Pipelines
+	limits    calc.Limits
+	slots     chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	running sync.WaitGroup
+}
+
+func NewLocal(storage Storage, cache Cache, pipelines Pipelines, limits calc.Limits, concurrency int) *local {
+	return &local{
+		storage:   storage,
+		cache:     cache,
+		pipelines: pipelines,
+		limits:    limits,
+		slots:     make(chan struct{}, concurrency),
+	}
+}
+
+func (l *local) Calculate(ctx context.Context, calculation *pb.Calculation) error {
+	ctx, span := otelcommon.Tracer().Start(ctx, "math dispatch", trace.WithAttributes(attribute.String("math.mode", "local")))
+	defer span.End()
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		err := fmt.Errorf("no evaluation slot became free: %w", ctx.Err())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return connect_go.NewError(connect_go.CodeUnavailable, err)
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		<-l.slots
+		return connect_go.NewError(connect_go.CodeUnavailable, errors.New("local math is closed"))
+	}
+	l.running.Add(1)
+	l.mu.Unlock()
+
+	// The evaluation outlives the RPC, so detach it from the request cancellation but keep the trace.
+	asyncCtx := baggage.ContextWithBaggage(trace.ContextWithSpan(context.Background(), span), baggage.FromContext(ctx))
+	recordDispatched(ctx, l.storage, calculation, "local")
+	go l.process(asyncCtx, calculation)
+
+	return nil
+}
+
+func (l *local) process(ctx context.Context, calculation *pb.Calculation) {
+	defer l.running.Done()
+
+	ctx, span := otelcommon.Tracer().Start(ctx, "math process")
+	defer span.End()
+	// The calculation was already checked against its tenant, storing its result is done for every tenant.
+	ctx = tenant.NewBackgroundContext(domain.WithActor(ctx, actor))
+
+	if !l.evaluate(ctx, span, calculation) {
+		return
+	}
+
+	span.AddEvent("result updated")
+	l.cache.Store(ctx, calculation)
+	// The slot is already free, so the next steps can take it.
+	l.pipelines.Advance(ctx, l, calculation)
+}
+
+// evaluate evaluates calculation in its slot and stores the outcome, it reports whether that succeeded.
+func (l *local) evaluate(ctx context.Context, span trace.Span, calculation *pb.Calculation) bool {
+	defer func() { <-l.slots }()
+
+	logger := log.WithContext(ctx)
+
+	err := calcpb.Evaluate(ctx, calculation, l.limits)
+	if err != nil {
+		logger.WithError(err).Error("Failed to evaluate expression")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		err = l.storage.UpdateError(ctx, uint(calculation.Id), calcpb.EvaluationError(err))
+	} else {
+		err = l.storage.UpdateResult(ctx, uint(calculation.Id), calculation.Result)
+	}
+	if err != nil {
+		logger.WithError(err).Error("unable to update result")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return false
+	}
+	return true
+}
+
+// Close stops accepting calculations and waits for the ones being evaluated.
+func (l *local) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+
+	l.running.Wait()
+	return nil
+}
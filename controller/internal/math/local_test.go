@@ -0,0 +1,79 @@
+package math
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	connect_go "github.com/bufbuild/connect-go"
+	"github.com/kostyay/otel-demo/common/calc"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/pipeline"
+)
+
+// blockingStorage holds every stored result until release is closed.
+type blockingStorage struct {
+	release chan struct{}
+	results chan *pb.Value
+}
+
+func (s *blockingStorage) UpdateResult(_ context.Context, _ uint, result *pb.Value) error {
+	<-s.release
+	s.results <- result
+	return nil
+}
+
+func (s *blockingStorage) UpdateError(context.Context, uint, *pb.EvaluationError) error {
+	<-s.release
+	s.results <- nil
+	return nil
+}
+
+func (s *blockingStorage) RecordEvent(context.Context, uint, pb.CalculationEventKind, string) error {
+	return nil
+}
+
+type nopCache struct{}
+
+func (nopCache) Store(context.Context, *pb.Calculation) {}
+
+type nopPipelines struct{}
+
+func (nopPipelines) Advance(context.Context, pipeline.Math, *pb.Calculation) {}
+
+func TestLocalBoundsConcurrency(t *testing.T) {
+	storage := &blockingStorage{release: make(chan struct{}), results: make(chan *pb.Value, 2)}
+	l := NewLocal(storage, nopCache{}, nopPipelines{}, calc.Limits{}, 1)
+
+	if err := l.Calculate(context.Background(), &pb.Calculation{Id: 1, Expression: "1 + 1"}); err != nil {
+		t.Fatalf("Calculate() = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := l.Calculate(ctx, &pb.Calculation{Id: 2, Expression: "2 + 2"})
+	if connect_go.CodeOf(err) != connect_go.CodeUnavailable {
+		t.Fatalf("Calculate() = %v while the only slot is taken, want %v", err, connect_go.CodeUnavailable)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- l.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close() = %v before the running calculation finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(storage.release)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if got := <-storage.results; got.GetIntValue() != 2 {
+		t.Fatalf("stored result %v, want 2", got)
+	}
+
+	err = l.Calculate(context.Background(), &pb.Calculation{Id: 3, Expression: "3 + 3"})
+	if connect_go.CodeOf(err) != connect_go.CodeUnavailable {
+		t.Fatalf("Calculate() = %v after Close, want %v", err, connect_go.CodeUnavailable)
+	}
+}
@@ -2,21 +2,43 @@ package math
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 
 	"cloud.google.com/go/pubsub"
+	connect_go "github.com/bufbuild/connect-go"
 	"github.com/kostyay/otel-demo/common/log"
 	otelcommon "github.com/kostyay/otel-demo/common/otel"
 	otelpubsub "github.com/kostyay/otel-demo/common/otel/pubsub"
 	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
 	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/pipeline"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 type Storage interface {
-	UpdateResult(ctx context.Context, id uint, result float64) error
+	UpdateResult(ctx context.Context, id uint, result *pb.Value) error
+	UpdateError(ctx context.Context, id uint, evalErr *pb.EvaluationError) error
+	RecordEvent(ctx context.Context, calculationID uint, kind pb.CalculationEventKind, detail string) error
+}
+
+// actor is who calculation events recorded while handling results are caused by.
+const actor = "math"
+
+// Cache remembers the results of completed calculations.
+type Cache interface {
+	Store(ctx context.Context, calculation *pb.Calculation)
+}
+
+// Pipelines dispatches the pipeline steps waiting for a completed calculation.
+type Pipelines interface {
+	Advance(ctx context.Context, math pipeline.Math, calculation *pb.Calculation)
 }
 
 type handler struct {
@@ -24,9 +46,13 @@ type handler struct {
 	responseSub  *pubsub.Subscription
 	client       *pubsub.Client
 	storage      Storage
+	cache        Cache
+	pipelines    Pipelines
+	retry        retryPolicy
+	breaker      *circuitBreaker
 }
 
-func New(ctx context.Context, cfg *config.Options, storage Storage) (*handler, error) {
+func New(ctx context.Context, cfg *config.Options, storage Storage, cache Cache, pipelines Pipelines) (*handler, error) {
 	requestClient, err := pubsub.NewClient(ctx, cfg.GoogleCloudProject)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create pubsub client: %w", err)
@@ -37,6 +63,14 @@ func New(ctx context.Context, cfg *config.Options, storage Storage) (*handler, e
 		requestTopic: requestClient.Topic(cfg.MathRequestTopic),
 		responseSub:  requestClient.Subscription(cfg.MathResultSubscription),
 		storage:      storage,
+		cache:        cache,
+		pipelines:    pipelines,
+		retry: retryPolicy{
+			maxAttempts:    cfg.MathPublish.MaxAttempts,
+			initialBackoff: cfg.MathPublish.InitialBackoff,
+			maxBackoff:     cfg.MathPublish.MaxBackoff,
+		},
+		breaker: newCircuitBreaker(cfg.MathPublish.BreakerThreshold, cfg.MathPublish.BreakerCooldown),
 	}
 
 	// Create the requestTopic if it doesn't exist.
@@ -76,27 +110,42 @@ func (h *handler) handleMathResult(ctx context.Context, msg *pubsub.Message) {
 		span.End()
 	}()
 
+	// Results are stored for every tenant, the calculation was checked against its tenant when it was created.
+	ctx = tenant.NewBackgroundContext(domain.WithActor(ctx, actor))
 	logger := log.WithContext(ctx)
 
-	err = json.Unmarshal(msg.Data, &calculation)
+	err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(msg.Data, &calculation)
 	if err != nil {
 		logger.WithError(err).Error("unable to unmarshal calculation")
 		return
 	}
 
-	err = h.storage.UpdateResult(ctx, uint(calculation.Id), calculation.Result)
+	if calculation.Error != nil {
+		span.SetAttributes(attribute.String("evaluation.error", calculation.GetError().GetKind().String()))
+		err = h.storage.UpdateError(ctx, uint(calculation.Id), calculation.Error)
+	} else {
+		err = h.storage.UpdateResult(ctx, uint(calculation.Id), calculation.Result)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		// Redelivering the result won't make the calculation exist.
+		logger.WithError(err).Error("unable to update result")
+		msg.Ack()
+		return
+	}
 	if err != nil {
 		logger.WithError(err).Error("unable to update result")
 		return
 	}
 
 	span.AddEvent("result updated")
+	h.cache.Store(ctx, &calculation)
+	h.pipelines.Advance(ctx, h, &calculation)
 
 	msg.Ack()
 }
 
 func (h *handler) Calculate(ctx context.Context, calculation *pb.Calculation) error {
-	expression, err := json.Marshal(calculation)
+	expression, err := protojson.Marshal(calculation)
 	if err != nil {
 		return fmt.Errorf("unable to marshal calculation: %w", err)
 	}
@@ -108,15 +157,62 @@ func (h *handler) Calculate(ctx context.Context, calculation *pb.Calculation) er
 	ctx, span := otelpubsub.BeforePublishMessage(ctx, otelcommon.Tracer(), h.requestTopic.String(), msg)
 	defer span.End()
 
-	result, err := h.requestTopic.Publish(ctx, msg).Get(ctx)
+	state, err := h.breaker.allow()
+	span.SetAttributes(attribute.String("circuit_breaker.state", state.String()))
+	if err != nil {
+		otelpubsub.AfterPublishMessage(span, "", err)
+		return connect_go.NewError(connect_go.CodeUnavailable, err)
+	}
+
+	result, err := h.publish(ctx, span, msg)
 	otelpubsub.AfterPublishMessage(span, result, err)
 	if err != nil {
+		// Only failures of Pub/Sub itself count against it, not bad requests or callers that gave up.
+		if isRetryable(err) && ctx.Err() == nil {
+			h.breaker.failure()
+		} else {
+			h.breaker.release()
+		}
 		return fmt.Errorf("unable to publish message: %w", err)
 	}
+	h.breaker.success()
 
+	recordDispatched(ctx, h.storage, calculation, "pubsub message "+result)
 	return nil
 }
 
+// recordDispatched records that calculation was handed over for evaluation. The calculation is on its way
+// by then, so failing to record it is only logged.
+func recordDispatched(ctx context.Context, storage Storage, calculation *pb.Calculation, detail string) {
+	err := storage.RecordEvent(ctx, uint(calculation.Id), pb.CalculationEventKind_CALCULATION_EVENT_KIND_DISPATCHED, detail)
+	if err != nil {
+		log.WithContext(ctx).WithError(err).Error("unable to record calculation dispatch")
+	}
+}
+
+// publish retries retryable failures with jittered exponential backoff, recording every failed attempt on the span.
+func (h *handler) publish(ctx context.Context, span trace.Span, msg *pubsub.Message) (string, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := h.requestTopic.Publish(ctx, msg).Get(ctx)
+		if err == nil {
+			return result, nil
+		}
+
+		attributes := []attribute.KeyValue{attribute.Int("attempt", attempt), attribute.String("error", err.Error())}
+		if attempt >= h.retry.maxAttempts || !isRetryable(err) || ctx.Err() != nil {
+			span.AddEvent("publish attempt failed", trace.WithAttributes(attributes...))
+			return "", err
+		}
+
+		backoff := h.retry.backoff(attempt)
+		span.AddEvent("publish attempt failed", trace.WithAttributes(append(attributes, attribute.String("backoff", backoff.String()))...))
+
+		if err := sleep(ctx, backoff); err != nil {
+			return "", err
+		}
+	}
+}
+
 func (h *handler) Close() error {
 	return h.client.Close()
 }
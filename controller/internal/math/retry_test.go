@@ -0,0 +1,94 @@
+package math
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoffBounds(t *testing.T) {
+	p := retryPolicy{initialBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 100 * time.Millisecond},
+		{attempt: 2, max: 200 * time.Millisecond},
+		{attempt: 4, max: 800 * time.Millisecond},
+		{attempt: 5, max: time.Second},
+		// Doubling this often would overflow, the cap still applies.
+		{attempt: 40, max: time.Second},
+		{attempt: 70, max: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempt), func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				if d := p.backoff(tt.attempt); d < 0 || d > tt.max {
+					t.Fatalf("backoff(%d) = %v, want within [0, %v]", tt.attempt, d, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := retryPolicy{initialBackoff: time.Second, maxBackoff: time.Second}
+	seen := map[time.Duration]bool{}
+	var total time.Duration
+	const samples = 1000
+	for i := 0; i < samples; i++ {
+		d := p.backoff(1)
+		seen[d] = true
+		total += d
+	}
+
+	if len(seen) < samples/2 {
+		t.Errorf("backoff returned only %d distinct delays out of %d", len(seen), samples)
+	}
+	// Full jitter is uniform over [0, 1s], its mean is far from either end.
+	if mean := total / samples; mean < 300*time.Millisecond || mean > 700*time.Millisecond {
+		t.Errorf("mean backoff is %v, want about 500ms", mean)
+	}
+}
+
+func TestBackoffDisabled(t *testing.T) {
+	if d := (retryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("backoff without delays = %v, want 0", d)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: status.Error(codes.Unavailable, "down"), want: true},
+		{err: fmt.Errorf("wrapped: %w", status.Error(codes.ResourceExhausted, "slow down")), want: true},
+		{err: status.Error(codes.InvalidArgument, "bad message"), want: false},
+		{err: status.Error(codes.PermissionDenied, "no access"), want: false},
+		{err: context.Canceled, want: false},
+		{err: context.DeadlineExceeded, want: false},
+		{err: errors.New("plain"), want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSleepStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("sleep() = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,301 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/kostyay/otel-demo/common/calc"
+	"github.com/kostyay/otel-demo/common/log"
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	"github.com/kostyay/otel-demo/controller/api/calcpb"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MaxSteps is the maximum number of steps in a pipeline.
+const MaxSteps = 100
+
+var stepName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type Storage interface {
+	CreatePipeline(ctx context.Context, pipeline *domain.Pipeline) error
+	GetPipeline(ctx context.Context, id uint) (*domain.Pipeline, error)
+	GetPipelineStep(ctx context.Context, calculationID uint) (*domain.PipelineStep, error)
+	ClaimPipelineStep(ctx context.Context, stepID uint, calculation *domain.Calculation) (bool, error)
+	CompletePipeline(ctx context.Context, id uint, failed bool) error
+	UpdateError(ctx context.Context, id uint, evalErr *pb.EvaluationError) error
+}
+
+// Math dispatches calculations for evaluation.
+type Math interface {
+	Calculate(ctx context.Context, calculation *pb.Calculation) error
+}
+
+// Scheduler dispatches the steps of a pipeline as soon as the steps they depend on have completed.
+type Scheduler struct {
+	storage Storage
+	quota   *tenant.Quota
+}
+
+func New(storage Storage, quota *tenant.Quota) *Scheduler {
+	return &Scheduler{storage: storage, quota: quota}
+}
+
+// Plan validates the steps of req and resolves which earlier steps each one depends on.
+func Plan(req *pb.CreatePipelineRequest, limits calc.Limits) (*domain.Pipeline, error) {
+	steps := req.GetSteps()
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("a pipeline needs at least one step")
+	}
+	if len(steps) > MaxSteps {
+		return nil, fmt.Errorf("a pipeline can't have more than %d steps", MaxSteps)
+	}
+
+	// Earlier steps are validated as variables, their values are only known once they completed.
+	defined := make(map[string]*pb.Value, len(req.GetVariables())+len(steps))
+	for name, value := range req.GetVariables() {
+		defined[name] = value
+	}
+	isStep := make(map[string]bool, len(steps))
+
+	result := &domain.Pipeline{Owner: req.GetOwner(), Variables: req.GetVariables()}
+	for i, step := range steps {
+		name := step.GetName()
+		if !stepName.MatchString(name) {
+			return nil, fmt.Errorf("step %d: name %q is not a valid identifier", i+1, name)
+		}
+		if _, ok := defined[name]; ok {
+			return nil, fmt.Errorf("step %q: name is already used by a variable or an earlier step", name)
+		}
+
+		expr, err := calcpb.Validate(&pb.Calculation{Expression: step.GetExpression(), Variables: defined, Mode: req.GetMode()}, limits)
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %w", name, err)
+		}
+
+		var dependsOn domain.StepNames
+		for _, variable := range expr.Variables {
+			if isStep[variable] {
+				dependsOn = append(dependsOn, variable)
+			}
+		}
+
+		result.Steps = append(result.Steps, &domain.PipelineStep{
+			Position:   i,
+			Name:       name,
+			Expression: step.GetExpression(),
+			DependsOn:  dependsOn,
+			OutputUnit: step.GetOutputUnit(),
+		})
+		defined[name] = &pb.Value{}
+		isStep[name] = true
+	}
+
+	return result, nil
+}
+
+// Start creates the pipeline and dispatches the steps that don't depend on other steps. The pipeline span
+// started here is the parent of every step, so the whole pipeline is a single trace.
+func (s *Scheduler) Start(ctx context.Context, math Math, pipeline *domain.Pipeline) (err error) {
+	ctx, span := otelcommon.Tracer().Start(ctx, "pipeline", trace.WithAttributes(
+		attribute.String("owner", pipeline.Owner),
+		attribute.Int("pipeline.steps", len(pipeline.Steps)),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	pipeline.TraceParent = traceParent(ctx)
+	if err := s.storage.CreatePipeline(ctx, pipeline); err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("pipeline.id", int(pipeline.ID)))
+
+	if err := s.dispatchReady(ctx, math, pipeline); err != nil {
+		s.complete(ctx, pipeline, true)
+		return err
+	}
+	return nil
+}
+
+// Advance is called with every completed calculation. If it is a pipeline step, the steps waiting for it are
+// dispatched, or the pipeline is completed when it was the last step or failed.
+func (s *Scheduler) Advance(ctx context.Context, math Math, calculation *pb.Calculation) {
+	logger := log.WithContext(ctx)
+
+	step, err := s.storage.GetPipelineStep(ctx, uint(calculation.GetId()))
+	if err != nil {
+		logger.WithError(err).Error("unable to find pipeline step")
+		return
+	}
+	if step == nil {
+		return
+	}
+
+	pipeline, err := s.storage.GetPipeline(ctx, step.PipelineID)
+	if err != nil {
+		logger.WithError(err).Error("unable to find pipeline")
+		return
+	}
+	if pipeline.CompletedAt != nil {
+		// Another step already failed the pipeline.
+		return
+	}
+
+	// Continue the pipeline's trace, linked to the span that delivered the result.
+	link := trace.LinkFromContext(ctx, attribute.String("pipeline.step", step.Name))
+	ctx = propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": pipeline.TraceParent})
+	ctx, span := otelcommon.Tracer().Start(ctx, "pipeline advance", trace.WithLinks(link), trace.WithAttributes(
+		attribute.Int("pipeline.id", int(pipeline.ID)),
+		attribute.String("pipeline.step", step.Name),
+	))
+	defer span.End()
+
+	if calculation.GetError() != nil {
+		span.AddEvent("step failed")
+		s.complete(ctx, pipeline, true)
+		return
+	}
+
+	if err := s.dispatchReady(ctx, math, pipeline); err != nil {
+		logger.WithError(err).Error("unable to dispatch pipeline steps")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.complete(ctx, pipeline, true)
+		return
+	}
+
+	for _, step := range pipeline.Steps {
+		if step.Calculation == nil || step.Calculation.Result == nil {
+			return
+		}
+	}
+	s.complete(ctx, pipeline, false)
+}
+
+// dispatchReady dispatches every step whose dependencies all have a result.
+func (s *Scheduler) dispatchReady(ctx context.Context, math Math, pipeline *domain.Pipeline) error {
+	results := map[string]*pb.Value{}
+	for _, step := range pipeline.Steps {
+		if step.Calculation != nil && step.Calculation.Result != nil {
+			results[step.Name] = step.Calculation.Result.Proto()
+		}
+	}
+
+	for _, step := range pipeline.Steps {
+		if step.CalculationID != nil || !ready(step, results) {
+			continue
+		}
+		if err := s.dispatch(ctx, math, pipeline, step, results); err != nil {
+			return fmt.Errorf("step %q: %w", step.Name, err)
+		}
+	}
+	return nil
+}
+
+func ready(step *domain.PipelineStep, results map[string]*pb.Value) bool {
+	for _, name := range step.DependsOn {
+		if _, ok := results[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Scheduler) dispatch(ctx context.Context, math Math, pipeline *domain.Pipeline, step *domain.PipelineStep, results map[string]*pb.Value) (err error) {
+	ctx, span := otelcommon.Tracer().Start(ctx, "pipeline step "+step.Name, trace.WithAttributes(
+		attribute.Int("pipeline.id", int(pipeline.ID)),
+		attribute.String("pipeline.step", step.Name),
+		attribute.StringSlice("pipeline.depends_on", step.DependsOn),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	ctx = domain.WithActor(ctx, "pipeline")
+	ctx = tenant.NewContext(ctx, pipeline.Owner)
+
+	variables := make(domain.Variables, len(pipeline.Variables)+len(step.DependsOn))
+	for name, value := range pipeline.Variables {
+		variables[name] = value
+	}
+	for _, name := range step.DependsOn {
+		variables[name] = results[name]
+	}
+
+	// A step over quota is still claimed and then failed, so the pipeline shows why it stopped.
+	quotaErr := s.quota.Check(ctx, 1)
+	var exceeded *tenant.QuotaError
+	if quotaErr != nil && !errors.As(quotaErr, &exceeded) {
+		return quotaErr
+	}
+
+	calculation := pipeline.NewCalculation(step, variables)
+	claimed, err := s.storage.ClaimPipelineStep(ctx, step.ID, calculation)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		span.AddEvent("step was already dispatched")
+		return nil
+	}
+	step.CalculationID = &calculation.ID
+	span.SetAttributes(attribute.Int("id", int(calculation.ID)))
+
+	if exceeded != nil {
+		s.fail(ctx, calculation.ID, exceeded.EvaluationError())
+		return quotaErr
+	}
+
+	dispatch := calculation.Proto()
+	err = math.Calculate(ctx, &pb.Calculation{
+		Id:         dispatch.Id,
+		Owner:      dispatch.Owner,
+		Expression: dispatch.Expression,
+		Variables:  dispatch.Variables,
+		Mode:       dispatch.Mode,
+		Decimal:    dispatch.Decimal,
+		OutputUnit: dispatch.OutputUnit,
+	})
+	if err != nil {
+		s.fail(ctx, calculation.ID, &pb.EvaluationError{Kind: pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_RUNTIME, Message: fmt.Sprintf("unable to dispatch: %s", err)})
+		return err
+	}
+	return nil
+}
+
+// fail records evalErr as the outcome of a step calculation that was created but never evaluated, so it
+// doesn't count as pending.
+func (s *Scheduler) fail(ctx context.Context, id uint, evalErr *pb.EvaluationError) {
+	if err := s.storage.UpdateError(ctx, id, evalErr); err != nil {
+		log.WithContext(ctx).WithError(err).Error("unable to update result")
+	}
+}
+
+func (s *Scheduler) complete(ctx context.Context, pipeline *domain.Pipeline, failed bool) {
+	trace.SpanFromContext(ctx).AddEvent("pipeline completed", trace.WithAttributes(attribute.Bool("pipeline.failed", failed)))
+	if err := s.storage.CompletePipeline(ctx, pipeline.ID, failed); err != nil {
+		log.WithContext(ctx).WithError(err).Error("unable to complete pipeline")
+	}
+}
+
+// traceParent serializes the span context of ctx, so later steps can continue the trace.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
@@ -0,0 +1,65 @@
+// Package procedure carries the connect procedure being served and the client calling it in the request
+// context, for code below the handler that has no access to the request, like the storage.
+package procedure
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	connect_go "github.com/bufbuild/connect-go"
+)
+
+type contextKey struct{}
+
+type call struct {
+	procedure string
+	client    string
+}
+
+// NewContext returns a copy of ctx carrying procedure, as in "/calculator.v1.CalculatorService/Calculate",
+// and the address of the client calling it.
+func NewContext(ctx context.Context, procedure, client string) context.Context {
+	return context.WithValue(ctx, contextKey{}, call{procedure: procedure, client: client})
+}
+
+// FromContext returns the procedure carried by ctx, or an empty string outside of a request.
+func FromContext(ctx context.Context) string {
+	c, _ := ctx.Value(contextKey{}).(call)
+	return c.procedure
+}
+
+// Client returns the address of the client carried by ctx, or an empty string outside of a request.
+func Client(ctx context.Context) string {
+	c, _ := ctx.Value(contextKey{}).(call)
+	return c.client
+}
+
+// Split splits procedure into its service and method.
+func Split(procedure string) (service, method string) {
+	service, method, _ = strings.Cut(strings.TrimPrefix(procedure, "/"), "/")
+	return service, method
+}
+
+// NewInterceptor returns an interceptor adding the procedure and client of every request to its context.
+func NewInterceptor() connect_go.UnaryInterceptorFunc {
+	return func(next connect_go.UnaryFunc) connect_go.UnaryFunc {
+		return func(ctx context.Context, req connect_go.AnyRequest) (connect_go.AnyResponse, error) {
+			return next(NewContext(ctx, req.Spec().Procedure, client(req)), req)
+		}
+	}
+}
+
+// client returns the address of the client of req. Behind a load balancer, like on Cloud Run, that is the
+// first address of X-Forwarded-For rather than the address of the peer.
+func client(req connect_go.AnyRequest) string {
+	if forwarded := req.Header().Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(req.Peer().Addr)
+	if err != nil {
+		return req.Peer().Addr
+	}
+	return host
+}
@@ -0,0 +1,217 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kostyay/otel-demo/common/log"
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// leaseName is the lease held by the controller instance that runs schedules.
+const leaseName = "scheduler"
+
+var runs, _ = otelcommon.Meter().Int64Counter("calculation.schedule.runs", metric.WithDescription("Number of scheduled calculation runs"))
+
+type Storage interface {
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	DueSchedules(ctx context.Context, now time.Time, limit int) ([]*domain.Schedule, error)
+	RecordScheduleRun(ctx context.Context, schedule *domain.Schedule, calculation *domain.Calculation, next *time.Time) (bool, error)
+	UpdateError(ctx context.Context, id uint, evalErr *pb.EvaluationError) error
+}
+
+type Math interface {
+	Calculate(ctx context.Context, calculation *pb.Calculation) error
+}
+
+// Scheduler runs due schedules. Every controller instance runs one, but only the instance holding the
+// scheduler lease dispatches anything.
+type Scheduler struct {
+	storage   Storage
+	math      Math
+	quota     *tenant.Quota
+	holder    string
+	interval  time.Duration
+	leaseTTL  time.Duration
+	batchSize int
+	leader    bool
+}
+
+func New(cfg *config.Options, storage Storage, math Math, quota *tenant.Quota) *Scheduler {
+	return &Scheduler{
+		storage:   storage,
+		math:      math,
+		quota:     quota,
+		holder:    holderID(),
+		interval:  cfg.Scheduler.Interval,
+		leaseTTL:  cfg.Scheduler.LeaseTTL,
+		batchSize: cfg.Scheduler.BatchSize,
+	}
+}
+
+// NextRun returns when schedule runs next after now, or nil if a one-off schedule already ran.
+func NextRun(schedule *domain.Schedule, now time.Time) (*time.Time, error) {
+	if schedule.Cron == "" {
+		if schedule.LastRunAt != nil {
+			return nil, nil
+		}
+		return schedule.RunAt, nil
+	}
+
+	spec, err := cron.ParseStandard(schedule.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("cron expression is invalid: %w", err)
+	}
+	next := spec.Next(now.UTC())
+	if next.IsZero() {
+		return nil, fmt.Errorf("cron expression %q never runs", schedule.Cron)
+	}
+	return &next, nil
+}
+
+// Run checks for due schedules every interval until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	// Every run acts as the owner of its schedule, finding them is done for every tenant.
+	ctx = tenant.NewBackgroundContext(ctx)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	logger := log.WithContext(ctx)
+
+	leader, err := s.storage.AcquireLease(ctx, leaseName, s.holder, s.leaseTTL)
+	if err != nil {
+		logger.WithError(err).Error("unable to acquire scheduler lease")
+		return
+	}
+	if leader != s.leader {
+		logger.Infof("scheduler leadership changed, holder=%s leader=%t", s.holder, leader)
+		s.leader = leader
+	}
+	if !leader {
+		return
+	}
+
+	now := time.Now()
+	due, err := s.storage.DueSchedules(ctx, now, s.batchSize)
+	if err != nil {
+		logger.WithError(err).Error("unable to find due schedules")
+		return
+	}
+	for _, schedule := range due {
+		s.run(ctx, schedule, now)
+	}
+}
+
+// run records a single run of schedule as a new calculation and dispatches it. Runs missed while no
+// controller was leader are skipped, a recurring schedule only runs once to catch up. A run that would exceed
+// the quota of the owner is recorded as a failed calculation, so the schedule moves on to its next run.
+func (s *Scheduler) run(ctx context.Context, schedule *domain.Schedule, now time.Time) {
+	ctx, span := otelcommon.Tracer().Start(ctx, "scheduled run", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.Int("schedule.id", int(schedule.ID)),
+		attribute.String("schedule.cron", schedule.Cron),
+		attribute.String("owner", schedule.Owner),
+	))
+	defer span.End()
+	ctx = domain.WithActor(ctx, "scheduler")
+	// The run is made for the owner of the schedule, as if it made the request itself.
+	ctx = tenant.NewContext(ctx, schedule.Owner)
+
+	logger := log.WithContext(ctx)
+	fail := func(msg string, err error) {
+		logger.WithError(err).Error(msg)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, msg)
+		runs.Add(ctx, 1, metric.WithAttributes(attribute.Bool("error", true)))
+	}
+
+	// The following run is computed as if this one already happened, a one-off schedule doesn't run again.
+	ran := *schedule
+	ran.LastRunAt = &now
+	next, err := NextRun(&ran, now)
+	if err != nil {
+		fail("unable to compute next run", err)
+		return
+	}
+
+	quotaErr := s.quota.Check(ctx, 1)
+	var exceeded *tenant.QuotaError
+	if quotaErr != nil && !errors.As(quotaErr, &exceeded) {
+		fail("unable to check tenant quota", quotaErr)
+		return
+	}
+
+	calculation := schedule.NewCalculation()
+	recorded, err := s.storage.RecordScheduleRun(ctx, schedule, calculation, next)
+	if err != nil {
+		fail("unable to record schedule run", err)
+		return
+	}
+	if !recorded {
+		span.AddEvent("schedule changed before it ran")
+		return
+	}
+	span.SetAttributes(attribute.Int("id", int(calculation.ID)))
+	failRun := func(evalErr *pb.EvaluationError) {
+		if err := s.storage.UpdateError(ctx, calculation.ID, evalErr); err != nil {
+			logger.WithError(err).Error("unable to update result")
+		}
+	}
+
+	if exceeded != nil {
+		fail("tenant quota exceeded", quotaErr)
+		failRun(exceeded.EvaluationError())
+		return
+	}
+
+	dispatch := calculation.Proto()
+	err = s.math.Calculate(ctx, &pb.Calculation{
+		Id:         dispatch.Id,
+		Owner:      dispatch.Owner,
+		Expression: dispatch.Expression,
+		Variables:  dispatch.Variables,
+		Mode:       dispatch.Mode,
+		Decimal:    dispatch.Decimal,
+		OutputUnit: dispatch.OutputUnit,
+	})
+	if err != nil {
+		fail("unable to dispatch scheduled calculation", err)
+		failRun(&pb.EvaluationError{Kind: pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_RUNTIME, Message: fmt.Sprintf("unable to dispatch: %s", err)})
+		return
+	}
+
+	runs.Add(ctx, 1, metric.WithAttributes(attribute.Bool("error", false)))
+}
+
+// holderID identifies this controller instance as a lease holder.
+func holderID() string {
+	hostname, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(b))
+}
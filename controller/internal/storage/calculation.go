@@ -2,88 +2,112 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/kostyay/otel-demo/common/log"
+	"time"
 
-	_ "github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/dialers/postgres"
-	otelgorm "github.com/kostyay/gorm-opentelemetry"
-	"github.com/kostyay/otel-demo/controller/internal/config"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
 	"github.com/kostyay/otel-demo/controller/internal/domain"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type storage struct {
-	db *gorm.DB
+func (s *storage) CreateCalculation(ctx context.Context, calculation *domain.Calculation) error {
+	var events []*domain.CalculationEvent
+	err := s.db.WithContext(ctx).Debug().Transaction(func(tx *gorm.DB) (err error) {
+		events, err = createCalculation(ctx, tx, calculation)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create calculation: %w", dbError(err, "calculation", 0))
+	}
+	s.wrote(ctx)
+	s.committed(ctx, events...)
+	return nil
 }
 
-func New(cfg *config.Options) (*storage, error) {
-	dsn := fmt.Sprintf("host=%s user=%s dbname=%s password=%s sslmode=disable", cfg.DB.InstanceConnectionName, cfg.DB.User, cfg.DB.Name, cfg.DB.Password)
-	log.Infof("Connecting to database, dsn=%s", dsn)
-	db, err := gorm.Open(postgres.New(postgres.Config{
-		DriverName: "cloudsqlpostgres",
-		DSN:        dsn,
-	}))
+func (s *storage) GetCalculation(ctx context.Context, id uint) (*domain.Calculation, error) {
+	var calculation domain.Calculation
+	err := s.reader(ctx).First(&calculation, id).Error
 	if err != nil {
-		return nil, fmt.Errorf("unable to open database: %w", err)
+		return nil, fmt.Errorf("unable to find calculation: %w", dbError(err, "calculation", id))
 	}
+	return &calculation, nil
+}
 
-	// Initialize otel plugin with options
-	plugin := otelgorm.NewPlugin()
-	err = db.Use(plugin)
+func (s *storage) GetCalculations(ctx context.Context) ([]*domain.Calculation, error) {
+	var calculations []*domain.Calculation
+	err := s.reader(ctx).Debug().Order("created_at DESC").Find(&calculations).Error
 	if err != nil {
-		return nil, fmt.Errorf("unable to use otelgorm plugin: %w", err)
+		return nil, fmt.Errorf("unable to find calculations: %w", dbError(err, "calculation", 0))
 	}
+	return calculations, nil
+}
 
-	//sqlcPlugin := NewSQLCommenterPlugin()
-	//err = db.Use(sqlcPlugin)
-	//if err != nil {
-	//	return nil, fmt.Errorf("unable to use sqlc plugin: %w", err)
-	//}
-
-	// Migrate the schema
-	err = db.AutoMigrate(&domain.Calculation{})
+// PendingCalculations counts the calculations created since then that haven't completed yet.
+func (s *storage) PendingCalculations(ctx context.Context, since time.Time) (int, error) {
+	var count int64
+	err := staticComment(s.db.WithContext(ctx)).Model(&domain.Calculation{}).Where("completed_at IS NULL AND created_at >= ?", since).Count(&count).Error
 	if err != nil {
-		panic(err.Error())
+		return 0, fmt.Errorf("unable to count pending calculations: %w", dbError(err, "calculation", 0))
 	}
+	return int(count), nil
+}
 
-	return &storage{db: db}, nil
+func (s *storage) UpdateResult(ctx context.Context, id uint, result *pb.Value) error {
+	return s.complete(ctx, id, "result_value", domain.NewResult(result), newEvent(ctx, id, pb.CalculationEventKind_CALCULATION_EVENT_KIND_RESULT_RECEIVED, ""))
 }
 
-func (s *storage) CreateCalculation(ctx context.Context, owner, expression string) (*domain.Calculation, error) {
-	calculation := &domain.Calculation{
-		Owner:      owner,
-		Expression: expression,
-	}
-	err := s.db.WithContext(ctx).Debug().Create(calculation).Error
-	if err != nil {
-		return nil, fmt.Errorf("unable to create calculation: %w", err)
-	}
-	return calculation, nil
+func (s *storage) UpdateError(ctx context.Context, id uint, evalErr *pb.EvaluationError) error {
+	return s.complete(ctx, id, "error", domain.NewFailure(evalErr), newEvent(ctx, id, pb.CalculationEventKind_CALCULATION_EVENT_KIND_FAILED, failedDetail(evalErr)))
 }
 
-func (s *storage) GetCalculation(ctx context.Context, id uint) (*domain.Calculation, error) {
-	var calculation domain.Calculation
-	err := s.db.WithContext(ctx).First(&calculation, id).Error
+// complete stores the outcome of a calculation in column and records event. A calculation keeps the first
+// outcome stored for it, storing another one, e.g. a redelivered result, changes nothing and records no event.
+// Outcomes are mostly stored by background work, the owner of the calculation reads its own writes all the same.
+func (s *storage) complete(ctx context.Context, id uint, column string, value interface{}, event *domain.CalculationEvent) error {
+	var owners []string
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&domain.Calculation{}).Where("id = ? AND completed_at IS NULL", id).Updates(map[string]interface{}{column: value, "completed_at": time.Now()})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			// Already completed, unless it doesn't exist.
+			return tx.Select("id").Take(&domain.Calculation{}, id).Error
+		}
+		if err := tx.Model(&domain.Calculation{}).Where("id = ?", id).Pluck("owner", &owners).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to find calculation: %w", err)
+		return fmt.Errorf("unable to update calculation: %w", dbError(err, "calculation", id))
 	}
-	return &calculation, nil
+	if len(owners) > 0 {
+		s.wroteFor(owners[0])
+		s.committed(ctx, event)
+	}
+	return nil
 }
 
-func (s *storage) GetCalculations(ctx context.Context) ([]*domain.Calculation, error) {
-	var calculations []*domain.Calculation
-	err := s.db.WithContext(ctx).Debug().Raw("SELECT * FROM calculations ORDER BY created_at DESC " + sqlComments(ctx)).Scan(&calculations).Error
+// GetCachedResult returns the unexpired cache entry for key, or nil if there is none.
+func (s *storage) GetCachedResult(ctx context.Context, key string) (*domain.CacheEntry, error) {
+	var entry domain.CacheEntry
+	err := staticComment(s.db.WithContext(ctx)).Where("key = ? AND expires_at > ?", key, time.Now()).Take(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to find calculations: %w", err)
+		return nil, fmt.Errorf("unable to find cache entry: %w", dbError(err, "cache entry", 0))
 	}
-	return calculations, nil
+	return &entry, nil
 }
 
-func (s *storage) UpdateResult(ctx context.Context, id uint, result float64) error {
-	err := s.db.WithContext(ctx).Model(&domain.Calculation{}).Where("id = ?", id).Update("result", result).Update("completed_at", "NOW()").Error
+func (s *storage) PutCachedResult(ctx context.Context, entry *domain.CacheEntry) error {
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(entry).Error
 	if err != nil {
-		return fmt.Errorf("unable to update calculation: %w", err)
+		return fmt.Errorf("unable to store cache entry: %w", dbError(err, "cache entry", 0))
 	}
 	return nil
 }
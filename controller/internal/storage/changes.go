@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// subscriptionBuffer is how many changes a subscriber can fall behind before changes are dropped for it. Its
+// channel has one more slot, reserved for the empty Change telling it to resync.
+const subscriptionBuffer = 100
+
+var droppedChanges, _ = otelcommon.Meter().Int64Counter("storage.changes.dropped", metric.WithDescription("Number of changes dropped because a subscriber fell behind"))
+
+// Change is a change to a calculation, delivered to subscribers once it is committed.
+type Change struct {
+	// Event is the event recorded in the history of the calculation. It is nil when changes may have been
+	// missed, because the subscriber fell behind or the feed (re)connected, subscribers then re-read the
+	// history they care about.
+	Event *domain.CalculationEvent
+	// SpanContext is the span the change was made in, consumers link their spans to it.
+	SpanContext trace.SpanContext
+}
+
+func newChange(event *domain.CalculationEvent) Change {
+	change := Change{Event: event}
+	traceID, _ := trace.TraceIDFromHex(event.TraceID)
+	spanID, _ := trace.SpanIDFromHex(event.SpanID)
+	change.SpanContext = trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, Remote: true})
+	return change
+}
+
+// changes fans changes out to subscribers in process.
+type changes struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	ch chan Change
+}
+
+// subscribe returns a channel receiving the changes published until ctx is done, it is closed then.
+func (c *changes) subscribe(ctx context.Context) <-chan Change {
+	s := &subscriber{ch: make(chan Change, subscriptionBuffer+1)}
+
+	c.mu.Lock()
+	if c.subscribers == nil {
+		c.subscribers = map[*subscriber]struct{}{}
+	}
+	c.subscribers[s] = struct{}{}
+	c.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, s)
+		close(s.ch)
+	}()
+	return s.ch
+}
+
+// publish delivers change to every subscriber without blocking. A subscriber that fell behind gets an empty
+// Change right away instead, in the slot reserved for it, so it resyncs even if nothing is published anymore.
+func (c *changes) publish(ctx context.Context, change Change) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for s := range c.subscribers {
+		if len(s.ch) < subscriptionBuffer {
+			s.ch <- change
+			continue
+		}
+		droppedChanges.Add(ctx, 1)
+		s.resync()
+	}
+}
+
+// missed tells every subscriber changes may have been missed.
+func (c *changes) missed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for s := range c.subscribers {
+		s.resync()
+	}
+}
+
+// resync queues an empty Change. Changes only fill the channel up to subscriptionBuffer, so a full channel
+// ends with an empty Change already and there is nothing to do.
+func (s *subscriber) resync() {
+	select {
+	case s.ch <- Change{}:
+	default:
+	}
+}
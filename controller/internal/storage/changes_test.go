@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+)
+
+func TestChangesResyncLaggingSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var c changes
+	ch := c.subscribe(ctx)
+	for i := 1; i <= subscriptionBuffer+10; i++ {
+		c.publish(ctx, Change{Event: &domain.CalculationEvent{ID: uint(i)}})
+	}
+
+	// The subscriber gets the changes that fit and then the resync, without anything else being published.
+	for i := 1; i <= subscriptionBuffer; i++ {
+		if change := <-ch; change.Event == nil || change.Event.ID != uint(i) {
+			t.Fatalf("change %d = %+v, want event %d", i, change.Event, i)
+		}
+	}
+	if change := <-ch; change.Event != nil {
+		t.Fatalf("change after the dropped ones = %+v, want a resync", change.Event)
+	}
+	select {
+	case change := <-ch:
+		t.Fatalf("got %+v after the resync, want nothing", change.Event)
+	default:
+	}
+
+	// Once it caught up, it gets changes again.
+	c.publish(ctx, Change{Event: &domain.CalculationEvent{ID: 1000}})
+	if change := <-ch; change.Event == nil || change.Event.ID != 1000 {
+		t.Fatalf("change = %+v, want event 1000", change.Event)
+	}
+
+	c.missed()
+	if change := <-ch; change.Event != nil {
+		t.Fatalf("change after the feed reconnected = %+v, want a resync", change.Event)
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kostyay/otel-demo/controller/internal/config"
+)
+
+// redacted replaces passwords in logged connection strings.
+const redacted = "xxxxx"
+
+// postgresDSN returns the key/value connection string of the postgres database configured by the DB_ options.
+// Cloud SQL databases are addressed by their instance connection name.
+func postgresDSN(cfg *config.Options) string {
+	params := map[string]string{}
+	for key, value := range cfg.DB.Params {
+		params[key] = value
+	}
+	params["user"] = cfg.DB.User
+	params["password"] = string(cfg.DB.Password)
+	params["dbname"] = cfg.DB.Name
+	params["sslmode"] = cfg.DB.SSLMode
+
+	if cfg.DB.Driver == config.DBDriverCloudSQL {
+		params["host"] = cfg.DB.InstanceConnectionName
+		if params["sslmode"] == "" {
+			params["sslmode"] = "disable"
+		}
+	} else {
+		params["host"] = cfg.DB.Host
+		params["port"] = strconv.Itoa(cfg.DB.Port)
+	}
+	return keyValueDSN(params)
+}
+
+// keyValueDSN formats params as a libpq key/value connection string, quoting every value. Empty values are
+// left out so the driver defaults apply.
+func keyValueDSN(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key, value := range params {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(params[key])
+		pairs = append(pairs, key+"='"+value+"'")
+	}
+	return strings.Join(pairs, " ")
+}
+
+// passwordParam matches the password of a key/value connection string, quoted or not.
+var passwordParam = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactDSN returns dsn with its password replaced, so it can be logged. dsn is either a URL or a key/value
+// connection string.
+func redactDSN(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// The password could be anywhere in a malformed URL.
+			return "(unparseable URL)"
+		}
+		if query := u.Query(); query.Has("password") {
+			query.Set("password", redacted)
+			u.RawQuery = query.Encode()
+		}
+		return u.Redacted()
+	}
+	return passwordParam.ReplaceAllString(dsn, "${1}"+redacted)
+}
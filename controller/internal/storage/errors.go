@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// The kinds of errors callers of Storage can handle, every backend returns them as an *Error.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrConflict      = errors.New("conflict")
+	ErrUnavailable   = errors.New("unavailable")
+)
+
+// Error is a storage error of a known kind, errors.Is matches it against its kind.
+type Error struct {
+	// Kind is ErrNotFound, ErrAlreadyExists, ErrConflict or ErrUnavailable.
+	Kind error
+	// Resource is the kind of record, like "calculation".
+	Resource string
+	// ID is the id of the record, or 0 if the error isn't about a single record.
+	ID uint
+	// Err is the error returned by the database, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Description()
+	}
+	return e.Description() + ": " + e.Err.Error()
+}
+
+// Description describes the error without the database error, so it can be returned to clients.
+func (e *Error) Description() string {
+	if e.ID == 0 {
+		return fmt.Sprintf("%s %s", e.Resource, e.Kind)
+	}
+	return fmt.Sprintf("%s %d %s", e.Resource, e.ID, e.Kind)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func notFound(resource string, id uint) error {
+	return &Error{Kind: ErrNotFound, Resource: resource, ID: id}
+}
+
+// SQLSTATE codes of the postgres errors dbError classifies, the class 08 connection exceptions are
+// classified by their class.
+var sqlStates = map[string]error{
+	"23505": ErrAlreadyExists, // unique_violation
+	"40001": ErrConflict,      // serialization_failure
+	"40P01": ErrConflict,      // deadlock_detected
+	"53300": ErrUnavailable,   // too_many_connections
+	"57P01": ErrUnavailable,   // admin_shutdown
+	"57P02": ErrUnavailable,   // crash_shutdown
+	"57P03": ErrUnavailable,   // cannot_connect_now
+}
+
+// Result codes of the sqlite errors dbError classifies.
+const (
+	sqliteBusy             = 5
+	sqliteLocked           = 6
+	sqliteConstraintPK     = 1555
+	sqliteConstraintUnique = 2067
+)
+
+// dbError classifies an error returned by the database about resource, errors of other kinds are returned as
+// they are. Both postgres drivers (pgx and lib/pq through the Cloud SQL dialer) report a SQLSTATE.
+func dbError(err error, resource string, id uint) error {
+	var kind error
+	var sqlState interface{ SQLState() string }
+	var sqliteErr interface{ Code() int }
+	var netErr net.Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return notFound(resource, id)
+	case errors.As(err, &sqlState):
+		kind = sqlStates[sqlState.SQLState()]
+		if strings.HasPrefix(sqlState.SQLState(), "08") {
+			kind = ErrUnavailable
+		}
+	case errors.As(err, &sqliteErr):
+		switch sqliteErr.Code() {
+		case sqliteConstraintPK, sqliteConstraintUnique:
+			kind = ErrAlreadyExists
+		case sqliteBusy, sqliteLocked:
+			kind = ErrUnavailable
+		}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		kind = ErrUnavailable
+	}
+
+	if kind == nil {
+		return err
+	}
+	return &Error{Kind: kind, Resource: resource, ID: id, Err: err}
+}
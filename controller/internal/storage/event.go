@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/procedure"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// servedFromCache is the detail of the result event of calculations whose result was reused.
+const servedFromCache = "served from cache"
+
+// newEvent returns an event of kind about a calculation, caused by the actor of ctx in its span.
+func newEvent(ctx context.Context, calculationID uint, kind pb.CalculationEventKind, detail string) *domain.CalculationEvent {
+	event := &domain.CalculationEvent{
+		CalculationID: calculationID,
+		Kind:          kind,
+		Actor:         actor(ctx),
+		Detail:        detail,
+		CreatedAt:     time.Now(),
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		event.TraceID = spanContext.TraceID().String()
+		event.SpanID = spanContext.SpanID().String()
+	}
+	return event
+}
+
+// actor returns who causes the changes made in ctx: the actor set with domain.WithActor, the client of the
+// request, or the controller itself.
+func actor(ctx context.Context) string {
+	if actor := domain.ActorFromContext(ctx); actor != "" {
+		return actor
+	}
+	if client := procedure.Client(ctx); client != "" {
+		return "client " + client
+	}
+	return "controller"
+}
+
+// createdEvents returns the events of creating calculation, which already has a result if it was served
+// from the cache.
+func createdEvents(ctx context.Context, calculation *domain.Calculation) []*domain.CalculationEvent {
+	events := []*domain.CalculationEvent{newEvent(ctx, calculation.ID, pb.CalculationEventKind_CALCULATION_EVENT_KIND_CREATED, "")}
+	if calculation.ServedFromCache {
+		events = append(events, newEvent(ctx, calculation.ID, pb.CalculationEventKind_CALCULATION_EVENT_KIND_RESULT_RECEIVED, servedFromCache))
+	}
+	return events
+}
+
+// failedDetail describes why a calculation failed.
+func failedDetail(evalErr *pb.EvaluationError) string {
+	return fmt.Sprintf("%s: %s", evalErr.GetKind(), evalErr.GetMessage())
+}
+
+// createCalculation creates calculation and records its creation in tx, returning the recorded events.
+func createCalculation(ctx context.Context, tx *gorm.DB, calculation *domain.Calculation) ([]*domain.CalculationEvent, error) {
+	if err := tx.Create(calculation).Error; err != nil {
+		return nil, err
+	}
+	events := createdEvents(ctx, calculation)
+	if err := tx.Create(events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// RecordEvent appends an event of kind, caused by the actor of ctx, to the history of a calculation.
+func (s *storage) RecordEvent(ctx context.Context, calculationID uint, kind pb.CalculationEventKind, detail string) error {
+	event := newEvent(ctx, calculationID, kind, detail)
+	err := s.db.WithContext(ctx).Create(event).Error
+	if err != nil {
+		return fmt.Errorf("unable to record calculation event: %w", dbError(err, "calculation event", 0))
+	}
+	s.committed(ctx, event)
+	return nil
+}
+
+// GetHistory returns the events of a calculation, oldest first. The history is kept after the calculation
+// is deleted.
+func (s *storage) GetHistory(ctx context.Context, calculationID uint) ([]*domain.CalculationEvent, error) {
+	var events []*domain.CalculationEvent
+	db := s.reader(ctx)
+	if tenant.FromContext(ctx) != "" {
+		// Events have no owner, the calculation tells whether the tenant may see them.
+		if err := db.Unscoped().Select("id").First(&domain.Calculation{}, calculationID).Error; err != nil {
+			return nil, fmt.Errorf("unable to find calculation: %w", dbError(err, "calculation", calculationID))
+		}
+	}
+	err := db.Where("calculation_id = ?", calculationID).Order("id").Find(&events).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to find calculation events: %w", dbError(err, "calculation event", 0))
+	}
+	if len(events) > 0 {
+		return events, nil
+	}
+
+	// Calculations created before their history was recorded have none.
+	err = db.Unscoped().Select("id").First(&domain.Calculation{}, calculationID).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to find calculation: %w", dbError(err, "calculation", calculationID))
+	}
+	return events, nil
+}
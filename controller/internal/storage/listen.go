@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
+	"github.com/kostyay/otel-demo/common/log"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/lib/pq"
+)
+
+// changesChannel is the channel the calculation_events trigger notifies of every recorded event.
+const changesChannel = "calculation_changes"
+
+// notification is the payload of a calculation_changes notification.
+type notification struct {
+	ID            uint      `json:"id"`
+	CalculationID uint      `json:"calculation_id"`
+	Kind          int32     `json:"kind"`
+	Actor         string    `json:"actor"`
+	Detail        string    `json:"detail"`
+	TraceID       string    `json:"trace_id"`
+	SpanID        string    `json:"span_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (n *notification) event() *domain.CalculationEvent {
+	return &domain.CalculationEvent{
+		ID:            n.ID,
+		CalculationID: n.CalculationID,
+		Kind:          pb.CalculationEventKind(n.Kind),
+		Actor:         n.Actor,
+		Detail:        n.Detail,
+		TraceID:       n.TraceID,
+		SpanID:        n.SpanID,
+		CreatedAt:     n.CreatedAt,
+	}
+}
+
+// newListener returns a function opening a connection dedicated to LISTEN on the postgres database dsn. The
+// listener reconnects by itself when the connection is lost.
+func newListener(cfg *config.Options, dsn string) func() *pq.Listener {
+	return func() *pq.Listener {
+		callback := func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.WithError(err).Error("change feed connection failed")
+			}
+		}
+		if cfg.DB.Driver == config.DBDriverCloudSQL {
+			return pq.NewDialListener(cloudSQLDialer{}, dsn, time.Second, time.Minute, callback)
+		}
+		return pq.NewListener(dsn, time.Second, time.Minute, callback)
+	}
+}
+
+// listen publishes the changes notified by postgres until the listener is closed. The listener connection
+// reads no tables, so it isn't subject to the row level security policies, subscribers only watch calculations
+// they were allowed to read.
+func (s *storage) listen(listener *pq.Listener) {
+	ctx := context.Background()
+	if err := listener.Listen(changesChannel); err != nil {
+		log.WithError(err).Error("unable to listen for changes")
+		return
+	}
+	// Subscribers that subscribed while connecting may have missed changes.
+	s.changes.missed()
+
+	for n := range listener.Notify {
+		if n == nil {
+			// Reconnected, notifications sent in the meantime are lost.
+			s.changes.missed()
+			continue
+		}
+		var payload notification
+		if err := json.Unmarshal([]byte(n.Extra), &payload); err != nil {
+			log.WithError(err).Error("unable to unmarshal change notification")
+			continue
+		}
+		s.changes.publish(ctx, newChange(payload.event()))
+	}
+}
+
+// Subscribe returns a channel receiving the changes committed to calculations until ctx is done. On postgres
+// changes are received with LISTEN, so those made by every controller instance are delivered, other
+// databases only deliver the changes made by this instance.
+func (s *storage) Subscribe(ctx context.Context) <-chan Change {
+	if s.listener != nil {
+		s.listenOnce.Do(func() {
+			s.listening = s.listener()
+			go s.listen(s.listening)
+		})
+	}
+	return s.changes.subscribe(ctx)
+}
+
+// committed publishes the events of a committed change to the subscribers of this instance, unless postgres
+// notifies them.
+func (s *storage) committed(ctx context.Context, events ...*domain.CalculationEvent) {
+	if s.listener != nil {
+		return
+	}
+	for _, event := range events {
+		s.changes.publish(ctx, newChange(event))
+	}
+}
+
+// cloudSQLDialer dials Cloud SQL instances through the Cloud SQL proxy, like the cloudsqlpostgres driver.
+type cloudSQLDialer struct{}
+
+var instanceAddr = regexp.MustCompile(`^\[(.+)\]:[0-9]+$`)
+
+func (cloudSQLDialer) Dial(network, addr string) (net.Conn, error) {
+	return cloudSQLDialer{}.DialTimeout(network, addr, 0)
+}
+
+func (cloudSQLDialer) DialTimeout(network, addr string, timeout time.Duration) (net.Conn, error) {
+	match := instanceAddr.FindStringSubmatch(addr)
+	if match == nil {
+		return nil, fmt.Errorf("unable to parse Cloud SQL instance address %q", addr)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return proxy.DialContext(ctx, match[1])
+}
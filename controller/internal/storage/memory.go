@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+)
+
+// memory is an in-memory Storage. Records are copied in and out, so callers can't change them behind its back.
+// Like the SQL storage, it hides the records of other owners from a tenant and makes it own what it creates.
+type memory struct {
+	mu           sync.Mutex
+	lastID       uint
+	calculations map[uint]*domain.Calculation
+	events       []*domain.CalculationEvent
+	cache        map[string]*domain.CacheEntry
+	pipelines    map[uint]*domain.Pipeline
+	steps        map[uint]*domain.PipelineStep
+	schedules    map[uint]*domain.Schedule
+	leases       map[string]*domain.Lease
+	changes      changes
+}
+
+func NewMemory() *memory {
+	return &memory{
+		calculations: map[uint]*domain.Calculation{},
+		cache:        map[string]*domain.CacheEntry{},
+		pipelines:    map[uint]*domain.Pipeline{},
+		steps:        map[uint]*domain.PipelineStep{},
+		schedules:    map[uint]*domain.Schedule{},
+		leases:       map[string]*domain.Lease{},
+	}
+}
+
+func (m *memory) nextID() uint {
+	m.lastID++
+	return m.lastID
+}
+
+func (m *memory) CreateCalculation(ctx context.Context, calculation *domain.Calculation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.createCalculation(ctx, calculation)
+	return nil
+}
+
+func (m *memory) createCalculation(ctx context.Context, calculation *domain.Calculation) {
+	now := time.Now()
+	if id := tenant.FromContext(ctx); id != "" {
+		calculation.Owner = id
+	}
+	calculation.ID = m.nextID()
+	calculation.CreatedAt, calculation.UpdatedAt = now, now
+	stored := *calculation
+	m.calculations[calculation.ID] = &stored
+	for _, event := range createdEvents(ctx, calculation) {
+		m.recordEvent(ctx, event)
+	}
+}
+
+func (m *memory) GetCalculation(ctx context.Context, id uint) (*domain.Calculation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calculation, ok := m.calculations[id]
+	if !ok || !tenant.Owns(ctx, calculation.Owner) {
+		return nil, fmt.Errorf("unable to find calculation: %w", notFound("calculation", id))
+	}
+	result := *calculation
+	return &result, nil
+}
+
+func (m *memory) GetCalculations(ctx context.Context) ([]*domain.Calculation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calculations := make([]*domain.Calculation, 0, len(m.calculations))
+	for _, calculation := range m.calculations {
+		if !tenant.Owns(ctx, calculation.Owner) {
+			continue
+		}
+		result := *calculation
+		calculations = append(calculations, &result)
+	}
+	sort.Slice(calculations, func(i, j int) bool { return calculations[i].ID > calculations[j].ID })
+	return calculations, nil
+}
+
+func (m *memory) PendingCalculations(ctx context.Context, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, calculation := range m.calculations {
+		if calculation.CompletedAt == nil && !calculation.CreatedAt.Before(since) && tenant.Owns(ctx, calculation.Owner) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memory) UpdateResult(ctx context.Context, id uint, result *pb.Value) error {
+	event := newEvent(ctx, id, pb.CalculationEventKind_CALCULATION_EVENT_KIND_RESULT_RECEIVED, "")
+	return m.complete(ctx, id, event, func(c *domain.Calculation) { c.Result = domain.NewResult(result) })
+}
+
+func (m *memory) UpdateError(ctx context.Context, id uint, evalErr *pb.EvaluationError) error {
+	event := newEvent(ctx, id, pb.CalculationEventKind_CALCULATION_EVENT_KIND_FAILED, failedDetail(evalErr))
+	return m.complete(ctx, id, event, func(c *domain.Calculation) { c.Error = domain.NewFailure(evalErr) })
+}
+
+func (m *memory) complete(ctx context.Context, id uint, event *domain.CalculationEvent, update func(c *domain.Calculation)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	calculation, ok := m.calculations[id]
+	if !ok || !tenant.Owns(ctx, calculation.Owner) {
+		return notFound("calculation", id)
+	}
+	if calculation.CompletedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	update(calculation)
+	calculation.CompletedAt = &now
+	calculation.UpdatedAt = now
+	m.recordEvent(ctx, event)
+	return nil
+}
+
+func (m *memory) RecordEvent(ctx context.Context, calculationID uint, kind pb.CalculationEventKind, detail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordEvent(ctx, newEvent(ctx, calculationID, kind, detail))
+	return nil
+}
+
+func (m *memory) recordEvent(ctx context.Context, event *domain.CalculationEvent) {
+	event.ID = m.nextID()
+	stored := *event
+	m.events = append(m.events, &stored)
+	published := *event
+	m.changes.publish(ctx, newChange(&published))
+}
+
+func (m *memory) GetHistory(ctx context.Context, calculationID uint) ([]*domain.CalculationEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if calculation, ok := m.calculations[calculationID]; ok && !tenant.Owns(ctx, calculation.Owner) {
+		return nil, fmt.Errorf("unable to find calculation: %w", notFound("calculation", calculationID))
+	}
+
+	var events []*domain.CalculationEvent
+	for _, event := range m.events {
+		if event.CalculationID == calculationID {
+			result := *event
+			events = append(events, &result)
+		}
+	}
+	if _, ok := m.calculations[calculationID]; !ok && len(events) == 0 {
+		return nil, fmt.Errorf("unable to find calculation: %w", notFound("calculation", calculationID))
+	}
+	return events, nil
+}
+
+func (m *memory) GetCachedResult(ctx context.Context, key string) (*domain.CacheEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.cache[key]
+	if !ok || !entry.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	result := *entry
+	return &result, nil
+}
+
+func (m *memory) PutCachedResult(ctx context.Context, entry *domain.CacheEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *entry
+	m.cache[entry.Key] = &stored
+	return nil
+}
+
+func (m *memory) CreatePipeline(ctx context.Context, pipeline *domain.Pipeline) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if id := tenant.FromContext(ctx); id != "" {
+		pipeline.Owner = id
+	}
+	pipeline.ID = m.nextID()
+	pipeline.CreatedAt, pipeline.UpdatedAt = now, now
+	stored := *pipeline
+	stored.Steps = nil
+	m.pipelines[pipeline.ID] = &stored
+
+	for _, step := range pipeline.Steps {
+		step.ID = m.nextID()
+		step.PipelineID = pipeline.ID
+		storedStep := *step
+		storedStep.Calculation = nil
+		m.steps[step.ID] = &storedStep
+	}
+	return nil
+}
+
+func (m *memory) GetPipeline(ctx context.Context, id uint) (*domain.Pipeline, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pipeline, ok := m.pipelines[id]
+	if !ok || !tenant.Owns(ctx, pipeline.Owner) {
+		return nil, fmt.Errorf("unable to find pipeline: %w", notFound("pipeline", id))
+	}
+	result := *pipeline
+
+	for _, step := range m.steps {
+		if step.PipelineID != id {
+			continue
+		}
+		resultStep := *step
+		if step.CalculationID != nil {
+			calculation := *m.calculations[*step.CalculationID]
+			resultStep.Calculation = &calculation
+		}
+		result.Steps = append(result.Steps, &resultStep)
+	}
+	sort.Slice(result.Steps, func(i, j int) bool { return result.Steps[i].Position < result.Steps[j].Position })
+	return &result, nil
+}
+
+func (m *memory) GetPipelineStep(ctx context.Context, calculationID uint) (*domain.PipelineStep, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, step := range m.steps {
+		if step.CalculationID != nil && *step.CalculationID == calculationID {
+			result := *step
+			return &result, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memory) ClaimPipelineStep(ctx context.Context, stepID uint, calculation *domain.Calculation) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	step, ok := m.steps[stepID]
+	if !ok || step.CalculationID != nil {
+		return false, nil
+	}
+	m.createCalculation(ctx, calculation)
+	id := calculation.ID
+	step.CalculationID = &id
+	return true, nil
+}
+
+func (m *memory) CompletePipeline(ctx context.Context, id uint, failed bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pipeline, ok := m.pipelines[id]
+	if !ok || pipeline.CompletedAt != nil || !tenant.Owns(ctx, pipeline.Owner) {
+		return nil
+	}
+	now := time.Now()
+	pipeline.Failed = failed
+	pipeline.CompletedAt = &now
+	pipeline.UpdatedAt = now
+	return nil
+}
+
+func (m *memory) CreateSchedule(ctx context.Context, schedule *domain.Schedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if id := tenant.FromContext(ctx); id != "" {
+		schedule.Owner = id
+	}
+	schedule.ID = m.nextID()
+	schedule.CreatedAt, schedule.UpdatedAt = now, now
+	stored := *schedule
+	m.schedules[schedule.ID] = &stored
+	return nil
+}
+
+func (m *memory) GetSchedule(ctx context.Context, id uint) (*domain.Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule, ok := m.schedules[id]
+	if !ok || !tenant.Owns(ctx, schedule.Owner) {
+		return nil, fmt.Errorf("unable to find schedule: %w", notFound("schedule", id))
+	}
+	result := *schedule
+	return &result, nil
+}
+
+func (m *memory) GetSchedules(ctx context.Context) ([]*domain.Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedules := make([]*domain.Schedule, 0, len(m.schedules))
+	for _, schedule := range m.schedules {
+		if !tenant.Owns(ctx, schedule.Owner) {
+			continue
+		}
+		result := *schedule
+		schedules = append(schedules, &result)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules, nil
+}
+
+func (m *memory) UpdateSchedule(ctx context.Context, schedule *domain.Schedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.schedules[schedule.ID]
+	if !ok || !tenant.Owns(ctx, stored.Owner) {
+		return fmt.Errorf("unable to update schedule: %w", notFound("schedule", schedule.ID))
+	}
+
+	// The same fields as scheduleFields, the run history is kept.
+	updated := *schedule
+	updated.CreatedAt = stored.CreatedAt
+	updated.UpdatedAt = time.Now()
+	updated.LastRunAt = stored.LastRunAt
+	updated.LastCalculationID = stored.LastCalculationID
+	m.schedules[schedule.ID] = &updated
+	return nil
+}
+
+func (m *memory) DeleteSchedule(ctx context.Context, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if schedule, ok := m.schedules[id]; !ok || !tenant.Owns(ctx, schedule.Owner) {
+		return fmt.Errorf("unable to delete schedule: %w", notFound("schedule", id))
+	}
+	delete(m.schedules, id)
+	return nil
+}
+
+func (m *memory) DueSchedules(ctx context.Context, now time.Time, limit int) ([]*domain.Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*domain.Schedule
+	for _, schedule := range m.schedules {
+		if schedule.Paused || schedule.NextRunAt == nil || schedule.NextRunAt.After(now) || !tenant.Owns(ctx, schedule.Owner) {
+			continue
+		}
+		result := *schedule
+		due = append(due, &result)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextRunAt.Before(*due[j].NextRunAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (m *memory) RecordScheduleRun(ctx context.Context, schedule *domain.Schedule, calculation *domain.Calculation, next *time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.schedules[schedule.ID]
+	if !ok || !equalTime(stored.NextRunAt, schedule.NextRunAt) || !stored.UpdatedAt.Equal(schedule.UpdatedAt) || !tenant.Owns(ctx, stored.Owner) {
+		return false, nil
+	}
+
+	m.createCalculation(ctx, calculation)
+	id := calculation.ID
+	stored.NextRunAt = next
+	stored.LastRunAt = &calculation.CreatedAt
+	stored.LastCalculationID = &id
+	stored.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (m *memory) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if lease, ok := m.leases[name]; ok && lease.Holder != holder && lease.ExpiresAt.After(now) {
+		return false, nil
+	}
+	m.leases[name] = &domain.Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *memory) Subscribe(ctx context.Context) <-chan Change {
+	return m.changes.subscribe(ctx)
+}
+
+func (m *memory) Close() error {
+	return nil
+}
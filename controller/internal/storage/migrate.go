@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kostyay/otel-demo/common/log"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// rowLevelSecurityFiles set up postgres to enforce DB_ROW_LEVEL_SECURITY, or stop enforcing it. They aren't
+// versioned migrations since they create a role, which is shared by every database of the cluster.
+//
+//go:embed row_level_security
+var rowLevelSecurityFiles embed.FS
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLock is the postgres advisory lock held while migrating, so replicas never migrate concurrently.
+const migrationLock = 4_242_001
+
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+)
+
+// createMigrationsTable creates the table recording the applied migrations.
+var createMigrationsTable = map[string]string{
+	dialectPostgres: "CREATE TABLE IF NOT EXISTS schema_migrations (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamptz NOT NULL)",
+	dialectSQLite:   "CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, name text NOT NULL, applied_at datetime NOT NULL)",
+}
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies the versioned migrations embedded for a SQL database.
+type Migrator struct {
+	db         *gorm.DB
+	dialect    string
+	migrations []migration
+}
+
+func newMigrator(db *gorm.DB, dialect string) (*Migrator, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// loadMigrations reads the migrations of dialect sorted by version, every version needs an up and a down file.
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read migrations: %w", err)
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		b, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read migration %s: %w", entry.Name(), err)
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		}
+		if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(b)
+		} else {
+			m.down = string(b)
+		}
+	}
+
+	result := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.version, m.name)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].version < result[j].version })
+	return result, nil
+}
+
+// Latest is the version the controller needs.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].version
+}
+
+// Version returns the version of the database schema, 0 before the first migration.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	db := m.db.WithContext(ctx)
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return 0, nil
+	}
+	return schemaVersion(db)
+}
+
+func schemaVersion(db *gorm.DB) (int, error) {
+	var current *int
+	if err := db.Model(&schemaMigration{}).Select("MAX(version)").Scan(&current).Error; err != nil {
+		return 0, fmt.Errorf("unable to read schema version: %w", err)
+	}
+	if current == nil {
+		return 0, nil
+	}
+	return *current, nil
+}
+
+// Check refuses a database whose schema is older than the controller. A newer schema is accepted, so a
+// previous controller version keeps running while a migrated one rolls out.
+func (m *Migrator) Check(ctx context.Context) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if current < m.Latest() {
+		return fmt.Errorf("database schema is at version %d but version %d is required, run the migrate command", current, m.Latest())
+	}
+	if current > m.Latest() {
+		log.WithContext(ctx).Infof("database schema is at version %d, newer than version %d", current, m.Latest())
+	}
+	return nil
+}
+
+// Up applies every pending migration and returns the versions it applied.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	var applied []int
+	err := m.locked(ctx, func(db *gorm.DB, current int) error {
+		for _, migration := range m.migrations {
+			if migration.version <= current {
+				continue
+			}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.up).Error; err != nil {
+					return err
+				}
+				return tx.Create(&schemaMigration{Version: migration.version, Name: migration.name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("unable to apply migration %d_%s: %w", migration.version, migration.name, err)
+			}
+			applied = append(applied, migration.version)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the latest n applied migrations and returns the versions it reverted.
+func (m *Migrator) Down(ctx context.Context, n int) ([]int, error) {
+	var reverted []int
+	err := m.locked(ctx, func(db *gorm.DB, current int) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			migration := m.migrations[i]
+			if migration.version > current {
+				continue
+			}
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.down).Error; err != nil {
+					return err
+				}
+				return tx.Delete(&schemaMigration{}, migration.version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("unable to revert migration %d_%s: %w", migration.version, migration.name, err)
+			}
+			reverted = append(reverted, migration.version)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// locked runs f on a single connection holding the migration lock, with the current schema version.
+// SQLite databases only have a single writer, so they need no lock.
+func (m *Migrator) locked(ctx context.Context, f func(db *gorm.DB, current int) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		// A new session, so the statements below don't share conditions.
+		db := conn.Session(&gorm.Session{})
+		if m.dialect == dialectPostgres {
+			if err := db.Exec("SELECT pg_advisory_lock(?)", migrationLock).Error; err != nil {
+				return fmt.Errorf("unable to acquire migration lock: %w", err)
+			}
+			defer func() {
+				if err := db.Exec("SELECT pg_advisory_unlock(?)", migrationLock).Error; err != nil {
+					log.WithContext(ctx).WithError(err).Error("unable to release migration lock")
+				}
+			}()
+		}
+
+		if err := db.Exec(createMigrationsTable[m.dialect]).Error; err != nil {
+			return fmt.Errorf("unable to create migrations table: %w", err)
+		}
+		current, err := schemaVersion(db)
+		if err != nil {
+			return err
+		}
+		return f(db, current)
+	})
+}
+
+// RowLevelSecurity sets up postgres to enforce the isolation of tenants, see tenantPlugin, or stops enforcing it
+// unless enable is set. loginRole is the role the controller logs in as, which is made a member of the background
+// role or removed from it. The schema has to be at the latest version.
+func (m *Migrator) RowLevelSecurity(ctx context.Context, enable bool, loginRole string) error {
+	if m.dialect != dialectPostgres {
+		return errors.New("row level security is only supported by postgres")
+	}
+	if loginRole == "" {
+		return errors.New("the role the controller logs in as is required")
+	}
+
+	file := "disable.sql"
+	if enable {
+		file = "enable.sql"
+	}
+	b, err := fs.ReadFile(rowLevelSecurityFiles, path.Join("row_level_security", file))
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", file, err)
+	}
+	statements := strings.ReplaceAll(string(b), `:"login_role"`, pq.QuoteIdentifier(loginRole))
+
+	return m.locked(ctx, func(db *gorm.DB, current int) error {
+		if current < m.Latest() {
+			return fmt.Errorf("database schema is at version %d but version %d is required, run the migrate command", current, m.Latest())
+		}
+		if !enable {
+			var exists bool
+			if err := db.Raw("SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = ?)", backgroundRole).Scan(&exists).Error; err != nil {
+				return fmt.Errorf("unable to look up role %s: %w", backgroundRole, err)
+			}
+			if !exists {
+				// Row level security was never enforced.
+				return nil
+			}
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error { return tx.Exec(statements).Error }); err != nil {
+			return fmt.Errorf("unable to run %s: %w", file, err)
+		}
+		return nil
+	})
+}
+
+// CheckRowLevelSecurity refuses a postgres database that doesn't enforce row level security as configured: the
+// controller sees no records of a database enforcing it without DB_ROW_LEVEL_SECURITY, and can't switch to
+// the background role of one that doesn't enforce it. Other databases have nothing to check.
+func (m *Migrator) CheckRowLevelSecurity(ctx context.Context, enabled bool) error {
+	if m.dialect != dialectPostgres {
+		return nil
+	}
+	var enforced bool
+	err := m.db.WithContext(ctx).Raw("SELECT relforcerowsecurity FROM pg_class WHERE oid = 'calculations'::regclass").Scan(&enforced).Error
+	if err != nil {
+		return fmt.Errorf("unable to check row level security: %w", err)
+	}
+	switch {
+	case enforced && !enabled:
+		return errors.New("the database enforces row level security, set DB_ROW_LEVEL_SECURITY or run the row-level-security step of the migrate command without it")
+	case !enforced && enabled:
+		return errors.New("DB_ROW_LEVEL_SECURITY is set but the database doesn't enforce row level security, run the row-level-security step of the migrate command")
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+-- The original schema created by AutoMigrate, existing databases are adopted as they are.
+CREATE TABLE IF NOT EXISTS calculations (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    owner text,
+    expression text,
+    result decimal,
+    completed_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_calculations_deleted_at ON calculations (deleted_at);
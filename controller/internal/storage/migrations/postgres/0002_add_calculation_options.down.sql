@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS cache_entries;
+
+ALTER TABLE calculations
+    DROP COLUMN IF EXISTS variables,
+    DROP COLUMN IF EXISTS result_value,
+    DROP COLUMN IF EXISTS error,
+    DROP COLUMN IF EXISTS mode,
+    DROP COLUMN IF EXISTS decimal_scale,
+    DROP COLUMN IF EXISTS decimal_rounding,
+    DROP COLUMN IF EXISTS output_unit,
+    DROP COLUMN IF EXISTS served_from_cache;
@@ -0,0 +1,15 @@
+ALTER TABLE calculations
+    ADD COLUMN IF NOT EXISTS variables jsonb,
+    ADD COLUMN IF NOT EXISTS result_value jsonb,
+    ADD COLUMN IF NOT EXISTS error jsonb,
+    ADD COLUMN IF NOT EXISTS mode integer,
+    ADD COLUMN IF NOT EXISTS decimal_scale bigint,
+    ADD COLUMN IF NOT EXISTS decimal_rounding integer,
+    ADD COLUMN IF NOT EXISTS output_unit text,
+    ADD COLUMN IF NOT EXISTS served_from_cache boolean;
+
+CREATE TABLE IF NOT EXISTS cache_entries (
+    key text PRIMARY KEY,
+    result jsonb,
+    expires_at timestamptz
+);
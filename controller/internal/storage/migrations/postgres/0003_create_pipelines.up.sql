@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS pipelines (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    owner text,
+    variables jsonb,
+    trace_parent text,
+    failed boolean,
+    completed_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_pipelines_deleted_at ON pipelines (deleted_at);
+
+CREATE TABLE IF NOT EXISTS pipeline_steps (
+    id bigserial PRIMARY KEY,
+    pipeline_id bigint CONSTRAINT fk_pipelines_steps REFERENCES pipelines (id),
+    position bigint,
+    name text,
+    expression text,
+    depends_on jsonb,
+    calculation_id bigint CONSTRAINT fk_pipeline_steps_calculation REFERENCES calculations (id)
+);
+CREATE INDEX IF NOT EXISTS idx_pipeline_steps_pipeline_id ON pipeline_steps (pipeline_id);
+CREATE INDEX IF NOT EXISTS idx_pipeline_steps_calculation_id ON pipeline_steps (calculation_id);
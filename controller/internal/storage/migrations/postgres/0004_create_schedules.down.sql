@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS leases;
+
+DROP INDEX IF EXISTS idx_calculations_schedule_id;
+ALTER TABLE calculations DROP COLUMN IF EXISTS schedule_id;
+
+DROP TABLE IF EXISTS schedules;
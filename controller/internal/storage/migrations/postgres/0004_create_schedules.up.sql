@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS schedules (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    owner text,
+    expression text,
+    variables jsonb,
+    mode integer,
+    decimal_scale bigint,
+    decimal_rounding integer,
+    output_unit text,
+    cron text,
+    run_at timestamptz,
+    paused boolean,
+    next_run_at timestamptz,
+    last_run_at timestamptz,
+    last_calculation_id bigint
+);
+CREATE INDEX IF NOT EXISTS idx_schedules_deleted_at ON schedules (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_schedules_next_run_at ON schedules (next_run_at);
+
+ALTER TABLE calculations ADD COLUMN IF NOT EXISTS schedule_id bigint;
+CREATE INDEX IF NOT EXISTS idx_calculations_schedule_id ON calculations (schedule_id);
+
+CREATE TABLE IF NOT EXISTS leases (
+    name text PRIMARY KEY,
+    holder text,
+    expires_at timestamptz
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS calculation_events;
+DROP FUNCTION IF EXISTS calculation_events_append_only();
@@ -0,0 +1,23 @@
+-- calculation_events has no foreign key to calculations, the history of a calculation outlives it.
+CREATE TABLE IF NOT EXISTS calculation_events (
+    id bigserial PRIMARY KEY,
+    calculation_id bigint,
+    kind integer,
+    actor text,
+    detail text,
+    trace_id text,
+    span_id text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_calculation_events_calculation_id ON calculation_events (calculation_id);
+
+CREATE OR REPLACE FUNCTION calculation_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'calculation_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER calculation_events_append_only BEFORE UPDATE OR DELETE ON calculation_events
+    FOR EACH ROW EXECUTE FUNCTION calculation_events_append_only();
+CREATE TRIGGER calculation_events_no_truncate BEFORE TRUNCATE ON calculation_events
+    FOR EACH STATEMENT EXECUTE FUNCTION calculation_events_append_only();
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS calculation_events_notify ON calculation_events;
+DROP FUNCTION IF EXISTS notify_calculation_change();
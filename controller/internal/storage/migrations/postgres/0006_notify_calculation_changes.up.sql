@@ -0,0 +1,20 @@
+-- Every recorded calculation event is notified on the calculation_changes channel once its transaction commits.
+-- actor and detail are truncated to keep the payload well within the 8000 byte NOTIFY limit.
+CREATE OR REPLACE FUNCTION notify_calculation_change() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('calculation_changes', json_build_object(
+        'id', NEW.id,
+        'calculation_id', NEW.calculation_id,
+        'kind', NEW.kind,
+        'actor', left(NEW.actor, 256),
+        'detail', left(NEW.detail, 1024),
+        'trace_id', NEW.trace_id,
+        'span_id', NEW.span_id,
+        'created_at', NEW.created_at
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER calculation_events_notify AFTER INSERT ON calculation_events
+    FOR EACH ROW EXECUTE FUNCTION notify_calculation_change();
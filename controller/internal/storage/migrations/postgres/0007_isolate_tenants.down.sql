@@ -0,0 +1,10 @@
+DROP POLICY IF EXISTS tenant_isolation ON schedules;
+ALTER TABLE schedules DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON pipelines;
+ALTER TABLE pipelines DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS tenant_isolation ON calculations;
+ALTER TABLE calculations DISABLE ROW LEVEL SECURITY;
+
+DROP INDEX IF EXISTS idx_schedules_owner;
+DROP INDEX IF EXISTS idx_pipelines_owner;
+DROP INDEX IF EXISTS idx_calculations_owner;
@@ -0,0 +1,21 @@
+-- Every query serving a tenant is filtered by owner.
+CREATE INDEX IF NOT EXISTS idx_calculations_owner ON calculations (owner, completed_at);
+CREATE INDEX IF NOT EXISTS idx_pipelines_owner ON pipelines (owner);
+CREATE INDEX IF NOT EXISTS idx_schedules_owner ON schedules (owner);
+
+-- With DB_ROW_LEVEL_SECURITY the controller sets app.tenant in the transaction of every statement serving a
+-- tenant, and these policies hide the rows of other owners from it. Statements without app.tenant see every row.
+-- Policies don't apply to the owner of a table, so by themselves they only restrict roles other than the owner.
+-- The row-level-security step of the migrate command enforces them for the owner too and hides every row from
+-- statements without a tenant, unless they run as the calculator_background role.
+ALTER TABLE calculations ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON calculations
+    USING (coalesce(current_setting('app.tenant', true), '') IN ('', owner));
+
+ALTER TABLE pipelines ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON pipelines
+    USING (coalesce(current_setting('app.tenant', true), '') IN ('', owner));
+
+ALTER TABLE schedules ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON schedules
+    USING (coalesce(current_setting('app.tenant', true), '') IN ('', owner));
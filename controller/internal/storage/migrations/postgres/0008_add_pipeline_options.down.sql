@@ -0,0 +1,6 @@
+ALTER TABLE pipeline_steps DROP COLUMN IF EXISTS output_unit;
+
+ALTER TABLE pipelines
+    DROP COLUMN IF EXISTS mode,
+    DROP COLUMN IF EXISTS decimal_scale,
+    DROP COLUMN IF EXISTS decimal_rounding;
@@ -0,0 +1,6 @@
+ALTER TABLE pipelines
+    ADD COLUMN IF NOT EXISTS mode integer,
+    ADD COLUMN IF NOT EXISTS decimal_scale bigint,
+    ADD COLUMN IF NOT EXISTS decimal_rounding integer;
+
+ALTER TABLE pipeline_steps ADD COLUMN IF NOT EXISTS output_unit text;
@@ -0,0 +1,11 @@
+CREATE TABLE calculations (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    owner text,
+    expression text,
+    result real,
+    completed_at datetime
+);
+CREATE INDEX idx_calculations_deleted_at ON calculations (deleted_at);
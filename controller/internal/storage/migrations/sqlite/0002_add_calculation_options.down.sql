@@ -0,0 +1,10 @@
+DROP TABLE cache_entries;
+
+ALTER TABLE calculations DROP COLUMN variables;
+ALTER TABLE calculations DROP COLUMN result_value;
+ALTER TABLE calculations DROP COLUMN error;
+ALTER TABLE calculations DROP COLUMN mode;
+ALTER TABLE calculations DROP COLUMN decimal_scale;
+ALTER TABLE calculations DROP COLUMN decimal_rounding;
+ALTER TABLE calculations DROP COLUMN output_unit;
+ALTER TABLE calculations DROP COLUMN served_from_cache;
@@ -0,0 +1,14 @@
+ALTER TABLE calculations ADD COLUMN variables jsonb;
+ALTER TABLE calculations ADD COLUMN result_value jsonb;
+ALTER TABLE calculations ADD COLUMN error jsonb;
+ALTER TABLE calculations ADD COLUMN mode integer;
+ALTER TABLE calculations ADD COLUMN decimal_scale integer;
+ALTER TABLE calculations ADD COLUMN decimal_rounding integer;
+ALTER TABLE calculations ADD COLUMN output_unit text;
+ALTER TABLE calculations ADD COLUMN served_from_cache numeric;
+
+CREATE TABLE cache_entries (
+    key text PRIMARY KEY,
+    result jsonb,
+    expires_at datetime
+);
@@ -0,0 +1,24 @@
+CREATE TABLE pipelines (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    owner text,
+    variables jsonb,
+    trace_parent text,
+    failed numeric,
+    completed_at datetime
+);
+CREATE INDEX idx_pipelines_deleted_at ON pipelines (deleted_at);
+
+CREATE TABLE pipeline_steps (
+    id integer PRIMARY KEY,
+    pipeline_id integer CONSTRAINT fk_pipelines_steps REFERENCES pipelines (id),
+    position integer,
+    name text,
+    expression text,
+    depends_on jsonb,
+    calculation_id integer CONSTRAINT fk_pipeline_steps_calculation REFERENCES calculations (id)
+);
+CREATE INDEX idx_pipeline_steps_pipeline_id ON pipeline_steps (pipeline_id);
+CREATE INDEX idx_pipeline_steps_calculation_id ON pipeline_steps (calculation_id);
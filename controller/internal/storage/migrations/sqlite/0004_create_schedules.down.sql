@@ -0,0 +1,6 @@
+DROP TABLE leases;
+
+DROP INDEX idx_calculations_schedule_id;
+ALTER TABLE calculations DROP COLUMN schedule_id;
+
+DROP TABLE schedules;
@@ -0,0 +1,30 @@
+CREATE TABLE schedules (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    owner text,
+    expression text,
+    variables jsonb,
+    mode integer,
+    decimal_scale integer,
+    decimal_rounding integer,
+    output_unit text,
+    cron text,
+    run_at datetime,
+    paused numeric,
+    next_run_at datetime,
+    last_run_at datetime,
+    last_calculation_id integer
+);
+CREATE INDEX idx_schedules_deleted_at ON schedules (deleted_at);
+CREATE INDEX idx_schedules_next_run_at ON schedules (next_run_at);
+
+ALTER TABLE calculations ADD COLUMN schedule_id integer;
+CREATE INDEX idx_calculations_schedule_id ON calculations (schedule_id);
+
+CREATE TABLE leases (
+    name text PRIMARY KEY,
+    holder text,
+    expires_at datetime
+);
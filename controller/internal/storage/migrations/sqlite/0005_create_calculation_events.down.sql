@@ -0,0 +1 @@
+DROP TABLE calculation_events;
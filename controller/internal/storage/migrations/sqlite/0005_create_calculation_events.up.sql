@@ -0,0 +1,21 @@
+-- calculation_events has no foreign key to calculations, the history of a calculation outlives it.
+CREATE TABLE calculation_events (
+    id integer PRIMARY KEY,
+    calculation_id integer,
+    kind integer,
+    actor text,
+    detail text,
+    trace_id text,
+    span_id text,
+    created_at datetime
+);
+CREATE INDEX idx_calculation_events_calculation_id ON calculation_events (calculation_id);
+
+CREATE TRIGGER calculation_events_no_update BEFORE UPDATE ON calculation_events
+BEGIN
+    SELECT RAISE(ABORT, 'calculation_events is append-only');
+END;
+CREATE TRIGGER calculation_events_no_delete BEFORE DELETE ON calculation_events
+BEGIN
+    SELECT RAISE(ABORT, 'calculation_events is append-only');
+END;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_schedules_owner;
+DROP INDEX IF EXISTS idx_pipelines_owner;
+DROP INDEX IF EXISTS idx_calculations_owner;
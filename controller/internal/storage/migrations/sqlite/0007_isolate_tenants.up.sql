@@ -0,0 +1,4 @@
+-- Every query serving a tenant is filtered by owner.
+CREATE INDEX idx_calculations_owner ON calculations (owner, completed_at);
+CREATE INDEX idx_pipelines_owner ON pipelines (owner);
+CREATE INDEX idx_schedules_owner ON schedules (owner);
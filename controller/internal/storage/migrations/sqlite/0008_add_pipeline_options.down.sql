@@ -0,0 +1,5 @@
+ALTER TABLE pipeline_steps DROP COLUMN output_unit;
+
+ALTER TABLE pipelines DROP COLUMN mode;
+ALTER TABLE pipelines DROP COLUMN decimal_scale;
+ALTER TABLE pipelines DROP COLUMN decimal_rounding;
@@ -0,0 +1,5 @@
+ALTER TABLE pipelines ADD COLUMN mode integer;
+ALTER TABLE pipelines ADD COLUMN decimal_scale integer;
+ALTER TABLE pipelines ADD COLUMN decimal_rounding integer;
+
+ALTER TABLE pipeline_steps ADD COLUMN output_unit text;
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"gorm.io/gorm"
+)
+
+func (s *storage) CreatePipeline(ctx context.Context, pipeline *domain.Pipeline) error {
+	err := s.db.WithContext(ctx).Create(pipeline).Error
+	if err != nil {
+		return fmt.Errorf("unable to create pipeline: %w", dbError(err, "pipeline", 0))
+	}
+	s.wrote(ctx)
+	return nil
+}
+
+// GetPipeline returns the pipeline with its steps in order and the calculations of the dispatched steps.
+func (s *storage) GetPipeline(ctx context.Context, id uint) (*domain.Pipeline, error) {
+	var pipeline domain.Pipeline
+	err := s.db.WithContext(ctx).
+		Preload("Steps", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+		Preload("Steps.Calculation").
+		First(&pipeline, id).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to find pipeline: %w", dbError(err, "pipeline", id))
+	}
+	return &pipeline, nil
+}
+
+// GetPipelineStep returns the pipeline step a calculation was dispatched for, or nil if it isn't part of a pipeline.
+func (s *storage) GetPipelineStep(ctx context.Context, calculationID uint) (*domain.PipelineStep, error) {
+	var step domain.PipelineStep
+	err := s.db.WithContext(ctx).Where("calculation_id = ?", calculationID).Take(&step).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find pipeline step: %w", dbError(err, "pipeline step", 0))
+	}
+	return &step, nil
+}
+
+// ClaimPipelineStep creates the calculation of a step that hasn't been dispatched yet. It returns false,
+// without creating anything, if another result already dispatched the step.
+func (s *storage) ClaimPipelineStep(ctx context.Context, stepID uint, calculation *domain.Calculation) (bool, error) {
+	claimed := false
+	var events []*domain.CalculationEvent
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if events, err = createCalculation(ctx, tx, calculation); err != nil {
+			return err
+		}
+		res := tx.Model(&domain.PipelineStep{}).Where("id = ? AND calculation_id IS NULL", stepID).Update("calculation_id", calculation.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errStepClaimed
+		}
+		claimed = true
+		return nil
+	})
+	if errors.Is(err, errStepClaimed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to claim pipeline step: %w", dbError(err, "pipeline step", stepID))
+	}
+	if claimed {
+		s.committed(ctx, events...)
+	}
+	return claimed, nil
+}
+
+var errStepClaimed = errors.New("pipeline step is already claimed")
+
+// CompletePipeline marks a running pipeline as completed.
+func (s *storage) CompletePipeline(ctx context.Context, id uint, failed bool) error {
+	err := s.db.WithContext(ctx).Model(&domain.Pipeline{}).Where("id = ? AND completed_at IS NULL", id).
+		Updates(map[string]interface{}{"failed": failed, "completed_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("unable to complete pipeline: %w", dbError(err, "pipeline", id))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	stdlog "log"
+	"os"
+	"time"
+
+	"github.com/kostyay/otel-demo/common/log"
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The connection pool metrics, observed from sql.DBStats on every collection.
+var (
+	openConnections, _ = otelcommon.Meter().Int64ObservableGauge("db.client.connections.open", metric.WithDescription("Number of established connections, in use or idle"))
+	usedConnections, _ = otelcommon.Meter().Int64ObservableGauge("db.client.connections.usage", metric.WithDescription("Number of connections by state, used or idle"))
+	maxConnections, _  = otelcommon.Meter().Int64ObservableGauge("db.client.connections.max", metric.WithDescription("Maximum number of open connections allowed"))
+	waitCount, _       = otelcommon.Meter().Int64ObservableCounter("db.client.connections.wait_count", metric.WithDescription("Number of times a connection had to be waited for"))
+	waitDuration, _    = otelcommon.Meter().Float64ObservableCounter("db.client.connections.wait_duration", metric.WithDescription("Total time spent waiting for a connection"), metric.WithUnit("ms"))
+	slowQueries, _     = otelcommon.Meter().Int64Counter("db.client.slow_queries", metric.WithDescription("Number of queries slower than DB_SLOW_QUERY_THRESHOLD"))
+)
+
+// configurePool sizes the connection pool of db.
+func configurePool(cfg *config.Options, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("unable to get connection pool: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(cfg.DB.Pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.DB.Pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.DB.Pool.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.DB.Pool.ConnMaxIdleTime)
+	return nil
+}
+
+// observePool exports the stats of the connection pool of db as metrics, labelled with name, until the
+// returned registration is unregistered.
+func observePool(db *gorm.DB, name string) (metric.Registration, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get connection pool: %w", err)
+	}
+
+	pool := attribute.String("pool.name", name)
+	registration, err := otelcommon.Meter().RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		observeStats(o, sqlDB.Stats(), pool)
+		return nil
+	}, openConnections, usedConnections, maxConnections, waitCount, waitDuration)
+	if err != nil {
+		return nil, fmt.Errorf("unable to register connection pool metrics: %w", err)
+	}
+	return registration, nil
+}
+
+func observeStats(o metric.Observer, stats sql.DBStats, pool attribute.KeyValue) {
+	o.ObserveInt64(openConnections, int64(stats.OpenConnections), metric.WithAttributes(pool))
+	o.ObserveInt64(usedConnections, int64(stats.InUse), metric.WithAttributes(pool, attribute.String("state", "used")))
+	o.ObserveInt64(usedConnections, int64(stats.Idle), metric.WithAttributes(pool, attribute.String("state", "idle")))
+	o.ObserveInt64(maxConnections, int64(stats.MaxOpenConnections), metric.WithAttributes(pool))
+	o.ObserveInt64(waitCount, stats.WaitCount, metric.WithAttributes(pool))
+	o.ObserveFloat64(waitDuration, float64(stats.WaitDuration)/float64(time.Millisecond), metric.WithAttributes(pool))
+}
+
+// slowQueryLogger logs queries slower than threshold with the trace context of the query, on top of what the
+// gorm logger it wraps logs.
+type slowQueryLogger struct {
+	logger.Interface
+	threshold time.Duration
+}
+
+func newLogger(threshold time.Duration) logger.Interface {
+	// The same as logger.Default, without its own slow query log.
+	l := logger.New(stdlog.New(os.Stdout, "\r\n", stdlog.LstdFlags), logger.Config{
+		LogLevel: logger.Warn,
+		Colorful: true,
+	})
+	return &slowQueryLogger{Interface: l, threshold: threshold}
+}
+
+func (l *slowQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &slowQueryLogger{Interface: l.Interface.LogMode(level), threshold: l.threshold}
+}
+
+func (l *slowQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	l.Interface.Trace(ctx, begin, fc, err)
+
+	elapsed := time.Since(begin)
+	if l.threshold <= 0 || elapsed < l.threshold {
+		return
+	}
+	sql, rows := fc()
+	slowQueries.Add(ctx, 1)
+	log.WithContext(ctx).With("sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds()).
+		Warnf("slow query took %s, over the %s threshold", elapsed, l.threshold)
+}
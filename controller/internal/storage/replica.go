@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kostyay/otel-demo/controller/internal/procedure"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const primaryName = "primary"
+
+// maxWriters bounds the read-your-writes windows tracked before expired ones are dropped.
+const maxWriters = 1024
+
+// anonymousWriter is the read-your-writes window shared by every request when tenants aren't authenticated.
+const anonymousWriter = "anonymous"
+
+type primaryKey struct{}
+
+// WithPrimary returns a copy of ctx whose reads go to the primary, for reads serving a request that can't
+// tolerate replication lag.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// replicas routes the reads serving a request to the read replicas.
+type replicas struct {
+	dbs  []*gorm.DB
+	next atomic.Uint64
+
+	window time.Duration
+	mu     sync.Mutex
+	// writes has the end of the read-your-writes window of every writer that recently wrote.
+	writes map[string]time.Time
+}
+
+// pick returns the database reads serving ctx go to: the replicas in turn, unless ctx asks for the primary or
+// the writer of ctx wrote within the read-your-writes window. Background work always reads the primary, as it
+// acts on writes just made.
+func (r *replicas) pick(ctx context.Context, primary *gorm.DB) *gorm.DB {
+	forcePrimary, _ := ctx.Value(primaryKey{}).(bool)
+	if len(r.dbs) == 0 || procedure.FromContext(ctx) == "" || forcePrimary || r.recentlyWrote(writer(ctx)) {
+		return primary
+	}
+	return r.dbs[r.next.Add(1)%uint64(len(r.dbs))]
+}
+
+// writer returns whose read-your-writes window the request of ctx reads and extends: its authenticated
+// tenant. The client address can't tell callers apart, X-Forwarded-For is set by the client itself, so
+// without authenticated tenants every request shares a single window. It is empty outside of a request.
+func writer(ctx context.Context) string {
+	if procedure.FromContext(ctx) == "" {
+		return ""
+	}
+	if id := tenant.FromContext(ctx); id != "" {
+		return tenantWriter(id)
+	}
+	return anonymousWriter
+}
+
+func tenantWriter(id string) string {
+	return "tenant " + id
+}
+
+// wrote starts the read-your-writes window of the writer of ctx.
+func (r *replicas) wrote(ctx context.Context) {
+	r.extend(writer(ctx))
+}
+
+// wroteFor starts the read-your-writes windows of the requests that read what background work wrote on
+// behalf of owner: those of owner when it is an authenticated tenant, or every request when tenants aren't.
+func (r *replicas) wroteFor(owner string) {
+	r.extend(tenantWriter(owner), anonymousWriter)
+}
+
+// extend starts the read-your-writes window of every writer in writers.
+func (r *replicas) extend(writers ...string) {
+	if len(r.dbs) == 0 || r.window <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if len(r.writes) >= maxWriters {
+		for other, until := range r.writes {
+			if !until.After(now) {
+				delete(r.writes, other)
+			}
+		}
+	}
+	for _, w := range writers {
+		if w != "" {
+			r.writes[w] = now.Add(r.window)
+		}
+	}
+}
+
+func (r *replicas) recentlyWrote(w string) bool {
+	if r.window <= 0 || w == "" {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	until, ok := r.writes[w]
+	if ok && !until.After(time.Now()) {
+		delete(r.writes, w)
+		return false
+	}
+	return ok
+}
+
+// replicaPlugin records which database, the primary or one of its replicas, ran a statement on its span.
+type replicaPlugin struct {
+	name string
+}
+
+func (p *replicaPlugin) Name() string {
+	return "replica"
+}
+
+func (p *replicaPlugin) Initialize(db *gorm.DB) error {
+	// Registered after the otel plugin, so the span of the statement is already started.
+	callbacks := db.Callback()
+	processors := []struct {
+		name     string
+		register func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register},
+	}
+
+	for _, processor := range processors {
+		err := processor.register("replica:before_"+processor.name, func(tx *gorm.DB) {
+			trace.SpanFromContext(tx.Statement.Context).SetAttributes(attribute.String("db.replica", p.name))
+		})
+		if err != nil {
+			return fmt.Errorf("unable to register %s callback: %w", processor.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/procedure"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"gorm.io/gorm"
+)
+
+func TestReplicasReadYourWrites(t *testing.T) {
+	primary, replica := &gorm.DB{}, &gorm.DB{}
+	request := procedure.NewContext(context.Background(), "/calculator.v1.CalculatorService/Get", "client")
+	owner, other := tenant.NewContext(request, "owner"), tenant.NewContext(request, "other")
+
+	tests := []struct {
+		name  string
+		write func(r *replicas)
+		// reads are the requests that must read the primary, every other request reads the replica.
+		reads []context.Context
+	}{
+		{
+			name: "no writes",
+		},
+		{
+			name:  "tenant wrote",
+			write: func(r *replicas) { r.wrote(owner) },
+			reads: []context.Context{owner},
+		},
+		{
+			name:  "anonymous request wrote",
+			write: func(r *replicas) { r.wrote(request) },
+			reads: []context.Context{request},
+		},
+		{
+			name:  "background work wrote for a tenant",
+			write: func(r *replicas) { r.wroteFor("owner") },
+			reads: []context.Context{owner, request},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &replicas{dbs: []*gorm.DB{replica}, window: time.Minute, writes: map[string]time.Time{}}
+			if tt.write != nil {
+				tt.write(r)
+			}
+
+			for _, ctx := range []context.Context{owner, other, request} {
+				want := replica
+				for _, read := range tt.reads {
+					if read == ctx {
+						want = primary
+					}
+				}
+				if got := r.pick(ctx, primary); got != want {
+					t.Errorf("pick(%q) read the primary = %v, want %v", writer(ctx), got == primary, want == primary)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryFromLaggingReplica(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Options{}
+	cfg.DB.Driver = config.DBDriverSQLite
+	open := func(name string) *storage {
+		cfg.DB.DSN = filepath.Join(t.TempDir(), name)
+		db, dialect, err := openDSN(cfg, cfg.DB.DSN)
+		if err != nil {
+			t.Fatalf("openDSN(%s) = %v", name, err)
+		}
+		migrator, err := newMigrator(db, dialect)
+		if err != nil {
+			t.Fatalf("newMigrator(%s) = %v", name, err)
+		}
+		if _, err := migrator.Up(ctx); err != nil {
+			t.Fatalf("Up(%s) = %v", name, err)
+		}
+		return &storage{db: db}
+	}
+	primary, replica := open("primary.db"), open("replica.db")
+
+	// The replica has the calculation, but not yet its result.
+	calculation := &domain.Calculation{Expression: "1"}
+	for _, s := range []*storage{primary, replica} {
+		if err := s.CreateCalculation(ctx, calculation); err != nil {
+			t.Fatalf("CreateCalculation() = %v", err)
+		}
+	}
+	if err := primary.UpdateResult(ctx, calculation.ID, &pb.Value{Kind: &pb.Value_IntValue{IntValue: 1}}); err != nil {
+		t.Fatalf("UpdateResult() = %v", err)
+	}
+	primary.replicas = replicas{dbs: []*gorm.DB{replica.db}, writes: map[string]time.Time{}}
+
+	request := procedure.NewContext(ctx, "/calculator.v1.CalculatorService/WatchCalculation", "client")
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want int
+	}{
+		{name: "replica", ctx: request, want: 1},
+		{name: "primary", ctx: WithPrimary(request), want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := primary.GetHistory(tt.ctx, calculation.ID)
+			if err != nil {
+				t.Fatalf("GetHistory() = %v", err)
+			}
+			if len(events) != tt.want {
+				t.Errorf("GetHistory() returned %d events, want %d", len(events), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+-- Reverts enable.sql, back to the policies of migration 7. The calculator_background role is kept, other
+-- databases of the cluster may still use it, drop it once none does.
+ALTER TABLE schedules NO FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS background_access ON schedules;
+ALTER POLICY tenant_isolation ON schedules USING (coalesce(current_setting('app.tenant', true), '') IN ('', owner));
+
+ALTER TABLE pipelines NO FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS background_access ON pipelines;
+ALTER POLICY tenant_isolation ON pipelines USING (coalesce(current_setting('app.tenant', true), '') IN ('', owner));
+
+ALTER TABLE calculations NO FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS background_access ON calculations;
+ALTER POLICY tenant_isolation ON calculations USING (coalesce(current_setting('app.tenant', true), '') IN ('', owner));
+
+REVOKE calculator_background FROM :"login_role";
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE USAGE, SELECT ON SEQUENCES FROM calculator_background;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE SELECT, INSERT, UPDATE, DELETE ON TABLES FROM calculator_background;
+REVOKE USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public FROM calculator_background;
+REVOKE SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public FROM calculator_background;
@@ -0,0 +1,37 @@
+-- Enforces the isolation of tenants for DB_ROW_LEVEL_SECURITY, on top of the policies of migration 7. Work done
+-- on behalf of every tenant (the scheduler, storing results, unauthenticated requests) switches to the
+-- calculator_background role for its transaction, every other statement only sees the rows of the tenant it
+-- sets in app.tenant, and nothing without one. The policies apply to the owner of the tables too, so connecting
+-- as the owner doesn't bypass them.
+--
+-- Run by `controller migrate row-level-security <login role>`, or by psql with -v login_role=<login role>.
+-- Roles are shared by every database of the cluster, creating one needs the CREATEROLE privilege.
+DO $$
+BEGIN
+    CREATE ROLE calculator_background NOLOGIN;
+EXCEPTION WHEN duplicate_object THEN
+    NULL;
+END
+$$;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO calculator_background;
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO calculator_background;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO calculator_background;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO calculator_background;
+-- The role the controller logs in as switches to calculator_background.
+GRANT calculator_background TO :"login_role";
+
+ALTER POLICY tenant_isolation ON calculations USING (owner = nullif(current_setting('app.tenant', true), ''));
+DROP POLICY IF EXISTS background_access ON calculations;
+CREATE POLICY background_access ON calculations TO calculator_background USING (true);
+ALTER TABLE calculations FORCE ROW LEVEL SECURITY;
+
+ALTER POLICY tenant_isolation ON pipelines USING (owner = nullif(current_setting('app.tenant', true), ''));
+DROP POLICY IF EXISTS background_access ON pipelines;
+CREATE POLICY background_access ON pipelines TO calculator_background USING (true);
+ALTER TABLE pipelines FORCE ROW LEVEL SECURITY;
+
+ALTER POLICY tenant_isolation ON schedules USING (owner = nullif(current_setting('app.tenant', true), ''));
+DROP POLICY IF EXISTS background_access ON schedules;
+CREATE POLICY background_access ON schedules TO calculator_background USING (true);
+ALTER TABLE schedules FORCE ROW LEVEL SECURITY;
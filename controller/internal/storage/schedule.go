@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// scheduleFields are the columns UpdateSchedule replaces, the run history is owned by the scheduler.
+var scheduleFields = []string{"owner", "expression", "variables", "mode", "decimal_scale", "decimal_rounding", "output_unit", "cron", "run_at", "paused", "next_run_at", "updated_at"}
+
+func (s *storage) CreateSchedule(ctx context.Context, schedule *domain.Schedule) error {
+	err := s.db.WithContext(ctx).Create(schedule).Error
+	if err != nil {
+		return fmt.Errorf("unable to create schedule: %w", dbError(err, "schedule", 0))
+	}
+	s.wrote(ctx)
+	return nil
+}
+
+func (s *storage) GetSchedule(ctx context.Context, id uint) (*domain.Schedule, error) {
+	var schedule domain.Schedule
+	err := s.reader(ctx).First(&schedule, id).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to find schedule: %w", dbError(err, "schedule", id))
+	}
+	return &schedule, nil
+}
+
+func (s *storage) GetSchedules(ctx context.Context) ([]*domain.Schedule, error) {
+	var schedules []*domain.Schedule
+	err := s.reader(ctx).Order("id").Find(&schedules).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to find schedules: %w", dbError(err, "schedule", 0))
+	}
+	return schedules, nil
+}
+
+func (s *storage) UpdateSchedule(ctx context.Context, schedule *domain.Schedule) error {
+	res := s.db.WithContext(ctx).Model(schedule).Select(scheduleFields).Updates(schedule)
+	if res.Error != nil {
+		return fmt.Errorf("unable to update schedule: %w", dbError(res.Error, "schedule", schedule.ID))
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("unable to update schedule: %w", notFound("schedule", schedule.ID))
+	}
+	s.wrote(ctx)
+	return nil
+}
+
+func (s *storage) DeleteSchedule(ctx context.Context, id uint) error {
+	res := s.db.WithContext(ctx).Delete(&domain.Schedule{}, id)
+	if res.Error != nil {
+		return fmt.Errorf("unable to delete schedule: %w", dbError(res.Error, "schedule", id))
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("unable to delete schedule: %w", notFound("schedule", id))
+	}
+	s.wrote(ctx)
+	return nil
+}
+
+// DueSchedules returns up to limit unpaused schedules whose next run is at or before now, oldest first.
+func (s *storage) DueSchedules(ctx context.Context, now time.Time, limit int) ([]*domain.Schedule, error) {
+	var schedules []*domain.Schedule
+	err := staticComment(s.db.WithContext(ctx)).Where("paused = ? AND next_run_at <= ?", false, now).Order("next_run_at").Limit(limit).Find(&schedules).Error
+	if err != nil {
+		return nil, fmt.Errorf("unable to find due schedules: %w", dbError(err, "schedule", 0))
+	}
+	return schedules, nil
+}
+
+// RecordScheduleRun creates the calculation of a run and moves the schedule on to next, which is nil when it
+// won't run again. It returns false without creating anything if the run was already recorded, because the
+// schedule changed since it was read.
+func (s *storage) RecordScheduleRun(ctx context.Context, schedule *domain.Schedule, calculation *domain.Calculation, next *time.Time) (bool, error) {
+	recorded := false
+	var events []*domain.CalculationEvent
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if events, err = createCalculation(ctx, tx, calculation); err != nil {
+			return err
+		}
+
+		res := tx.Model(&domain.Schedule{}).Where("id = ? AND next_run_at = ? AND updated_at = ?", schedule.ID, schedule.NextRunAt, schedule.UpdatedAt).
+			Updates(map[string]interface{}{
+				"next_run_at":         next,
+				"last_run_at":         calculation.CreatedAt,
+				"last_calculation_id": calculation.ID,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errScheduleChanged
+		}
+		recorded = true
+		return nil
+	})
+	if errors.Is(err, errScheduleChanged) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to record schedule run: %w", dbError(err, "schedule", schedule.ID))
+	}
+	if recorded {
+		s.committed(ctx, events...)
+	}
+	return recorded, nil
+}
+
+var errScheduleChanged = errors.New("schedule changed since it was read")
+
+// AcquireLease takes or renews the named lease for holder. It returns false while another holder's lease
+// hasn't expired.
+func (s *storage) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res := staticComment(s.db.WithContext(ctx)).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"holder": holder, "expires_at": now.Add(ttl)}),
+		Where:     clause.Where{Exprs: []clause.Expression{gorm.Expr("leases.holder = ? OR leases.expires_at < ?", holder, now)}},
+	}).Create(&domain.Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)})
+	if res.Error != nil {
+		return false, fmt.Errorf("unable to acquire lease %q: %w", name, dbError(res.Error, "lease", 0))
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -3,18 +3,36 @@ package storage
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
 	"github.com/kostyay/otel-demo/common/log"
+	"github.com/kostyay/otel-demo/common/version"
+	"github.com/kostyay/otel-demo/controller/internal/procedure"
 	"go.opentelemetry.io/otel/propagation"
 	"gorm.io/gorm"
-	"net/url"
+	"gorm.io/gorm/clause"
 )
 
 const (
 	callBackBeforeName = "sqlcommenter:before"
-	callBackAfterName  = "sqlcommenter:after"
+
+	// staticCommentKey is the gorm setting marking statements whose comment leaves out the traceparent.
+	staticCommentKey = "sqlcommenter:static"
+
+	// commentClause is the clause the comment is built as, it is built last in every statement.
+	commentClause = "SQLCOMMENTER"
 )
 
+// sqlCommenterPlugin adds a sqlcommenter comment (https://google.github.io/sqlcommenter/spec/) to every
+// statement, so queries seen by the database can be tied to the trace and the procedure that issued them.
+// Raw statements that already contain "/*" are left unchanged, as the spec requires, even when it is part of a
+// string literal rather than a comment.
 type sqlCommenterPlugin struct {
+	// traceParent adds the traceparent and tracestate of the span to the comment. The rest of the comment only
+	// changes with the procedure, so without them the database can group statements by their text.
+	traceParent bool
 }
 
 func (p *sqlCommenterPlugin) Name() string {
@@ -25,64 +43,121 @@ func beforeName(name string) string {
 	return callBackBeforeName + "_" + name
 }
 
-func afterName(name string) string {
-	return callBackAfterName + "_" + name
-}
-
 func encodeURL(k string) string {
 	return url.QueryEscape(k)
 }
 
-// ExtractTraceparent extracts the traceparent field using OpenTelemetry library.
+// ExtractTraceparent extracts the traceparent and tracestate fields using OpenTelemetry library.
 func ExtractTraceparent(ctx context.Context) propagation.MapCarrier {
 	// Serialize the context into carrier
-	textMapPropogator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
 	carrier := propagation.MapCarrier{}
-	textMapPropogator.Inject(ctx, carrier)
+	propagation.TraceContext{}.Inject(ctx, carrier)
 	return carrier
 }
 
-func (p *sqlCommenterPlugin) Initialize(db *gorm.DB) error {
-	if err := db.Callback().Create().After("gorm:create").Register(afterName("create"), p.after()); err != nil {
-		return err
-	}
-	if err := db.Callback().Create().Before("gorm:create").Register(beforeName("create"), p.after()); err != nil {
-		return err
-	}
-	if err := db.Callback().Update().After("gorm:update").Register(afterName("update"), p.after()); err != nil {
-		return err
+func (plugin *sqlCommenterPlugin) Initialize(db *gorm.DB) error {
+	// Statements gorm builds get the comment as their last clause, while raw statements are already built
+	// and get it appended. Either way it is added right before the statement runs, after the otel plugin
+	// started the span of the statement.
+	callbacks := db.Callback()
+	processors := []struct {
+		name     string
+		clauses  *[]string
+		register func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", &callbacks.Create().Clauses, callbacks.Create().Before("gorm:create").Register},
+		{"query", &callbacks.Query().Clauses, callbacks.Query().Before("gorm:query").Register},
+		{"update", &callbacks.Update().Clauses, callbacks.Update().Before("gorm:update").Register},
+		{"delete", &callbacks.Delete().Clauses, callbacks.Delete().Before("gorm:delete").Register},
+		{"row", &callbacks.Row().Clauses, callbacks.Row().Before("gorm:row").Register},
+		{"raw", &callbacks.Raw().Clauses, callbacks.Raw().Before("gorm:raw").Register},
 	}
 
-	if err := db.Callback().Delete().After("gorm:delete").Register(afterName("delete"), p.after()); err != nil {
-		return err
+	for _, p := range processors {
+		*p.clauses = append(append([]string(nil), *p.clauses...), commentClause)
+		if err := p.register(beforeName(p.name), plugin.comment); err != nil {
+			return fmt.Errorf("unable to register %s callback: %w", p.name, err)
+		}
 	}
 
 	return nil
 }
 
-func (p *sqlCommenterPlugin) after() func(tx *gorm.DB) {
-	return func(tx *gorm.DB) {
-		carrier := ExtractTraceparent(tx.Statement.Context)
-		traceID, ok := carrier["traceparent"]
-		if !ok {
-			log.Info("No traceparent found")
-			return
-		}
+func (plugin *sqlCommenterPlugin) comment(tx *gorm.DB) {
+	if tx.Error != nil {
+		return
+	}
 
-		tx.Statement.SQL.WriteString(fmt.Sprintf(" /*traceparent='%s',framework='gorm'*/", encodeURL(traceID)))
+	_, static := tx.Get(staticCommentKey)
+	c := sqlComment(tx.Statement.Context, tx.Dialector.Name(), plugin.traceParent && !static)
+	if tx.Statement.SQL.Len() == 0 {
+		tx.Statement.AddClause(commentExpression(c))
+		return
+	}
+	// The spec forbids changing a statement that already has a comment.
+	if strings.Contains(tx.Statement.SQL.String(), "/*") {
+		log.WithContext(tx.Statement.Context).Debug("raw statement already has a comment, leaving it without a sqlcommenter comment")
+		return
 	}
+	tx.Statement.SQL.WriteString(" " + c)
 }
 
-func sqlComments(ctx context.Context) string {
-	carrier := ExtractTraceparent(ctx)
-	traceID, ok := carrier["traceparent"]
-	if !ok {
-		return ""
+// staticComment returns db with the traceparent left out of the comments of its statements, for statements
+// run on every request or tick.
+func staticComment(db *gorm.DB) *gorm.DB {
+	return db.Set(staticCommentKey, true)
+}
+
+// sqlComment serializes the comment for a statement run with ctx: every key and value is URL encoded, values
+// are quoted and the pairs are sorted by key. Empty values are left out, as is the trace context unless
+// traceParent is set.
+func sqlComment(ctx context.Context, driver string, traceParent bool) string {
+	service, method := procedure.Split(procedure.FromContext(ctx))
+	fields := map[string]string{
+		"controller":  service,
+		"action":      method,
+		"route":       procedure.FromContext(ctx),
+		"application": version.ServiceName,
+		"db_driver":   driver,
+		"framework":   "gorm",
+	}
+
+	if traceParent {
+		carrier := ExtractTraceparent(ctx)
+		fields["traceparent"] = carrier.Get("traceparent")
+		fields["tracestate"] = carrier.Get("tracestate")
 	}
 
-	return fmt.Sprintf(" /*traceparent='%s',framework='gorm',route='list',action='list'*/", encodeURL(traceID))
+	pairs := make([]string, 0, len(fields))
+	for key, value := range fields {
+		if value == "" {
+			continue
+		}
+		value = strings.ReplaceAll(encodeURL(value), "'", `\'`)
+		pairs = append(pairs, fmt.Sprintf("%s='%s'", encodeURL(key), value))
+	}
+	sort.Strings(pairs)
+
+	return "/*" + strings.Join(pairs, ",") + "*/"
+}
+
+// commentExpression is the comment clause of a statement.
+type commentExpression string
+
+func (c commentExpression) Name() string {
+	return commentClause
+}
+
+func (c commentExpression) Build(builder clause.Builder) {
+	builder.WriteString(string(c))
+}
+
+func (c commentExpression) MergeClause(clause *clause.Clause) {
+	// Without a name, only the comment itself is built.
+	clause.Name = ""
+	clause.Expression = c
 }
 
-func NewSQLCommenterPlugin() *sqlCommenterPlugin {
-	return &sqlCommenterPlugin{}
+func NewSQLCommenterPlugin(traceParent bool) *sqlCommenterPlugin {
+	return &sqlCommenterPlugin{traceParent: traceParent}
 }
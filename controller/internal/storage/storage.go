@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/dialers/postgres"
+	"github.com/glebarez/sqlite"
+	otelgorm "github.com/kostyay/gorm-opentelemetry"
+	"github.com/kostyay/otel-demo/common/log"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/metric"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Storage is everything the controller persists. Every backend returns errors wrapping an *Error for missing
+// records and the database failures callers can handle, see ErrNotFound and the other kinds. When ctx carries
+// a tenant, see package tenant, only the calculations, pipelines and schedules it owns are visible and the
+// ones it creates are owned by it.
+type Storage interface {
+	CreateCalculation(ctx context.Context, calculation *domain.Calculation) error
+	GetCalculation(ctx context.Context, id uint) (*domain.Calculation, error)
+	GetCalculations(ctx context.Context) ([]*domain.Calculation, error)
+	// UpdateResult and UpdateError complete a calculation, unless it already is. They return ErrNotFound for
+	// missing calculations.
+	UpdateResult(ctx context.Context, id uint, result *pb.Value) error
+	UpdateError(ctx context.Context, id uint, evalErr *pb.EvaluationError) error
+	RecordEvent(ctx context.Context, calculationID uint, kind pb.CalculationEventKind, detail string) error
+	GetHistory(ctx context.Context, calculationID uint) ([]*domain.CalculationEvent, error)
+	PendingCalculations(ctx context.Context, since time.Time) (int, error)
+
+	GetCachedResult(ctx context.Context, key string) (*domain.CacheEntry, error)
+	PutCachedResult(ctx context.Context, entry *domain.CacheEntry) error
+
+	CreatePipeline(ctx context.Context, pipeline *domain.Pipeline) error
+	GetPipeline(ctx context.Context, id uint) (*domain.Pipeline, error)
+	GetPipelineStep(ctx context.Context, calculationID uint) (*domain.PipelineStep, error)
+	ClaimPipelineStep(ctx context.Context, stepID uint, calculation *domain.Calculation) (bool, error)
+	CompletePipeline(ctx context.Context, id uint, failed bool) error
+
+	CreateSchedule(ctx context.Context, schedule *domain.Schedule) error
+	GetSchedule(ctx context.Context, id uint) (*domain.Schedule, error)
+	GetSchedules(ctx context.Context) ([]*domain.Schedule, error)
+	UpdateSchedule(ctx context.Context, schedule *domain.Schedule) error
+	DeleteSchedule(ctx context.Context, id uint) error
+	DueSchedules(ctx context.Context, now time.Time, limit int) ([]*domain.Schedule, error)
+	RecordScheduleRun(ctx context.Context, schedule *domain.Schedule, calculation *domain.Calculation, next *time.Time) (bool, error)
+
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+
+	Subscribe(ctx context.Context) <-chan Change
+
+	// Close releases the connections of the storage and stops exporting their metrics.
+	Close() error
+}
+
+// storage is the SQL implementation of Storage, shared by every database driver.
+type storage struct {
+	db *gorm.DB
+	replicas
+
+	changes changes
+	// listener opens the connection receiving changes from postgres, it is nil for other databases.
+	listener   func() *pq.Listener
+	listenOnce sync.Once
+	// listening is the listener opened by the first subscriber, if any.
+	listening *pq.Listener
+
+	// metrics observe the connection pools of db and the replicas.
+	metrics []metric.Registration
+}
+
+// New opens the storage backend selected by DB_DRIVER. A SQL database must already be migrated to the
+// schema version this controller needs.
+func New(cfg *config.Options) (Storage, error) {
+	if cfg.DB.Driver == config.DBDriverMemory {
+		log.Info("Using in-memory storage, nothing is persisted")
+		return NewMemory(), nil
+	}
+
+	db, dialect, err := open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &storage{db: db, replicas: replicas{window: cfg.DB.ReadYourWritesWindow, writes: map[string]time.Time{}}}
+	if err := s.open(cfg, db, primaryName); err != nil {
+		return nil, errors.Join(err, s.Close())
+	}
+
+	migrator, err := newMigrator(db, dialect)
+	if err != nil {
+		return nil, errors.Join(err, s.Close())
+	}
+	if err := migrator.Check(context.Background()); err != nil {
+		return nil, errors.Join(err, s.Close())
+	}
+	if err := migrator.CheckRowLevelSecurity(context.Background(), cfg.DB.RowLevelSecurity); err != nil {
+		return nil, errors.Join(err, s.Close())
+	}
+
+	if dialect == dialectPostgres {
+		s.listener = newListener(cfg, primaryDSN(cfg))
+	}
+	for i, dsn := range cfg.DB.ReplicaDSNs {
+		replica, _, err := openDSN(cfg, dsn)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("unable to open replica %d: %w", i+1, err), s.Close())
+		}
+		s.replicas.dbs = append(s.replicas.dbs, replica)
+		if err := s.open(cfg, replica, fmt.Sprintf("replica-%d", i+1)); err != nil {
+			return nil, errors.Join(err, s.Close())
+		}
+	}
+	return s, nil
+}
+
+// open instruments db and isolates its tenants. name identifies db among the primary and its replicas.
+func (s *storage) open(cfg *config.Options, db *gorm.DB, name string) error {
+	registration, err := instrument(cfg, db, name)
+	if err != nil {
+		return err
+	}
+	s.metrics = append(s.metrics, registration)
+	return scope(db, cfg)
+}
+
+// instrument traces and comments every statement run on db, and exports the stats of its connection pool until
+// the returned registration is unregistered. name identifies db among the primary and its replicas.
+func instrument(cfg *config.Options, db *gorm.DB, name string) (metric.Registration, error) {
+	// Initialize otel plugin with options
+	plugin := otelgorm.NewPlugin()
+	err := db.Use(plugin)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use otelgorm plugin: %w", err)
+	}
+
+	err = db.Use(&replicaPlugin{name: name})
+	if err != nil {
+		return nil, fmt.Errorf("unable to use replica plugin: %w", err)
+	}
+
+	err = db.Use(NewSQLCommenterPlugin(cfg.DB.SQLCommentTraceParent))
+	if err != nil {
+		return nil, fmt.Errorf("unable to use sqlcommenter plugin: %w", err)
+	}
+
+	return observePool(db, name)
+}
+
+// Close stops the change feed, stops exporting the pool metrics and closes the primary and the replicas.
+func (s *storage) Close() error {
+	var errs []error
+	// Subscribers can't start the listener anymore.
+	s.listenOnce.Do(func() {})
+	if s.listening != nil {
+		if err := s.listening.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("unable to close change feed: %w", err))
+		}
+	}
+	for _, registration := range s.metrics {
+		if err := registration.Unregister(); err != nil {
+			errs = append(errs, fmt.Errorf("unable to unregister connection pool metrics: %w", err))
+		}
+	}
+	for _, db := range append([]*gorm.DB{s.db}, s.replicas.dbs...) {
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.Close()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to close database: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reader returns the database to read from, a replica if possible. Only reads that can tolerate replication
+// lag use it.
+func (s *storage) reader(ctx context.Context) *gorm.DB {
+	return s.replicas.pick(ctx, s.db).WithContext(ctx)
+}
+
+// NewMigrator opens the SQL database selected by DB_DRIVER for migrating its schema.
+func NewMigrator(cfg *config.Options) (*Migrator, error) {
+	if cfg.DB.Driver == config.DBDriverMemory {
+		return nil, fmt.Errorf("in-memory storage has no schema to migrate")
+	}
+
+	db, dialect, err := open(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, dialect)
+}
+
+// open opens the SQL database selected by DB_DRIVER and returns it with its migrations dialect.
+func open(cfg *config.Options) (*gorm.DB, string, error) {
+	return openDSN(cfg, primaryDSN(cfg))
+}
+
+// primaryDSN returns the DSN of the SQL database selected by DB_DRIVER.
+func primaryDSN(cfg *config.Options) string {
+	if cfg.DB.Driver == config.DBDriverCloudSQL || (cfg.DB.Driver == config.DBDriverPostgres && cfg.DB.DSN == "") {
+		return postgresDSN(cfg)
+	}
+	return cfg.DB.DSN
+}
+
+// openDSN opens the database dsn with the DB_DRIVER driver and returns it with its migrations dialect.
+func openDSN(cfg *config.Options, dsn string) (*gorm.DB, string, error) {
+	var dialector gorm.Dialector
+	dialect := dialectPostgres
+	switch cfg.DB.Driver {
+	case config.DBDriverCloudSQL:
+		log.Infof("Connecting to Cloud SQL database, dsn=%s", redactDSN(dsn))
+		dialector = postgres.New(postgres.Config{
+			DriverName: "cloudsqlpostgres",
+			DSN:        dsn,
+		})
+	case config.DBDriverPostgres:
+		log.Infof("Connecting to postgres database, dsn=%s", redactDSN(dsn))
+		dialector = postgres.Open(dsn)
+	case config.DBDriverSQLite:
+		log.Infof("Opening sqlite database, file=%s", dsn)
+		dialector = sqlite.Open(dsn)
+		dialect = dialectSQLite
+	default:
+		return nil, "", fmt.Errorf("unknown database driver %q", cfg.DB.Driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: newLogger(cfg.DB.SlowQueryThreshold)})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to open database: %w", err)
+	}
+	if err := configurePool(cfg, db); err != nil {
+		return nil, "", err
+	}
+	return db, dialect, nil
+}
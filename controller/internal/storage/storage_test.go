@@ -0,0 +1,65 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"github.com/kostyay/otel-demo/controller/internal/storage/storagetest"
+)
+
+// postgresDSNEnv names the database the suite also runs against, it is skipped when unset. The database is
+// migrated to the latest version first.
+const postgresDSNEnv = "STORAGE_TEST_POSTGRES_DSN"
+
+func TestConformance(t *testing.T) {
+	tests := []struct {
+		driver string
+		dsn    func(t *testing.T) string
+	}{
+		{driver: config.DBDriverMemory, dsn: func(*testing.T) string { return "" }},
+		{driver: config.DBDriverSQLite, dsn: func(t *testing.T) string { return filepath.Join(t.TempDir(), "storage.db") }},
+		{driver: config.DBDriverPostgres, dsn: func(t *testing.T) string {
+			dsn := os.Getenv(postgresDSNEnv)
+			if dsn == "" {
+				t.Skipf("%s is not set", postgresDSNEnv)
+			}
+			return dsn
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := &config.Options{}
+			cfg.DB.Driver = tt.driver
+			cfg.DB.DSN = tt.dsn(t)
+
+			if tt.driver != config.DBDriverMemory {
+				migrator, err := storage.NewMigrator(cfg)
+				if err != nil {
+					t.Fatalf("NewMigrator() = %v", err)
+				}
+				if _, err := migrator.Up(ctx); err != nil {
+					t.Fatalf("Up() = %v", err)
+				}
+			}
+
+			s, err := storage.New(cfg)
+			if err != nil {
+				t.Fatalf("New() = %v", err)
+			}
+			t.Cleanup(func() {
+				if err := s.Close(); err != nil {
+					t.Errorf("Close() = %v", err)
+				}
+			})
+			if err := storagetest.Run(ctx, s); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
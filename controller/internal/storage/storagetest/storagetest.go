@@ -0,0 +1,565 @@
+// Package storagetest is the conformance suite every storage backend has to pass. It only adds records of its
+// own and tolerates existing ones, so it can also be run against a development database.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/controller/internal/domain"
+	"github.com/kostyay/otel-demo/controller/internal/storage"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"google.golang.org/protobuf/proto"
+)
+
+// missingID doesn't belong to any record.
+const missingID = 1 << 31
+
+// Run checks the behaviour of s the controller relies on and returns every deviation.
+// The checks run as background work, except those checking tenant isolation.
+func Run(ctx context.Context, s storage.Storage) error {
+	ctx = tenant.NewBackgroundContext(ctx)
+	checks := []struct {
+		name  string
+		check func(ctx context.Context, s storage.Storage) error
+	}{
+		{"calculations", calculations},
+		{"history", history},
+		{"changes", changes},
+		{"cache", cache},
+		{"pipelines", pipelines},
+		{"schedules", schedules},
+		{"leases", leases},
+		{"tenants", tenants},
+	}
+
+	var errs []error
+	for _, c := range checks {
+		if err := c.check(ctx, s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func calculations(ctx context.Context, s storage.Storage) error {
+	// 2^53+1 doesn't survive a round trip through a double.
+	variables := domain.Variables{"x": {Kind: &pb.Value_IntValue{IntValue: 1<<53 + 1}}}
+	first := &domain.Calculation{Owner: "storagetest", Expression: "x + 1", Variables: variables, Mode: pb.EvaluationMode_EVALUATION_MODE_FLOAT}
+	if err := s.CreateCalculation(ctx, first); err != nil {
+		return err
+	}
+	if first.ID == 0 || first.CreatedAt.IsZero() {
+		return fmt.Errorf("CreateCalculation didn't set the ID and CreatedAt")
+	}
+	second := &domain.Calculation{Owner: "storagetest", Expression: "2"}
+	if err := s.CreateCalculation(ctx, second); err != nil {
+		return err
+	}
+
+	got, err := s.GetCalculation(ctx, first.ID)
+	if err != nil {
+		return err
+	}
+	if got.Owner != first.Owner || got.Expression != first.Expression || got.Mode != first.Mode || !proto.Equal(got.Variables["x"], variables["x"]) {
+		return fmt.Errorf("GetCalculation returned %v, want %v", got.Proto(), first.Proto())
+	}
+	if got.CompletedAt != nil || got.Result != nil {
+		return fmt.Errorf("a new calculation is already completed")
+	}
+
+	all, err := s.GetCalculations(ctx)
+	if err != nil {
+		return err
+	}
+	if position(all, second.ID) < 0 || position(all, second.ID) > position(all, first.ID) {
+		return fmt.Errorf("GetCalculations doesn't return the newest calculations first")
+	}
+
+	result := &pb.Value{Kind: &pb.Value_IntValue{IntValue: 1<<53 + 2}}
+	if err := s.UpdateResult(ctx, first.ID, result); err != nil {
+		return err
+	}
+	if got, err = s.GetCalculation(ctx, first.ID); err != nil {
+		return err
+	}
+	if !proto.Equal(got.Result.Proto(), result) || got.CompletedAt == nil {
+		return fmt.Errorf("UpdateResult stored %v completed at %v, want %v", got.Result.Proto(), got.CompletedAt, result)
+	}
+	completedAt := *got.CompletedAt
+	// A redelivered result doesn't replace the first one.
+	if err := s.UpdateResult(ctx, first.ID, &pb.Value{Kind: &pb.Value_IntValue{IntValue: 3}}); err != nil {
+		return err
+	}
+	if got, err = s.GetCalculation(ctx, first.ID); err != nil {
+		return err
+	}
+	if !proto.Equal(got.Result.Proto(), result) || got.CompletedAt == nil || !got.CompletedAt.Equal(completedAt) {
+		return fmt.Errorf("a second UpdateResult stored %v completed at %v, want %v completed at %v", got.Result.Proto(), got.CompletedAt, result, completedAt)
+	}
+
+	evalErr := &pb.EvaluationError{Kind: pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_RUNTIME, Message: "division by zero", Column: 3}
+	if err := s.UpdateError(ctx, second.ID, evalErr); err != nil {
+		return err
+	}
+	if got, err = s.GetCalculation(ctx, second.ID); err != nil {
+		return err
+	}
+	if !proto.Equal(got.Error.Proto(), evalErr) || got.CompletedAt == nil {
+		return fmt.Errorf("UpdateError stored %v completed at %v, want %v", got.Error.Proto(), got.CompletedAt, evalErr)
+	}
+
+	var storageErr *storage.Error
+	_, err = s.GetCalculation(ctx, missingID)
+	if !errors.As(err, &storageErr) || storageErr.Kind != storage.ErrNotFound || storageErr.Resource != "calculation" || storageErr.ID != missingID {
+		return fmt.Errorf("GetCalculation of a missing calculation returned %v, want storage.ErrNotFound for calculation %d", err, missingID)
+	}
+	err = s.UpdateResult(ctx, missingID, result)
+	if !errors.As(err, &storageErr) || storageErr.Kind != storage.ErrNotFound || storageErr.Resource != "calculation" || storageErr.ID != missingID {
+		return fmt.Errorf("UpdateResult of a missing calculation returned %v, want storage.ErrNotFound for calculation %d", err, missingID)
+	}
+	if err := s.UpdateError(ctx, missingID, evalErr); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("UpdateError of a missing calculation returned %v, want storage.ErrNotFound", err)
+	}
+	return nil
+}
+
+func history(ctx context.Context, s storage.Storage) error {
+	ctx = domain.WithActor(ctx, "storagetest")
+	calculation := &domain.Calculation{Owner: "storagetest", Expression: "1 / 0"}
+	if err := s.CreateCalculation(ctx, calculation); err != nil {
+		return err
+	}
+	if err := s.RecordEvent(ctx, calculation.ID, pb.CalculationEventKind_CALCULATION_EVENT_KIND_DISPATCHED, "storagetest"); err != nil {
+		return err
+	}
+	evalErr := &pb.EvaluationError{Kind: pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_RUNTIME, Message: "division by zero"}
+	if err := s.UpdateError(ctx, calculation.ID, evalErr); err != nil {
+		return err
+	}
+	err := checkHistory(ctx, s, calculation.ID,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_CREATED,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_DISPATCHED,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_FAILED,
+	)
+	if err != nil {
+		return err
+	}
+	// A redelivered outcome records no event.
+	if err := s.UpdateError(ctx, calculation.ID, evalErr); err != nil {
+		return err
+	}
+	err = checkHistory(ctx, s, calculation.ID,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_CREATED,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_DISPATCHED,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_FAILED,
+	)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cached := &domain.Calculation{Owner: "storagetest", Expression: "2", Result: domain.NewResult(&pb.Value{Kind: &pb.Value_IntValue{IntValue: 2}}), CompletedAt: &now, ServedFromCache: true}
+	if err := s.CreateCalculation(ctx, cached); err != nil {
+		return err
+	}
+	err = checkHistory(ctx, s, cached.ID,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_CREATED,
+		pb.CalculationEventKind_CALCULATION_EVENT_KIND_RESULT_RECEIVED,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := s.UpdateResult(ctx, missingID, &pb.Value{}); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("UpdateResult of a missing calculation returned %v, want storage.ErrNotFound", err)
+	}
+	if _, err := s.GetHistory(ctx, missingID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetHistory of a missing calculation returned %v, want storage.ErrNotFound", err)
+	}
+	return nil
+}
+
+func changes(ctx context.Context, s storage.Storage) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	feed := s.Subscribe(ctx)
+
+	calculation := &domain.Calculation{Owner: "storagetest", Expression: "1"}
+	if err := s.CreateCalculation(ctx, calculation); err != nil {
+		return err
+	}
+	for change := range feed {
+		if change.Event == nil {
+			// Changes may have been missed while the feed connected, make another one.
+			calculation = &domain.Calculation{Owner: "storagetest", Expression: "1"}
+			if err := s.CreateCalculation(ctx, calculation); err != nil {
+				return err
+			}
+			continue
+		}
+		if change.Event.CalculationID != calculation.ID {
+			continue
+		}
+		if change.Event.Kind != pb.CalculationEventKind_CALCULATION_EVENT_KIND_CREATED || !change.SpanContext.IsRemote() {
+			return fmt.Errorf("Subscribe delivered %v, want the creation of calculation %d", change.Event.Proto(), calculation.ID)
+		}
+		return nil
+	}
+	return fmt.Errorf("Subscribe didn't deliver the creation of calculation %d", calculation.ID)
+}
+
+// checkHistory checks the history of a calculation has events of kinds, in order, caused by the actor of ctx.
+func checkHistory(ctx context.Context, s storage.Storage, calculationID uint, kinds ...pb.CalculationEventKind) error {
+	events, err := s.GetHistory(ctx, calculationID)
+	if err != nil {
+		return err
+	}
+	if len(events) != len(kinds) {
+		return fmt.Errorf("GetHistory returned %d events for calculation %d, want %v", len(events), calculationID, kinds)
+	}
+	for i, event := range events {
+		if event.Kind != kinds[i] || event.CalculationID != calculationID || event.Actor != domain.ActorFromContext(ctx) || event.CreatedAt.IsZero() {
+			return fmt.Errorf("GetHistory returned %v as event %d, want a %s event by %q", event.Proto(), i, kinds[i], domain.ActorFromContext(ctx))
+		}
+	}
+	return nil
+}
+
+func position(calculations []*domain.Calculation, id uint) int {
+	for i, calculation := range calculations {
+		if calculation.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func cache(ctx context.Context, s storage.Storage) error {
+	key := fmt.Sprintf("storagetest-%d", time.Now().UnixNano())
+	result := &pb.Value{Kind: &pb.Value_DoubleValue{DoubleValue: 1.5}}
+	if err := s.PutCachedResult(ctx, &domain.CacheEntry{Key: key, Result: domain.NewResult(result), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		return err
+	}
+	entry, err := s.GetCachedResult(ctx, key)
+	if err != nil {
+		return err
+	}
+	if entry == nil || !proto.Equal(entry.Result.Proto(), result) {
+		return fmt.Errorf("GetCachedResult returned %v, want %v", entry, result)
+	}
+
+	replaced := &pb.Value{Kind: &pb.Value_StringValue{StringValue: "replaced"}}
+	if err := s.PutCachedResult(ctx, &domain.CacheEntry{Key: key, Result: domain.NewResult(replaced), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		return err
+	}
+	if entry, err = s.GetCachedResult(ctx, key); err != nil {
+		return err
+	}
+	if entry == nil || !proto.Equal(entry.Result.Proto(), replaced) {
+		return fmt.Errorf("PutCachedResult didn't replace the existing entry")
+	}
+
+	expired := key + "-expired"
+	if err := s.PutCachedResult(ctx, &domain.CacheEntry{Key: expired, Result: domain.NewResult(result), ExpiresAt: time.Now().Add(-time.Hour)}); err != nil {
+		return err
+	}
+	for _, key := range []string{expired, key + "-missing"} {
+		if entry, err := s.GetCachedResult(ctx, key); err != nil || entry != nil {
+			return fmt.Errorf("GetCachedResult(%q) returned %v, %v, want nothing", key, entry, err)
+		}
+	}
+	return nil
+}
+
+func pipelines(ctx context.Context, s storage.Storage) error {
+	pipeline := &domain.Pipeline{
+		Owner:       "storagetest",
+		Variables:   domain.Variables{"x": {Kind: &pb.Value_IntValue{IntValue: 2}}},
+		TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		Mode:        pb.EvaluationMode_EVALUATION_MODE_UNITS,
+		Steps: []*domain.PipelineStep{
+			{Position: 0, Name: "a", Expression: "x + 1", OutputUnit: "km"},
+			{Position: 1, Name: "b", Expression: "a * 2", DependsOn: domain.StepNames{"a"}},
+		},
+	}
+	if err := s.CreatePipeline(ctx, pipeline); err != nil {
+		return err
+	}
+	if pipeline.ID == 0 || pipeline.Steps[0].ID == 0 || pipeline.Steps[0].PipelineID != pipeline.ID {
+		return fmt.Errorf("CreatePipeline didn't set the IDs of the pipeline and its steps")
+	}
+
+	got, err := s.GetPipeline(ctx, pipeline.ID)
+	if err != nil {
+		return err
+	}
+	if got.TraceParent != pipeline.TraceParent || got.Mode != pipeline.Mode || len(got.Steps) != 2 || got.Steps[0].Name != "a" || got.Steps[0].OutputUnit != "km" || got.Steps[1].Name != "b" {
+		return fmt.Errorf("GetPipeline returned %v, want %v", got.Proto(), pipeline.Proto())
+	}
+	if len(got.Steps[1].DependsOn) != 1 || got.Steps[1].DependsOn[0] != "a" || got.Steps[0].Calculation != nil {
+		return fmt.Errorf("GetPipeline returned the steps %v", got.Proto().GetSteps())
+	}
+
+	if step, err := s.GetPipelineStep(ctx, missingID); err != nil || step != nil {
+		return fmt.Errorf("GetPipelineStep of a calculation outside of a pipeline returned %v, %v", step, err)
+	}
+
+	calculation := &domain.Calculation{Owner: "storagetest", Expression: "x + 1", Variables: pipeline.Variables}
+	claimed, err := s.ClaimPipelineStep(ctx, pipeline.Steps[0].ID, calculation)
+	if err != nil {
+		return err
+	}
+	if !claimed || calculation.ID == 0 {
+		return fmt.Errorf("ClaimPipelineStep didn't claim an undispatched step")
+	}
+	if claimed, err = s.ClaimPipelineStep(ctx, pipeline.Steps[0].ID, &domain.Calculation{Owner: "storagetest", Expression: "x + 1"}); err != nil || claimed {
+		return fmt.Errorf("ClaimPipelineStep claimed a dispatched step again: %v, %v", claimed, err)
+	}
+
+	step, err := s.GetPipelineStep(ctx, calculation.ID)
+	if err != nil {
+		return err
+	}
+	if step == nil || step.ID != pipeline.Steps[0].ID {
+		return fmt.Errorf("GetPipelineStep returned %v, want step a", step)
+	}
+	if got, err = s.GetPipeline(ctx, pipeline.ID); err != nil {
+		return err
+	}
+	if got.Steps[0].Calculation == nil || got.Steps[0].Calculation.ID != calculation.ID {
+		return fmt.Errorf("GetPipeline doesn't return the calculations of dispatched steps")
+	}
+
+	if err := s.CompletePipeline(ctx, pipeline.ID, true); err != nil {
+		return err
+	}
+	if err := s.CompletePipeline(ctx, pipeline.ID, false); err != nil {
+		return err
+	}
+	if got, err = s.GetPipeline(ctx, pipeline.ID); err != nil {
+		return err
+	}
+	if !got.Failed || got.CompletedAt == nil {
+		return fmt.Errorf("CompletePipeline changed a completed pipeline")
+	}
+
+	if _, err := s.GetPipeline(ctx, missingID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetPipeline of a missing pipeline returned %v, want storage.ErrNotFound", err)
+	}
+	return nil
+}
+
+func schedules(ctx context.Context, s storage.Storage) error {
+	past := time.Now().Add(-time.Minute)
+	schedule := &domain.Schedule{Owner: "storagetest", Expression: "1 + 1", Cron: "0 * * * *", NextRunAt: &past}
+	if err := s.CreateSchedule(ctx, schedule); err != nil {
+		return err
+	}
+	if schedule.ID == 0 {
+		return fmt.Errorf("CreateSchedule didn't set the ID")
+	}
+
+	got, err := s.GetSchedule(ctx, schedule.ID)
+	if err != nil {
+		return err
+	}
+	if got.Cron != schedule.Cron || got.Expression != schedule.Expression || !sameTime(got.NextRunAt, &past) {
+		return fmt.Errorf("GetSchedule returned %v, want %v", got.Proto(), schedule.Proto())
+	}
+	if all, err := s.GetSchedules(ctx); err != nil || !containsSchedule(all, schedule.ID) {
+		return fmt.Errorf("GetSchedules doesn't return the schedule: %v", err)
+	}
+	if due, err := s.DueSchedules(ctx, time.Now(), 1000); err != nil || !containsSchedule(due, schedule.ID) {
+		return fmt.Errorf("DueSchedules doesn't return a due schedule: %v", err)
+	}
+
+	got.Paused = true
+	if err := s.UpdateSchedule(ctx, got); err != nil {
+		return err
+	}
+	if due, err := s.DueSchedules(ctx, time.Now(), 1000); err != nil || containsSchedule(due, schedule.ID) {
+		return fmt.Errorf("DueSchedules returns a paused schedule: %v", err)
+	}
+	got.Paused = false
+	if err := s.UpdateSchedule(ctx, got); err != nil {
+		return err
+	}
+
+	due, err := s.GetSchedule(ctx, schedule.ID)
+	if err != nil {
+		return err
+	}
+	next := time.Now().Add(time.Hour)
+	calculation := due.NewCalculation()
+	recorded, err := s.RecordScheduleRun(ctx, due, calculation, &next)
+	if err != nil {
+		return err
+	}
+	if !recorded || calculation.ID == 0 || calculation.ScheduleID == nil || *calculation.ScheduleID != schedule.ID {
+		return fmt.Errorf("RecordScheduleRun didn't record the run")
+	}
+	if recorded, err = s.RecordScheduleRun(ctx, due, due.NewCalculation(), &next); err != nil || recorded {
+		return fmt.Errorf("RecordScheduleRun recorded the same run twice: %v, %v", recorded, err)
+	}
+	if got, err = s.GetSchedule(ctx, schedule.ID); err != nil {
+		return err
+	}
+	if got.LastCalculationID == nil || *got.LastCalculationID != calculation.ID || got.LastRunAt == nil || !sameTime(got.NextRunAt, &next) {
+		return fmt.Errorf("RecordScheduleRun left the schedule at %v", got.Proto())
+	}
+
+	// Updates keep the run history and bump the update time.
+	if got, err = s.GetSchedule(ctx, schedule.ID); err != nil {
+		return err
+	}
+	updatedAt := got.UpdatedAt
+	time.Sleep(10 * time.Millisecond)
+	got.Expression = "2 + 2"
+	if err := s.UpdateSchedule(ctx, got); err != nil {
+		return err
+	}
+	if got, err = s.GetSchedule(ctx, schedule.ID); err != nil {
+		return err
+	}
+	if got.Expression != "2 + 2" || got.LastCalculationID == nil || !got.UpdatedAt.After(updatedAt) {
+		return fmt.Errorf("UpdateSchedule stored %v", got.Proto())
+	}
+
+	if err := s.DeleteSchedule(ctx, schedule.ID); err != nil {
+		return err
+	}
+	if _, err := s.GetSchedule(ctx, schedule.ID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetSchedule of a deleted schedule returned %v, want storage.ErrNotFound", err)
+	}
+	if err := s.DeleteSchedule(ctx, schedule.ID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("DeleteSchedule of a deleted schedule returned %v, want storage.ErrNotFound", err)
+	}
+	if err := s.UpdateSchedule(ctx, got); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("UpdateSchedule of a deleted schedule returned %v, want storage.ErrNotFound", err)
+	}
+	return nil
+}
+
+func tenants(ctx context.Context, s storage.Storage) error {
+	owner, other := tenant.NewContext(ctx, "storagetest-owner"), tenant.NewContext(ctx, "storagetest-other")
+	since := time.Now().Add(-time.Hour)
+
+	calculation := &domain.Calculation{Owner: "storagetest", Expression: "1 + 1"}
+	if err := s.CreateCalculation(owner, calculation); err != nil {
+		return err
+	}
+	if calculation.Owner != "storagetest-owner" {
+		return fmt.Errorf("CreateCalculation made %q own the calculation of a tenant", calculation.Owner)
+	}
+	if _, err := s.GetCalculation(other, calculation.ID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetCalculation of another tenant's calculation returned %v, want storage.ErrNotFound", err)
+	}
+	if _, err := s.GetHistory(other, calculation.ID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetHistory of another tenant's calculation returned %v, want storage.ErrNotFound", err)
+	}
+	if all, err := s.GetCalculations(other); err != nil || position(all, calculation.ID) >= 0 {
+		return fmt.Errorf("GetCalculations returns another tenant's calculation: %v", err)
+	}
+	if all, err := s.GetCalculations(owner); err != nil || position(all, calculation.ID) < 0 {
+		return fmt.Errorf("GetCalculations doesn't return the tenant's calculation: %v", err)
+	}
+	if all, err := s.GetCalculations(ctx); err != nil || position(all, calculation.ID) < 0 {
+		return fmt.Errorf("GetCalculations without a tenant doesn't return every calculation: %v", err)
+	}
+
+	pending, err := s.PendingCalculations(owner, since)
+	if err != nil {
+		return err
+	}
+	if pending < 1 {
+		return fmt.Errorf("PendingCalculations returned %d, want at least 1", pending)
+	}
+	if err := s.UpdateResult(other, calculation.ID, &pb.Value{Kind: &pb.Value_IntValue{IntValue: 2}}); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("UpdateResult of another tenant's calculation returned %v, want storage.ErrNotFound", err)
+	}
+	if got, err := s.GetCalculation(owner, calculation.ID); err != nil || got.CompletedAt != nil {
+		return fmt.Errorf("UpdateResult completed another tenant's calculation: %v", err)
+	}
+	if err := s.UpdateResult(owner, calculation.ID, &pb.Value{Kind: &pb.Value_IntValue{IntValue: 2}}); err != nil {
+		return err
+	}
+	if got, err := s.PendingCalculations(owner, since); err != nil || got != pending-1 {
+		return fmt.Errorf("PendingCalculations returned %d after a calculation completed, want %d: %v", got, pending-1, err)
+	}
+
+	pipeline := &domain.Pipeline{Steps: []*domain.PipelineStep{{Position: 0, Name: "a", Expression: "1"}}}
+	if err := s.CreatePipeline(owner, pipeline); err != nil {
+		return err
+	}
+	if _, err := s.GetPipeline(other, pipeline.ID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetPipeline of another tenant's pipeline returned %v, want storage.ErrNotFound", err)
+	}
+	if got, err := s.GetPipeline(owner, pipeline.ID); err != nil || got.Owner != "storagetest-owner" {
+		return fmt.Errorf("GetPipeline of the tenant's pipeline returned %v, %v", got, err)
+	}
+
+	runAt := time.Now().Add(time.Hour)
+	schedule := &domain.Schedule{Expression: "1 + 1", RunAt: &runAt, NextRunAt: &runAt}
+	if err := s.CreateSchedule(owner, schedule); err != nil {
+		return err
+	}
+	if _, err := s.GetSchedule(other, schedule.ID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("GetSchedule of another tenant's schedule returned %v, want storage.ErrNotFound", err)
+	}
+	if all, err := s.GetSchedules(other); err != nil || containsSchedule(all, schedule.ID) {
+		return fmt.Errorf("GetSchedules returns another tenant's schedule: %v", err)
+	}
+	if err := s.UpdateSchedule(other, schedule); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("UpdateSchedule of another tenant's schedule returned %v, want storage.ErrNotFound", err)
+	}
+	if err := s.DeleteSchedule(other, schedule.ID); !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("DeleteSchedule of another tenant's schedule returned %v, want storage.ErrNotFound", err)
+	}
+	return s.DeleteSchedule(owner, schedule.ID)
+}
+
+func containsSchedule(schedules []*domain.Schedule, id uint) bool {
+	for _, schedule := range schedules {
+		if schedule.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sameTime compares timestamps at the microsecond precision databases store.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Sub(*b).Abs() < time.Microsecond
+}
+
+func leases(ctx context.Context, s storage.Storage) error {
+	name := fmt.Sprintf("storagetest-%d", time.Now().UnixNano())
+	steps := []struct {
+		name, holder string
+		ttl          time.Duration
+		want         bool
+	}{
+		{name, "a", time.Hour, true},
+		{name, "b", time.Hour, false},
+		{name, "a", time.Hour, true},
+		{name + "-expired", "a", -time.Second, true},
+		{name + "-expired", "b", time.Hour, true},
+	}
+	for _, step := range steps {
+		acquired, err := s.AcquireLease(ctx, step.name, step.holder, step.ttl)
+		if err != nil {
+			return err
+		}
+		if acquired != step.want {
+			return fmt.Errorf("AcquireLease(%q, %q) returned %t, want %t", step.name, step.holder, acquired, step.want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"github.com/kostyay/otel-demo/controller/internal/tenant"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// tenantSetting is the postgres setting the row level security policies compare owners with.
+	tenantSetting = "app.tenant"
+	// backgroundRole is the postgres role the row level security policies show every record to, it's created by
+	// Migrator.RowLevelSecurity.
+	backgroundRole = "calculator_background"
+)
+
+// tenantPlugin scopes the statements run for a tenant to the records it owns: the queries, updates and deletes
+// of models with an owner are filtered by it, and the records it creates are owned by it. Background work runs
+// without a tenant and sees every record.
+//
+// With DB_ROW_LEVEL_SECURITY the postgres policies enforce the same, in the transaction of every statement the
+// plugin sets the tenant or switches to the background role. Row and raw statements aren't scoped, the storage
+// only runs raw statements to migrate and to set up the transaction, and under the policies an unscoped
+// statement sees no records at all.
+type tenantPlugin struct {
+	// rowLevelSecurity sets up the transaction of every statement for the postgres policies.
+	rowLevelSecurity bool
+}
+
+// scope isolates the tenants of db, see tenantPlugin.
+func scope(db *gorm.DB, cfg *config.Options) error {
+	if err := db.Use(&tenantPlugin{rowLevelSecurity: cfg.DB.RowLevelSecurity}); err != nil {
+		return fmt.Errorf("unable to use tenant plugin: %w", err)
+	}
+	return nil
+}
+
+func (p *tenantPlugin) Name() string {
+	return "tenant"
+}
+
+func (p *tenantPlugin) Initialize(db *gorm.DB) error {
+	// Creates, updates and deletes already run in a transaction, queries get one to scope the setting to.
+	cb := db.Callback()
+	registrations := []struct {
+		name     string
+		register func(name string, fn func(*gorm.DB)) error
+		fn       func(*gorm.DB)
+	}{
+		{"before_create", cb.Create().Before("gorm:create").Register, p.beforeCreate},
+		{"before_query", cb.Query().Before("gorm:query").Register, p.beforeQuery},
+		{"after_query", cb.Query().After("gorm:after_query").Register, p.afterQuery},
+		{"before_update", cb.Update().Before("gorm:update").Register, p.beforeChange},
+		{"before_delete", cb.Delete().Before("gorm:delete").Register, p.beforeChange},
+		{"before_row", cb.Row().Before("gorm:row").Register, filterByOwner},
+	}
+
+	for _, r := range registrations {
+		if err := r.register("tenant:"+r.name, r.fn); err != nil {
+			return fmt.Errorf("unable to register %s callback: %w", r.name, err)
+		}
+	}
+	return nil
+}
+
+func (p *tenantPlugin) beforeCreate(db *gorm.DB) {
+	if id, field := tenantField(db); field != nil {
+		switch value := db.Statement.ReflectValue; value.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < value.Len(); i++ {
+				setOwner(db, field, reflect.Indirect(value.Index(i)), id)
+			}
+		case reflect.Struct:
+			setOwner(db, field, value, id)
+		}
+	}
+	p.setAccess(db)
+}
+
+func (p *tenantPlugin) beforeQuery(db *gorm.DB) {
+	ctx := db.Statement.Context
+	if p.rowLevelSecurity && (tenant.FromContext(ctx) != "" || tenant.IsBackground(ctx)) {
+		callbacks.BeginTransaction(db)
+	}
+	filterByOwner(db)
+	p.setAccess(db)
+}
+
+func (p *tenantPlugin) afterQuery(db *gorm.DB) {
+	if p.rowLevelSecurity {
+		callbacks.CommitOrRollbackTransaction(db)
+	}
+}
+
+func (p *tenantPlugin) beforeChange(db *gorm.DB) {
+	filterByOwner(db)
+	p.setAccess(db)
+}
+
+// setAccess sets the tenant of the statement, or switches background work to the background role, for the
+// rest of its transaction. Statements with neither see no records.
+func (p *tenantPlugin) setAccess(db *gorm.DB) {
+	if !p.rowLevelSecurity || db.Error != nil {
+		return
+	}
+
+	ctx := db.Statement.Context
+	if id := tenant.FromContext(ctx); id != "" {
+		if _, err := db.Statement.ConnPool.ExecContext(ctx, "SELECT set_config($1, $2, true)", tenantSetting, id); err != nil {
+			_ = db.AddError(fmt.Errorf("unable to set tenant: %w", err))
+		}
+		return
+	}
+	if tenant.IsBackground(ctx) {
+		if _, err := db.Statement.ConnPool.ExecContext(ctx, "SET LOCAL ROLE "+backgroundRole); err != nil {
+			_ = db.AddError(fmt.Errorf("unable to set background role: %w", err))
+		}
+	}
+}
+
+// filterByOwner restricts the statement to the records owned by its tenant.
+func filterByOwner(db *gorm.DB) {
+	id, field := tenantField(db)
+	if field == nil {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id},
+	}})
+}
+
+// tenantField returns the tenant of the statement and the owner field of its model, or a nil field if either
+// is missing.
+func tenantField(db *gorm.DB) (string, *schema.Field) {
+	id := tenant.FromContext(db.Statement.Context)
+	if id == "" || db.Error != nil || db.Statement.Schema == nil {
+		return "", nil
+	}
+	return id, db.Statement.Schema.LookUpField("Owner")
+}
+
+func setOwner(db *gorm.DB, field *schema.Field, value reflect.Value, id string) {
+	if err := field.Set(db.Statement.Context, value, id); err != nil {
+		_ = db.AddError(fmt.Errorf("unable to set owner: %w", err))
+	}
+}
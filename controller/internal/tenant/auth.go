@@ -0,0 +1,127 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	connect_go "github.com/bufbuild/connect-go"
+	"github.com/kostyay/otel-demo/controller/internal/config"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/idtoken"
+)
+
+// iapAccountPrefix prefixes the identities Identity-Aware Proxy puts in its headers.
+const iapAccountPrefix = "accounts.google.com:"
+
+// Authenticator returns the tenant a request is made by from its headers.
+type Authenticator interface {
+	Authenticate(ctx context.Context, header http.Header) (string, error)
+}
+
+// NewAuthenticator returns the Authenticator selected by AUTH_MODE, or nil if tenants aren't authenticated.
+func NewAuthenticator(ctx context.Context, cfg *config.Options) (Authenticator, error) {
+	switch cfg.Auth.Mode {
+	case config.AuthModeHeader:
+		return &headerAuthenticator{header: cfg.Auth.Header}, nil
+	case config.AuthModeIDToken:
+		validator, err := idtoken.NewValidator(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create ID token validator: %w", err)
+		}
+		return &idTokenAuthenticator{validator: validator, audience: cfg.Auth.Audience}, nil
+	}
+	return nil, nil
+}
+
+// headerAuthenticator trusts the identity a proxy in front of the controller, like Identity-Aware Proxy, puts in
+// a header. The controller must not be reachable without going through the proxy.
+type headerAuthenticator struct {
+	header string
+}
+
+func (a *headerAuthenticator) Authenticate(ctx context.Context, header http.Header) (string, error) {
+	id := strings.TrimPrefix(header.Get(a.header), iapAccountPrefix)
+	if id == "" {
+		return "", fmt.Errorf("%s header is missing", a.header)
+	}
+	return id, nil
+}
+
+// idTokenAuthenticator verifies the Google-signed ID token of the Authorization header. The tenant is the email
+// of the token, or its subject for tokens without one.
+type idTokenAuthenticator struct {
+	validator *idtoken.Validator
+	audience  string
+}
+
+func (a *idTokenAuthenticator) Authenticate(ctx context.Context, header http.Header) (string, error) {
+	token, ok := strings.CutPrefix(header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", errors.New("bearer token is missing")
+	}
+	payload, err := a.validator.Validate(ctx, token, a.audience)
+	if err != nil {
+		return "", fmt.Errorf("unable to validate ID token: %w", err)
+	}
+	if email, _ := payload.Claims["email"].(string); email != "" {
+		return email, nil
+	}
+	return payload.Subject, nil
+}
+
+// interceptor serves every request for the tenant authenticated by auth, rejecting the requests it can't
+// authenticate. Without an Authenticator requests are served without a tenant.
+type interceptor struct {
+	auth Authenticator
+}
+
+// NewInterceptor returns an interceptor adding the tenant authenticated by auth, which may be nil, to the
+// context of every request. It must run after the otel interceptor, so the tenant is set on the request span.
+func NewInterceptor(auth Authenticator) connect_go.Interceptor {
+	return &interceptor{auth: auth}
+}
+
+func (i *interceptor) WrapUnary(next connect_go.UnaryFunc) connect_go.UnaryFunc {
+	return func(ctx context.Context, req connect_go.AnyRequest) (connect_go.AnyResponse, error) {
+		ctx, err := i.authenticate(ctx, req.Header())
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+func (i *interceptor) WrapStreamingClient(next connect_go.StreamingClientFunc) connect_go.StreamingClientFunc {
+	return next
+}
+
+func (i *interceptor) WrapStreamingHandler(next connect_go.StreamingHandlerFunc) connect_go.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect_go.StreamingHandlerConn) error {
+		ctx, err := i.authenticate(ctx, conn.RequestHeader())
+		if err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+func (i *interceptor) authenticate(ctx context.Context, header http.Header) (context.Context, error) {
+	ctx = withoutBaggage(ctx)
+	if i.auth == nil {
+		// Without authentication requests share every record.
+		return NewBackgroundContext(ctx), nil
+	}
+
+	id, err := i.auth.Authenticate(ctx, header)
+	if err != nil {
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "unauthenticated")
+		return nil, connect_go.NewError(connect_go.CodeUnauthenticated, errors.New("request is not authenticated"))
+	}
+	return NewContext(ctx, id), nil
+}
@@ -0,0 +1,72 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pendingWindow is how long a calculation counts against the pending quota, calculations pending for longer
+// are assumed lost.
+const pendingWindow = time.Hour
+
+// QuotaLimit names the quota in the EvaluationError of calculations that couldn't run because of it.
+const QuotaLimit = "max_pending_calculations"
+
+type QuotaStorage interface {
+	PendingCalculations(ctx context.Context, since time.Time) (int, error)
+}
+
+// Quota limits how many calculations a tenant can have pending. Every calculation is checked before it is
+// created, whether a client submits it or a schedule or pipeline creates it on behalf of the tenant.
+type Quota struct {
+	storage QuotaStorage
+	// max is the most calculations a tenant can have pending, zero disables the quota.
+	max int
+}
+
+func NewQuota(storage QuotaStorage, max int) *Quota {
+	return &Quota{storage: storage, max: max}
+}
+
+// QuotaError is returned when creating calculations would exceed the quota of a tenant.
+type QuotaError struct {
+	Tenant string
+	Max    int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %q would have more than %d pending calculations", e.Tenant, e.Max)
+}
+
+// EvaluationError is the error of a calculation that was recorded but not run because of e.
+func (e *QuotaError) EvaluationError() *pb.EvaluationError {
+	return &pb.EvaluationError{
+		Kind:    pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_LIMIT_EXCEEDED,
+		Message: e.Error(),
+		Limit:   QuotaLimit,
+	}
+}
+
+// Check returns a *QuotaError if the tenant of ctx would have more than its maximum of pending calculations
+// once count more are created. The quota is soft, concurrent checks can exceed it slightly.
+func (q *Quota) Check(ctx context.Context, count int) error {
+	id := FromContext(ctx)
+	if id == "" || q.max <= 0 {
+		return nil
+	}
+
+	pending, err := q.storage.PendingCalculations(ctx, time.Now().Add(-pendingWindow))
+	if err != nil {
+		return err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("tenant.pending_calculations", pending))
+	if pending+count <= q.max {
+		return nil
+	}
+	return &QuotaError{Tenant: id, Max: q.max}
+}
@@ -0,0 +1,68 @@
+// Package tenant carries the tenant a request is served for in its context. Everything the request reads and
+// writes is owned by the tenant, the storage filters its queries by it.
+package tenant
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Key is the baggage member and span attribute holding the tenant.
+const Key = "tenant.id"
+
+type contextKey struct{}
+
+type backgroundKey struct{}
+
+// NewContext returns a copy of ctx served for tenant id, or ctx itself if id is empty. The tenant is set on the
+// span of ctx and in its baggage, replacing whatever the client sent, so it reaches the math function too.
+func NewContext(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(Key, id))
+	if member, err := baggage.NewMember(Key, url.PathEscape(id)); err == nil {
+		if bag, err := baggage.FromContext(ctx).SetMember(member); err == nil {
+			ctx = baggage.ContextWithBaggage(ctx, bag)
+		}
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant of ctx, or an empty string when tenants aren't authenticated.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// NewBackgroundContext returns a copy of ctx for work done on behalf of every tenant, such as the scheduler or
+// storing results. Postgres only shows every record to such work, statements without a tenant see nothing.
+// A tenant set on the context later takes precedence.
+func NewBackgroundContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// IsBackground reports whether ctx is for work done on behalf of every tenant.
+func IsBackground(ctx context.Context) bool {
+	background, _ := ctx.Value(backgroundKey{}).(bool)
+	return background
+}
+
+// Owns reports whether the tenant of ctx may see records of owner. Without a tenant every record is visible.
+func Owns(ctx context.Context, owner string) bool {
+	id := FromContext(ctx)
+	return id == "" || id == owner
+}
+
+// withoutBaggage returns ctx without the tenant a client claims in its baggage, which is never trusted.
+func withoutBaggage(ctx context.Context) context.Context {
+	bag := baggage.FromContext(ctx)
+	if bag.Member(Key).Key() == "" {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, bag.DeleteMember(Key))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/caarlos0/env/v8"
+	"github.com/kostyay/otel-demo/common/log"
+	"github.com/kostyay/otel-demo/common/otel"
+	otelpubsub "github.com/kostyay/otel-demo/common/otel/pubsub"
+	"github.com/kostyay/otel-demo/common/version"
+	"github.com/kostyay/otel-demo/functions/math/internal/processor"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type options struct {
+	GoogleCloudProject      string        `env:"GOOGLE_CLOUD_PROJECT,required"`
+	MathRequestSubscription string        `env:"MATH_REQUEST_SUBSCRIPTION,required"`
+	MathResultTopic         string        `env:"MATH_RESULT_TOPIC" envDefault:"math-result-topic"`
+	MaxOutstandingMessages  int           `env:"MAX_OUTSTANDING_MESSAGES" envDefault:"10"`
+	NumGoroutines           int           `env:"NUM_GOROUTINES" envDefault:"1"`
+	ListenAddr              string        `env:"LISTEN_ADDR" envDefault:"0.0.0.0:8080"`
+	ShutdownTimeout         time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	Publish                 processor.PublishOptions
+	Limits                  processor.LimitOptions
+}
+
+type worker struct {
+	subscription *pubsub.Subscription
+	publisher    *processor.Publisher
+	limits       processor.LimitOptions
+	ready        atomic.Bool
+}
+
+// handleMessage processes a single math request. The work is detached from the receive context so
+// in-flight calculations are allowed to finish while the subscription drains.
+func (w *worker) handleMessage(ctx context.Context, msg *pubsub.Message) {
+	span := trace.SpanFromContext(ctx)
+	ctx = trace.ContextWithSpan(context.Background(), span)
+
+	err := processor.Process(ctx, msg.Data, w.publisher, w.limits)
+	if err == nil {
+		msg.Ack()
+		return
+	}
+
+	log.WithContext(ctx).WithError(err).Error("Failed to process message")
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	if errors.Is(err, processor.ErrInvalidRequest) {
+		msg.Ack()
+		return
+	}
+	msg.Nack()
+}
+
+func (w *worker) healthz(rw http.ResponseWriter, _ *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (w *worker) readyz(rw http.ResponseWriter, _ *http.Request) {
+	if !w.ready.Load() {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// serve receives math requests until the worker is told to stop, then drains the in-flight ones.
+func serve(ctx context.Context, cfg *options) error {
+	client, err := pubsub.NewClient(ctx, cfg.GoogleCloudProject)
+	if err != nil {
+		return fmt.Errorf("unable to create pubsub client: %w", err)
+	}
+	defer client.Close()
+
+	publisher, err := processor.NewPublisher(client.Topic(cfg.MathResultTopic), cfg.Publish)
+	if err != nil {
+		return fmt.Errorf("unable to create result publisher: %w", err)
+	}
+	defer publisher.Stop()
+
+	w := &worker{
+		subscription: client.Subscription(cfg.MathRequestSubscription),
+		publisher:    publisher,
+		limits:       cfg.Limits,
+	}
+	w.subscription.ReceiveSettings.MaxOutstandingMessages = cfg.MaxOutstandingMessages
+	w.subscription.ReceiveSettings.NumGoroutines = cfg.NumGoroutines
+
+	exists, err := w.subscription.Exists(ctx)
+	if !exists || err != nil {
+		return fmt.Errorf("unable to check request subscription existence: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", w.healthz)
+	mux.HandleFunc("/readyz", w.readyz)
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("health server failed")
+		}
+	}()
+
+	receiveCtx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	received := make(chan error, 1)
+	go func() {
+		received <- w.subscription.Receive(receiveCtx, otelpubsub.WrapPubSubHandlerWithTelemetry(otel.Tracer(), cfg.MathRequestSubscription, w.handleMessage))
+	}()
+	w.ready.Store(true)
+	log.Info("worker receiving messages")
+
+	select {
+	case err = <-received:
+	case <-receiveCtx.Done():
+		// Receive stops pulling once its context is done and returns after outstanding messages are handled.
+		w.ready.Store(false)
+		log.Info("draining in-flight messages")
+		select {
+		case err = <-received:
+		case <-time.After(cfg.ShutdownTimeout):
+			err = errors.New("timed out draining in-flight messages")
+		}
+	}
+	w.ready.Store(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("failed to shutdown health server")
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to receive pubsub messages: %w", err)
+	}
+	return nil
+}
+
+// run sets up telemetry around serve. It returns instead of exiting, so the telemetry is flushed by the
+// deferred shutdowns whatever happens.
+func run() error {
+	ctx := context.Background()
+	log.Info(fmt.Sprintf("Starting worker - %s;%s", version.ServiceName, version.Version))
+
+	cfg := &options{}
+	if err := env.Parse(cfg); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	tp, err := otel.InitTracing(ctx, otel.Config{
+		ProjectID:      cfg.GoogleCloudProject,
+		ServiceName:    version.ServiceName,
+		ServiceVersion: version.Version,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init trace: %w", err)
+	}
+
+	defer func() {
+		if err := tp.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("failed to shutdown trace")
+		}
+	}()
+
+	mp, err := otel.InitMetrics(ctx, otel.Config{
+		ProjectID:      cfg.GoogleCloudProject,
+		ServiceName:    version.ServiceName,
+		ServiceVersion: version.Version,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init metrics: %w", err)
+	}
+
+	defer func() {
+		if err := mp.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("failed to shutdown metrics")
+		}
+	}()
+
+	if err := serve(ctx, cfg); err != nil {
+		return fmt.Errorf("failed to run worker: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.WithError(err).Error("worker stopped")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,173 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/kostyay/otel-demo/common/calc"
+	"github.com/kostyay/otel-demo/common/log"
+	otelcommon "github.com/kostyay/otel-demo/common/otel"
+	otelpubsub "github.com/kostyay/otel-demo/common/otel/pubsub"
+	"github.com/kostyay/otel-demo/controller/api/calcpb"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ErrInvalidRequest marks failures caused by the request itself, redelivering such a message won't help.
+var ErrInvalidRequest = errors.New("invalid math request")
+
+// ResultSender delivers a finished calculation back to the controller.
+type ResultSender interface {
+	SendResult(ctx context.Context, calculation *pb.Calculation) error
+}
+
+// ResultSenderFunc adapts a plain function to a ResultSender.
+type ResultSenderFunc func(ctx context.Context, calculation *pb.Calculation) error
+
+func (f ResultSenderFunc) SendResult(ctx context.Context, calculation *pb.Calculation) error {
+	return f(ctx, calculation)
+}
+
+// Process evaluates the calculation carried by a math request message within limits and sends the result.
+// A calculation that can't be evaluated is sent back with its error instead of a result.
+// The span in ctx is expected to be the consumer span of the message.
+func Process(ctx context.Context, data []byte, sender ResultSender, limits LimitOptions) error {
+	span := trace.SpanFromContext(ctx)
+	logger := log.WithContext(ctx)
+
+	var calculation pb.Calculation
+	err := protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, &calculation)
+	if err != nil {
+		return fmt.Errorf("%w: protojson.Unmarshal: %w", ErrInvalidRequest, err)
+	}
+
+	logger.Infof("Calculation: Owner: %s; Expression: %s", calculation.GetOwner(), calculation.GetExpression())
+
+	if err := calcpb.Evaluate(ctx, &calculation, limits.limits()); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		logger.WithError(err).Error("Failed to evaluate expression")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		calculation.Error = calcpb.EvaluationError(err)
+	}
+
+	if err := sender.SendResult(ctx, &calculation); err != nil {
+		logger.WithError(err).Error("Failed to send result")
+		return err
+	}
+
+	span.AddEvent("result sent")
+
+	return nil
+}
+
+// LimitOptions bounds the resources a single evaluation may use, zero disables a limit.
+type LimitOptions struct {
+	Timeout         time.Duration `env:"MATH_EVAL_TIMEOUT" envDefault:"5s"`
+	MaxNodes        int           `env:"MATH_EVAL_MAX_NODES" envDefault:"1000"`
+	MaxDepth        int           `env:"MATH_EVAL_MAX_DEPTH" envDefault:"64"`
+	MaxStringLength int           `env:"MATH_EVAL_MAX_STRING_LENGTH" envDefault:"65536"`
+	MaxListLength   int           `env:"MATH_EVAL_MAX_LIST_LENGTH" envDefault:"10000"`
+}
+
+func (o LimitOptions) limits() calc.Limits {
+	return calc.Limits{
+		MaxDepth:        o.MaxDepth,
+		MaxNodes:        o.MaxNodes,
+		MaxStringLength: o.MaxStringLength,
+		MaxListLength:   o.MaxListLength,
+		Timeout:         o.Timeout,
+	}
+}
+
+// PublishOptions configures batching and flow control when publishing results.
+// The defaults match pubsub.DefaultPublishSettings.
+type PublishOptions struct {
+	DelayThreshold         time.Duration `env:"MATH_RESULT_PUBLISH_DELAY_THRESHOLD" envDefault:"10ms"`
+	CountThreshold         int           `env:"MATH_RESULT_PUBLISH_COUNT_THRESHOLD" envDefault:"100"`
+	ByteThreshold          int           `env:"MATH_RESULT_PUBLISH_BYTE_THRESHOLD" envDefault:"1000000"`
+	Timeout                time.Duration `env:"MATH_RESULT_PUBLISH_TIMEOUT" envDefault:"60s"`
+	MaxOutstandingMessages int           `env:"MATH_RESULT_PUBLISH_MAX_OUTSTANDING_MESSAGES" envDefault:"1000"`
+	MaxOutstandingBytes    int           `env:"MATH_RESULT_PUBLISH_MAX_OUTSTANDING_BYTES" envDefault:"-1"`
+	// LimitExceededBehavior is one of ignore, block or error.
+	LimitExceededBehavior string `env:"MATH_RESULT_PUBLISH_LIMIT_EXCEEDED_BEHAVIOR" envDefault:"ignore"`
+}
+
+// Validate checks the options can be turned into publish settings.
+func (o PublishOptions) Validate() error {
+	_, err := o.settings()
+	return err
+}
+
+func (o PublishOptions) settings() (pubsub.PublishSettings, error) {
+	settings := pubsub.DefaultPublishSettings
+	settings.DelayThreshold = o.DelayThreshold
+	settings.CountThreshold = o.CountThreshold
+	settings.ByteThreshold = o.ByteThreshold
+	settings.Timeout = o.Timeout
+	settings.FlowControlSettings.MaxOutstandingMessages = o.MaxOutstandingMessages
+	settings.FlowControlSettings.MaxOutstandingBytes = o.MaxOutstandingBytes
+
+	switch o.LimitExceededBehavior {
+	case "ignore":
+		settings.FlowControlSettings.LimitExceededBehavior = pubsub.FlowControlIgnore
+	case "block":
+		settings.FlowControlSettings.LimitExceededBehavior = pubsub.FlowControlBlock
+	case "error":
+		settings.FlowControlSettings.LimitExceededBehavior = pubsub.FlowControlSignalError
+	default:
+		return settings, fmt.Errorf("unknown flow control limit exceeded behavior %q", o.LimitExceededBehavior)
+	}
+
+	return settings, nil
+}
+
+// Publisher sends calculation results to the math result topic.
+type Publisher struct {
+	topic *pubsub.Topic
+}
+
+func NewPublisher(topic *pubsub.Topic, opts PublishOptions) (*Publisher, error) {
+	settings, err := opts.settings()
+	if err != nil {
+		return nil, err
+	}
+	topic.PublishSettings = settings
+
+	return &Publisher{topic: topic}, nil
+}
+
+// Stop flushes pending results and stops the publishing goroutines.
+func (p *Publisher) Stop() {
+	p.topic.Stop()
+}
+
+func (p *Publisher) SendResult(ctx context.Context, calculation *pb.Calculation) error {
+	respJson, err := protojson.Marshal(calculation)
+	if err != nil {
+		return fmt.Errorf("unable to marshal calculation: %w", err)
+	}
+
+	msg := &pubsub.Message{
+		Data: respJson,
+	}
+
+	// Create a new span
+	ctx, span := otelpubsub.BeforePublishMessage(ctx, otelcommon.Tracer(), p.topic.ID(), msg)
+	defer span.End()
+
+	result, err := p.topic.Publish(ctx, msg).Get(ctx)
+	otelpubsub.AfterPublishMessage(span, result, err)
+	if err != nil {
+		return fmt.Errorf("unable to publish message: %w", err)
+	}
+
+	return nil
+}
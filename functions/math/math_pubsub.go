@@ -2,23 +2,25 @@ package math
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
-	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/kostyay/otel-demo/common/log"
 	otelcommon "github.com/kostyay/otel-demo/common/otel"
-	otelpubsub "github.com/kostyay/otel-demo/common/otel/pubsub"
+	"github.com/kostyay/otel-demo/functions/math/internal/processor"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
-	"github.com/maja42/goval"
+	"github.com/caarlos0/env/v8"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.15.0"
 	"go.opentelemetry.io/otel/trace"
 
@@ -28,23 +30,102 @@ import (
 	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
 )
 
-const (
-	resultTopic = "math-result-topic"
+type options struct {
+	GoogleCloudProject string `env:"GOOGLE_CLOUD_PROJECT"`
+	MathResultTopic    string `env:"MATH_RESULT_TOPIC" envDefault:"math-result-topic"`
+	Publish            processor.PublishOptions
+	Limits             processor.LimitOptions
+}
+
+var (
+	cfg            options
+	tracerProvider *sdktrace.TracerProvider
+	meterProvider  *sdkmetric.MeterProvider
 )
 
-var googleCloudProject = os.Getenv("GOOGLE_CLOUD_PROJECT")
+// resultSender receives the results of calculateExpression.
+var resultSender processor.ResultSender = processor.ResultSenderFunc(sendResult)
+
+// SetResultSender replaces the destination of calculation results, it lets mathtest capture them locally.
+func SetResultSender(sender processor.ResultSender) {
+	resultSender = sender
+}
+
+// errShuttingDown rejects invocations arriving once the instance started shutting down, their messages are
+// redelivered to another instance.
+var errShuttingDown = errors.New("instance is shutting down")
+
+// The Pub/Sub client and result publisher are created by the first invocation that sends a result and shared
+// by every later one. A failure to create them is returned to that invocation, the next one tries again.
+// stopping is set once the instance started shutting down.
+var (
+	publisherMu  sync.Mutex
+	pubsubClient *pubsub.Client
+	publisher    *processor.Publisher
+	stopping     bool
+)
 
 func init() {
-	_, err := otelcommon.InitTracing(context.Background(), otelcommon.Config{
-		ProjectID:      googleCloudProject,
+	if err := env.Parse(&cfg); err != nil {
+		log.WithError(err).Fatal("Failed to parse config")
+	}
+
+	otelCfg := otelcommon.Config{
+		ProjectID:      cfg.GoogleCloudProject,
 		ServiceName:    "math-cloud-function",
 		ServiceVersion: "0.0.1",
-	})
+	}
+
+	var err error
+	tracerProvider, err = otelcommon.InitTracing(context.Background(), otelCfg)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to initialize tracing")
 		os.Exit(1)
 	}
+
+	meterProvider, err = otelcommon.InitMetrics(context.Background(), otelCfg)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to initialize metrics")
+		os.Exit(1)
+	}
+
+	if err := cfg.Publish.Validate(); err != nil {
+		log.WithError(err).Fatal("Invalid result publish settings")
+	}
+	if cfg.GoogleCloudProject == "" {
+		log.Info("GOOGLE_CLOUD_PROJECT is not set, results can only be captured with SetResultSender")
+	}
+
 	functions.CloudEvent("calculateExpression", calculateExpression)
+
+	go flushOnShutdown()
+}
+
+// flushOnShutdown waits for the SIGTERM sent before the instance is shut down and flushes pending results,
+// spans and metrics. functions-framework has no shutdown hook and owns the server, so the process keeps running
+// until the platform kills it at the end of the grace period; invocations arriving meanwhile are rejected
+// before processing and their messages are redelivered.
+func flushOnShutdown() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	<-sig
+	signal.Stop(sig)
+
+	publisherMu.Lock()
+	stopping = true
+	if publisher != nil {
+		publisher.Stop()
+		pubsubClient.Close()
+		pubsubClient, publisher = nil, nil
+	}
+	publisherMu.Unlock()
+
+	if err := tracerProvider.Shutdown(context.Background()); err != nil {
+		log.WithError(err).Error("Failed to shutdown tracing")
+	}
+	if err := meterProvider.Shutdown(context.Background()); err != nil {
+		log.WithError(err).Error("Failed to shutdown metrics")
+	}
 }
 
 // MessagePublishedData contains the full Pub/Sub message
@@ -84,15 +165,6 @@ func spanFromPubsubMessage(ctx context.Context, tracer trace.Tracer, topicID str
 	return tracer.Start(ctx, fmt.Sprintf("%s process", topicID), opts...)
 }
 
-func lazinessFactor(ctx context.Context) {
-	ctx, span := otel.GetTracerProvider().Tracer("math").Start(ctx, "lazinessFactor")
-	defer span.End()
-
-	delay := rand.Intn(5) + 2
-	span.SetAttributes(attribute.Int("laziness", delay))
-	time.Sleep(time.Duration(delay) * time.Second)
-}
-
 // helloPubSub consumes a CloudEvent message and extracts the Pub/Sub message.
 func calculateExpression(ctx context.Context, e event.Event) error {
 	var err error
@@ -101,6 +173,10 @@ func calculateExpression(ctx context.Context, e event.Event) error {
 		return fmt.Errorf("event.DataAs: %v", err)
 	}
 
+	if isStopping() {
+		return errShuttingDown
+	}
+
 	ctx, span := spanFromPubsubMessage(ctx, otelcommon.Tracer(), "math-topic", msg.Message)
 	defer func(err1 *error) {
 		if err != nil {
@@ -111,84 +187,53 @@ func calculateExpression(ctx context.Context, e event.Event) error {
 		span.End()
 	}(&err)
 
-	logger := log.WithContext(ctx)
-
-	var calculation pb.Calculation
-
-	err = json.Unmarshal(msg.Message.Data, &calculation)
-	if err != nil {
-		return fmt.Errorf("json.Unmarshal: %v", err)
-	}
-
-	span.SetAttributes(attribute.String("owner", calculation.GetOwner()), attribute.String("expression", calculation.GetExpression()))
+	err = processor.Process(ctx, msg.Message.Data, resultSender, cfg.Limits)
+	return err
+}
 
-	if calculation.GetOwner() == "slow" {
-		lazinessFactor(ctx)
-	}
+// isStopping reports whether the instance started shutting down.
+func isStopping() bool {
+	publisherMu.Lock()
+	defer publisherMu.Unlock()
+	return stopping
+}
 
-	logger.Infof("Calculation: Owner: %s; Expression: %s; Attributes: %v", calculation.GetOwner(), calculation.GetExpression(), msg.Message.Attributes)
+// resultPublisher returns the instance wide result publisher, creating it on first use.
+func resultPublisher() (*processor.Publisher, error) {
+	publisherMu.Lock()
+	defer publisherMu.Unlock()
 
-	span.AddEvent("evaluating expression")
-	eval := goval.NewEvaluator()
-	result, err := eval.Evaluate(calculation.GetExpression(), nil, nil)
-	if err != nil {
-		log.WithContext(ctx).WithError(err).Error("Failed to evaluate expression")
-		return err
+	if stopping {
+		return nil, errShuttingDown
 	}
-
-	logger.Infof("Result: %f", result)
-	//
-	if intResult, ok := result.(int); ok {
-		calculation.Result = float64(intResult)
-	} else if floatResult, ok := result.(float64); ok {
-		calculation.Result = floatResult
-	} else {
-		calculation.Result = 0
+	if publisher != nil {
+		return publisher, nil
 	}
-
-	err = sendResult(ctx, &calculation)
-	if err != nil {
-		logger.WithError(err).Error("Failed to send result")
-		return err
+	if cfg.GoogleCloudProject == "" {
+		return nil, errors.New("GOOGLE_CLOUD_PROJECT is not set, there is no result topic to publish to")
 	}
 
-	span.AddEvent("result sent")
-
-	return nil
-}
-
-func sendResult(ctx context.Context, calc *pb.Calculation) error {
-	client, err := pubsub.NewClient(ctx, googleCloudProject)
+	// The client outlives the invocation that creates it, so it must not use an invocation context.
+	client, err := pubsub.NewClient(context.Background(), cfg.GoogleCloudProject)
 	if err != nil {
-		return fmt.Errorf("unable to create pubsub client: %w", err)
-	}
-	defer client.Close()
-
-	topic := client.Topic(resultTopic)
-	exists, err := topic.Exists(ctx)
-	if err != nil || !exists {
-		return fmt.Errorf("unable to get topic: %w", err)
+		return nil, fmt.Errorf("unable to create pubsub client: %w", err)
 	}
 
-	respJson, err := json.Marshal(calc)
+	p, err := processor.NewPublisher(client.Topic(cfg.MathResultTopic), cfg.Publish)
 	if err != nil {
-		return fmt.Errorf("unable to marshal calculation: %w", err)
-	}
-
-	msg := &pubsub.Message{
-		Data: respJson,
+		client.Close()
+		return nil, fmt.Errorf("unable to create result publisher: %w", err)
 	}
 
-	// Create a new span
-	ctx, span := otelpubsub.BeforePublishMessage(ctx, otelcommon.Tracer(), resultTopic, msg)
-	defer span.End()
+	pubsubClient, publisher = client, p
+	return publisher, nil
+}
 
-	result, err := topic.Publish(ctx, msg).Get(ctx)
-	otelpubsub.AfterPublishMessage(span, result, err)
+func sendResult(ctx context.Context, calc *pb.Calculation) error {
+	p, err := resultPublisher()
 	if err != nil {
-		return fmt.Errorf("unable to publish message: %w", err)
+		return err
 	}
 
-	return nil
-
+	return p.SendResult(ctx, calc)
 }
@@ -0,0 +1,109 @@
+package math_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	"github.com/kostyay/otel-demo/functions/math/mathtest"
+	"google.golang.org/protobuf/proto"
+)
+
+// runner is shared by every test, functions-framework can only be started once per process.
+var runner *mathtest.Runner
+
+func TestMain(m *testing.M) {
+	var err error
+	runner, err = mathtest.Start()
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestCalculateExpression(t *testing.T) {
+	tests := []struct {
+		name        string
+		calculation *pb.Calculation
+		// data replaces the marshalled calculation as the message data.
+		data    []byte
+		want    *pb.Value
+		wantErr pb.EvaluationErrorKind
+		// rejected messages fail the function and publish nothing.
+		rejected bool
+	}{
+		{
+			name:        "float",
+			calculation: &pb.Calculation{Id: 1, Expression: "1 + 2 * 3"},
+			want:        &pb.Value{Kind: &pb.Value_IntValue{IntValue: 7}},
+		},
+		{
+			name: "variables",
+			calculation: &pb.Calculation{Id: 2, Expression: "x * 2", Variables: map[string]*pb.Value{
+				"x": {Kind: &pb.Value_DoubleValue{DoubleValue: 1.5}},
+			}},
+			want: &pb.Value{Kind: &pb.Value_DoubleValue{DoubleValue: 3}},
+		},
+		{
+			name: "decimal",
+			calculation: &pb.Calculation{
+				Id:         3,
+				Expression: "0.1 + 0.2",
+				Mode:       pb.EvaluationMode_EVALUATION_MODE_DECIMAL,
+				Decimal:    &pb.DecimalOptions{Scale: proto.Uint32(2)},
+			},
+			want: &pb.Value{Kind: &pb.Value_DecimalValue{DecimalValue: "0.30"}},
+		},
+		{
+			name:        "undefined variable",
+			calculation: &pb.Calculation{Id: 4, Expression: "x + 1"},
+			wantErr:     pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_INVALID_EXPRESSION,
+		},
+		{
+			name:        "division by zero",
+			calculation: &pb.Calculation{Id: 5, Expression: "1 / 0"},
+			wantErr:     pb.EvaluationErrorKind_EVALUATION_ERROR_KIND_RUNTIME,
+		},
+		{
+			name:     "malformed message",
+			data:     []byte("not a calculation"),
+			rejected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			runner.Sink.Reset()
+
+			if tt.data != nil {
+				e, err := mathtest.NewMessageEvent(ctx, tt.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = runner.Send(ctx, e)
+				if tt.rejected != (err != nil) {
+					t.Fatalf("Send() = %v, want rejected %v", err, tt.rejected)
+				}
+				if results := runner.Sink.Results(); len(results) != 0 {
+					t.Fatalf("published %v for a rejected message", results)
+				}
+				return
+			}
+
+			got, err := runner.Calculate(ctx, tt.calculation)
+			if err != nil {
+				t.Fatalf("Calculate() = %v", err)
+			}
+			if kind := got.GetError().GetKind(); kind != tt.wantErr {
+				t.Fatalf("error kind = %v (%v), want %v", kind, got.GetError(), tt.wantErr)
+			}
+			if !proto.Equal(got.GetResult(), tt.want) {
+				t.Fatalf("result = %v, want %v", got.GetResult(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Package mathtest runs the math function locally through functions-framework, so it can be
+// exercised end-to-end without Pub/Sub or any other cloud service.
+package mathtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/event"
+	pb "github.com/kostyay/otel-demo/controller/api/calculator/v1"
+	math "github.com/kostyay/otel-demo/functions/math"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	functionName = "calculateExpression"
+	eventType    = "google.cloud.pubsub.topic.v1.messagePublished"
+	eventSource  = "//pubsub.googleapis.com/projects/local/topics/math-topic"
+)
+
+var messageID atomic.Int64
+
+// NewEvent builds the Pub/Sub CloudEvent Eventarc would deliver for calculation.
+// The trace context of ctx is injected into the message attributes, like the controller does when publishing.
+func NewEvent(ctx context.Context, calculation *pb.Calculation) (event.Event, error) {
+	data, err := protojson.Marshal(calculation)
+	if err != nil {
+		return event.Event{}, fmt.Errorf("unable to marshal calculation: %w", err)
+	}
+	return NewMessageEvent(ctx, data)
+}
+
+// NewMessageEvent is NewEvent for a message carrying arbitrary data, e.g. a malformed request.
+func NewMessageEvent(ctx context.Context, data []byte) (event.Event, error) {
+	msg := math.PubSubMessage{
+		Data:       data,
+		Attributes: map[string]string{},
+		ID:         strconv.FormatInt(messageID.Add(1), 10),
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(msg.Attributes))
+
+	e := event.New()
+	e.SetID(msg.ID)
+	e.SetType(eventType)
+	e.SetSource(eventSource)
+	e.SetTime(time.Now())
+	if err := e.SetData(event.ApplicationJSON, math.MessagePublishedData{Message: msg}); err != nil {
+		return event.Event{}, fmt.Errorf("unable to set event data: %w", err)
+	}
+
+	return e, nil
+}
+
+// Sink captures the results the function would publish to the result topic.
+type Sink struct {
+	mu      sync.Mutex
+	results []*pb.Calculation
+}
+
+func (s *Sink) SendResult(_ context.Context, calculation *pb.Calculation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results = append(s.results, proto.Clone(calculation).(*pb.Calculation))
+	return nil
+}
+
+// Results returns every captured result, oldest first.
+func (s *Sink) Results() []*pb.Calculation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*pb.Calculation(nil), s.results...)
+}
+
+// Result returns the latest captured result for the calculation id.
+func (s *Sink) Result(id uint32) (*pb.Calculation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.results) - 1; i >= 0; i-- {
+		if s.results[i].GetId() == id {
+			return s.results[i], true
+		}
+	}
+	return nil, false
+}
+
+func (s *Sink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results = nil
+}
+
+// Runner serves the math function on localhost and routes its results to Sink.
+type Runner struct {
+	URL    string
+	Sink   *Sink
+	client cloudevents.Client
+}
+
+// Start serves the function on a free localhost port. functions-framework has no way to stop its
+// server, so a Runner lives until the process exits; share one across tests.
+func Start() (*Runner, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to find a free port: %w", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := cloudevents.NewClientHTTP()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cloudevents client: %w", err)
+	}
+
+	sink := &Sink{}
+	math.SetResultSender(sink)
+
+	started := make(chan error, 1)
+	go func() {
+		started <- funcframework.Start(port)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		select {
+		case err := <-started:
+			return nil, fmt.Errorf("unable to start functions framework: %w", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("functions framework did not start listening on %s", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return &Runner{
+		URL:    "http://" + addr + "/" + functionName,
+		Sink:   sink,
+		client: client,
+	}, nil
+}
+
+// Send delivers the event to the function and returns the error the function returned, if any.
+func (r *Runner) Send(ctx context.Context, e event.Event) error {
+	result := r.client.Send(cloudevents.ContextWithTarget(ctx, r.URL), e)
+	if !cloudevents.IsACK(result) {
+		return fmt.Errorf("function failed: %w", result)
+	}
+	return nil
+}
+
+// Calculate runs calculation through the function and returns the result it published.
+func (r *Runner) Calculate(ctx context.Context, calculation *pb.Calculation) (*pb.Calculation, error) {
+	e, err := NewEvent(ctx, calculation)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.Send(ctx, e); err != nil {
+		return nil, err
+	}
+
+	result, ok := r.Sink.Result(calculation.GetId())
+	if !ok {
+		return nil, fmt.Errorf("no result published for calculation %d", calculation.GetId())
+	}
+	return result, nil
+}